@@ -1,68 +1,231 @@
 package main
 
 import (
-	"flag"
-	"github.com/99designs/gqlgen/graphql/handler"
-	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
 	"github.com/99designs/gqlgen/graphql/playground"
-	"github.com/gorilla/websocket"
+	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
-	"github.com/spf13/viper"
-	"log"
+	"io/fs"
+	"log/slog"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"post-comments/pkg/config"
 	"post-comments/pkg/database"
 	"post-comments/pkg/generated"
+	"post-comments/pkg/health"
+	"post-comments/pkg/logging"
+	"post-comments/pkg/metrics"
+	"post-comments/pkg/ratelimit"
 	"post-comments/pkg/resolver"
+	"post-comments/pkg/server"
+	"post-comments/pkg/settings"
 	"post-comments/pkg/storage"
+	"post-comments/pkg/storage/cache"
+	"post-comments/pkg/tracing"
+	"post-comments/pkg/viewer"
+	"strings"
+	"syscall"
 )
 
 func main() {
 
-	// Define flag for storage type
-	storageType := flag.String("storage", "in_memory", "type of storage to use (postgres or in_memory)")
-	flag.Parse()
+	// .env is optional, variables already set in the environment win
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fatal("error loading env variables", err)
+	}
 
-	if err := initConfig(); err != nil {
-		log.Fatalf("error initializing config: %s", err.Error())
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		fatal("error loading config", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal("invalid config", err)
+	}
+
+	if len(args) > 0 {
+		if err := runCommand(cfg, args); err != nil {
+			fatal("error running command", err)
+		}
+		return
 	}
 
-	if err := godotenv.Load(); err != nil {
-		log.Fatalf("error loading env variables: %s", err.Error())
+	if err := logging.Setup(cfg.Logging); err != nil {
+		fatal("error initializing logging", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Init(ctx, cfg.Tracing)
+	if err != nil {
+		fatal("error initializing tracing", err)
 	}
 
 	var store storage.Storage
-	if *storageType == "postgres" {
-		cfg := database.LoadDBConfig()
-		connect, err := database.NewDB(cfg)
+	var connect *sqlx.DB
+	var replicas []*sqlx.DB
+	var durable *storage.DurableStorage
+	switch cfg.Storage.Type {
+	case config.StoragePostgres:
+		connect, err = database.NewDB(ctx, cfg.DB)
+		if err != nil {
+			fatal("failed to initialize db", err)
+		}
+		if cfg.DB.Migrate {
+			if err := database.Migrate(ctx, connect); err != nil {
+				fatal("failed to migrate db", err)
+			}
+		}
+		metrics.RegisterDB(connect, "postgres")
+
+		replicaConfigs, err := cfg.DB.ReplicaConfigs()
+		if err != nil {
+			fatal("invalid replicas", err)
+		}
+		for i, replicaConfig := range replicaConfigs {
+			replica, err := database.NewDB(ctx, replicaConfig)
+			if err != nil {
+				fatal("failed to initialize replica "+cfg.DB.Replicas[i], err)
+			}
+			metrics.RegisterDB(replica, fmt.Sprintf("postgres_replica_%d", i))
+			replicas = append(replicas, replica)
+		}
+		// sessions are the signed-in users, or the client addresses of
+		// anonymous requests, as for rate limiting
+		store = storage.NewReplicatedPostgresStorage(connect, replicas, storage.ReplicaConfig{
+			Pin:     cfg.DB.ReplicaPin,
+			Session: ratelimit.Key,
+		})
+	case config.StorageSQLite:
+		connect, err = database.NewSQLiteDB(ctx, cfg.SQLite)
+		if err != nil {
+			fatal("failed to open sqlite db", err)
+		}
+		if cfg.SQLite.Migrate {
+			if err := database.Migrate(ctx, connect); err != nil {
+				fatal("failed to migrate db", err)
+			}
+		}
+		metrics.RegisterDB(connect, "sqlite")
+		store = storage.NewSQLiteStorage(connect)
+	default:
+		if cfg.Storage.InMemory.Dir == "" {
+			store = storage.NewInMemoryStorage()
+			break
+		}
+		durable, err = storage.OpenDurableStorage(cfg.Storage.InMemory)
 		if err != nil {
-			log.Fatalf("failed to initialize db: %s", err.Error())
+			fatal("failed to restore in-memory storage", err)
+		}
+		store = durable
+	}
+	store = metrics.NewStorage(store)
+	if cfg.Cache.Enabled {
+		var bus cache.Bus
+		if cfg.Storage.Type == config.StoragePostgres {
+			// other instances write to the same database
+			bus = cache.NewPostgresBus(connect, cfg.DB.DSN())
 		}
-		defer connect.Close()
-		store = storage.NewPostgresStorage(connect)
-	} else {
-		store = storage.NewInMemoryStorage()
+		cached := cache.New(store, cfg.Cache, bus)
+		go func() {
+			if err := cached.Listen(ctx); err != nil {
+				slog.Error("cache invalidation listener stopped", slog.String("error", err.Error()))
+			}
+		}()
+		metrics.RegisterCache(cached.Stats)
+		store = cached
 	}
+	store = tracing.NewStorage(store)
 
-	r := resolver.NewResolver(store)
+	r := resolver.NewResolverWithBroker(store, cfg.Subscriptions)
+	r.Settings = settings.New(cfg.Limits)
+	// only the limits are applied live, everything else needs a restart
+	config.Watch(func(c *config.Config) {
+		r.Settings.Set(c.Limits)
+		slog.Info("config reloaded, limits updated")
+	})
+	metrics.RegisterBroker("comments", r.Comments.Stats)
+	metrics.RegisterBroker("reactions", r.Reactions.Stats)
+	metrics.RegisterUserBroker("notifications", r.Notifications.Stats)
 	// Create a GraphQL server
-	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: r}))
-	srv.AddTransport(&transport.Websocket{
-		Upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
-		},
+	srv := server.NewGraphQLServer(generated.NewExecutableSchema(generated.Config{Resolvers: r}), cfg.Transport)
+	srv.Use(&extension.ComplexityLimit{Func: func(ctx context.Context, rc *graphql.OperationContext) int {
+		if limit := r.Settings.Get().MaxComplexity; limit > 0 {
+			return limit
+		}
+		return math.MaxInt
+	}})
+	srv.Use(metrics.Tracer{})
+	srv.Use(tracing.Tracer{})
+	srv.Use(logging.NewTracer(cfg.Logging))
+	limiter := server.NewConnLimiter(cfg.Transport.MaxConnsPerIP)
+
+	probes := health.New(cfg.Health.Timeout)
+	if connect != nil {
+		probes.AddCheck("db", func(ctx context.Context) (any, error) {
+			return nil, connect.PingContext(ctx)
+		})
+		probes.AddCheck("migrations", func(ctx context.Context) (any, error) {
+			return nil, database.CheckMigrations(ctx, connect)
+		})
+	}
+	probes.AddCheck("subscriptions", func(ctx context.Context) (any, error) {
+		stats := r.Comments.Stats()
+		if stats.Closed {
+			return stats, fmt.Errorf("broker is closed")
+		}
+		return stats, nil
 	})
+
+	mux := http.NewServeMux()
 	// Create a playground for testing
-	http.Handle("/", playground.Handler("GraphQL Playground", "/query"))
-	http.Handle("/query", srv)
+	mux.Handle("/", playground.Handler("GraphQL Playground", "/query"))
+	mux.Handle("/query", logging.Middleware(limiter.Middleware(ratelimit.Middleware(viewer.Middleware(tracing.Middleware(srv))))))
+	mux.Handle("/healthz", probes.Liveness())
+	mux.Handle("/readyz", probes.Readiness())
+	mux.Handle("/metrics", metrics.Handler())
 
-	log.Printf("connect to http://localhost:%s/ for GraphQL playground", viper.GetString("port"))
-	log.Fatal(http.ListenAndServe(viper.GetString("port"), nil))
+	httpServer := server.New(cfg.Port, mux, cfg.ShutdownDelay, cfg.DrainTimeout)
+	httpServer.OnShutdown(probes.SetShuttingDown)
+	// completing the subscriptions lets SSE requests finish and sends
+	// "complete" to WebSocket clients before their connections are closed
+	httpServer.BeforeDrain(r.Comments.Close)
+	httpServer.BeforeDrain(r.Reactions.Close)
+	httpServer.BeforeDrain(r.Notifications.Close)
+	if connect != nil {
+		httpServer.AddCloser("db", connect.Close)
+	}
+	if durable != nil {
+		httpServer.AddCloser("in-memory storage", durable.Close)
+	}
+	for i, replica := range replicas {
+		httpServer.AddCloser("db replica "+cfg.DB.Replicas[i], replica.Close)
+	}
+	httpServer.AddCloser("tracing", func() error {
+		return shutdownTracing(context.Background())
+	})
+
+	slog.Info("connect to http://localhost" + cfg.Port + "/ for GraphQL playground")
+	if err := httpServer.Run(ctx); err != nil {
+		fatal("error running server", err)
+	}
+	slog.Info("server stopped")
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.String("error", err.Error()))
+	os.Exit(1)
 }
 
-func initConfig() error {
-	viper.AddConfigPath("configs")
-	viper.SetConfigName("config")
-	return viper.ReadInConfig()
+func runCommand(cfg *config.Config, args []string) error {
+	if len(args) == 2 && args[0] == "config" && args[1] == "print" {
+		return cfg.Print(os.Stdout)
+	}
+	return fmt.Errorf("unknown command %q, the only command is \"config print\"", strings.Join(args, " "))
 }
@@ -2,6 +2,13 @@
 
 package post_comments
 
+import (
+	"fmt"
+	"io"
+	"post-comments/pkg/model"
+	"strconv"
+)
+
 type NewComment struct {
 	PostID   int    `json:"postId"`
 	ParentID *int   `json:"parentId,omitempty"`
@@ -11,4 +18,76 @@ type NewComment struct {
 type NewPost struct {
 	Title string `json:"title"`
 	Body  string `json:"body"`
+	// One of the configured filter profiles, the default one if null.
+	FilterProfile *string `json:"filterProfile,omitempty"`
+	// Letters, digits and '-', lower-cased. Duplicates are dropped and the
+	// limits set the number of tags a post can have.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// A reaction added or removed, with the new counts of its post or comment.
+type ReactionEvent struct {
+	PostID         int                    `json:"postId"`
+	CommentID      *int                   `json:"commentId,omitempty"`
+	User           string                 `json:"user"`
+	Emoji          string                 `json:"emoji"`
+	Added          bool                   `json:"added"`
+	ReactionCounts []*model.ReactionCount `json:"reactionCounts"`
+}
+
+// Reacts to a post, or to one of its comments when commentId is set.
+type ReactionInput struct {
+	PostID    int    `json:"postId"`
+	CommentID *int   `json:"commentId,omitempty"`
+	Emoji     string `json:"emoji"`
+}
+
+// Changes the fields that are set, tags replacing all the tags of the post.
+type UpdatePost struct {
+	Title *string  `json:"title,omitempty"`
+	Body  *string  `json:"body,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+type VoteValue string
+
+const (
+	VoteValueUp   VoteValue = "UP"
+	VoteValueDown VoteValue = "DOWN"
+	VoteValueNone VoteValue = "NONE"
+)
+
+var AllVoteValue = []VoteValue{
+	VoteValueUp,
+	VoteValueDown,
+	VoteValueNone,
+}
+
+func (e VoteValue) IsValid() bool {
+	switch e {
+	case VoteValueUp, VoteValueDown, VoteValueNone:
+		return true
+	}
+	return false
+}
+
+func (e VoteValue) String() string {
+	return string(e)
+}
+
+func (e *VoteValue) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = VoteValue(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid VoteValue", str)
+	}
+	return nil
+}
+
+func (e VoteValue) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
 }
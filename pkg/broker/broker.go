@@ -0,0 +1,240 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// Policy decides what happens when a subscriber's buffer is full.
+type Policy int
+
+const (
+	// DropNewest discards the event being published.
+	DropNewest Policy = iota
+	// DropOldest discards the oldest buffered event to make room for the new one.
+	DropOldest
+	// Disconnect closes the subscription with ErrSlowConsumer.
+	Disconnect
+)
+
+var ErrSlowConsumer = errors.New("subscriber is too slow, disconnected")
+
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "", "drop_newest":
+		return DropNewest, nil
+	case "drop_oldest":
+		return DropOldest, nil
+	case "disconnect":
+		return Disconnect, nil
+	}
+	return 0, fmt.Errorf("unknown subscription policy %q", s)
+}
+
+func (p *Policy) UnmarshalText(text []byte) error {
+	policy, err := ParsePolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+func (p Policy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p Policy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop_oldest"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "drop_newest"
+}
+
+type Config struct {
+	BufferSize int    `mapstructure:"buffer_size"`
+	Policy     Policy `mapstructure:"policy"`
+	// OnDisconnect is called before the channel of a subscriber evicted by the
+	// Disconnect policy is closed.
+	OnDisconnect func(ctx context.Context, topic any, err error) `mapstructure:"-"`
+}
+
+type subscriber[K comparable, T any] struct {
+	ctx     context.Context
+	topic   K
+	ch      chan T
+	mu      sync.Mutex
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// Broker fans out events published on a topic, such as a post ID, to every
+// subscriber of that topic without ever blocking the publisher.
+type Broker[K comparable, T any] struct {
+	cfg          Config
+	mu           sync.RWMutex
+	topics       map[K]map[*subscriber[K, T]]struct{}
+	closed       bool
+	dropped      atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+type Stats[K comparable] struct {
+	Closed       bool      `json:"closed"`
+	Subscribers  int       `json:"subscribers"`
+	Topics       map[K]int `json:"topics"`
+	Dropped      uint64    `json:"dropped"`
+	Disconnected uint64    `json:"disconnected"`
+}
+
+func New[K comparable, T any](cfg Config) *Broker[K, T] {
+	if cfg.BufferSize < 1 {
+		cfg.BufferSize = 1
+	}
+	return &Broker[K, T]{
+		cfg:    cfg,
+		topics: make(map[K]map[*subscriber[K, T]]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber on topic. The returned channel is closed
+// when ctx is done or the subscriber is disconnected for being too slow.
+func (b *Broker[K, T]) Subscribe(ctx context.Context, topic K) <-chan T {
+	sub := &subscriber[K, T]{
+		ctx:   ctx,
+		topic: topic,
+		ch:    make(chan T, b.cfg.BufferSize),
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(sub.ch)
+		return sub.ch
+	}
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[*subscriber[K, T]]struct{})
+	}
+	b.topics[topic][sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.unsubscribe(sub)
+	}()
+
+	return sub.ch
+}
+
+func (b *Broker[K, T]) Publish(topic K, event T) {
+	b.mu.RLock()
+	var evicted []*subscriber[K, T]
+	for sub := range b.topics[topic] {
+		if !b.deliver(sub, event) {
+			evicted = append(evicted, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range evicted {
+		b.disconnected.Add(1)
+		if b.cfg.OnDisconnect != nil {
+			b.cfg.OnDisconnect(sub.ctx, sub.topic, ErrSlowConsumer)
+		}
+		b.unsubscribe(sub)
+	}
+}
+
+// deliver returns false when the subscriber has to be disconnected.
+func (b *Broker[K, T]) deliver(sub *subscriber[K, T], event T) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return true
+	}
+
+	select {
+	case sub.ch <- event:
+		return true
+	default:
+	}
+
+	switch b.cfg.Policy {
+	case DropOldest:
+		select {
+		case <-sub.ch:
+		default:
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	case Disconnect:
+		return false
+	}
+	sub.dropped.Add(1)
+	b.dropped.Add(1)
+	return true
+}
+
+func (b *Broker[K, T]) unsubscribe(sub *subscriber[K, T]) {
+	b.mu.Lock()
+	if subs, ok := b.topics[sub.topic]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(b.topics, sub.topic)
+		}
+	}
+	b.mu.Unlock()
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+		if dropped := sub.dropped.Load(); dropped > 0 {
+			slog.Warn("subscriber missed events", slog.Any("topic", sub.topic), slog.Uint64("dropped", dropped))
+		}
+	}
+}
+
+// Close completes every active subscription and makes new ones complete
+// immediately. It is used to drain subscribers on shutdown.
+func (b *Broker[K, T]) Close() {
+	b.mu.Lock()
+	b.closed = true
+	var subs []*subscriber[K, T]
+	for _, topic := range b.topics {
+		for sub := range topic {
+			subs = append(subs, sub)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		b.unsubscribe(sub)
+	}
+}
+
+func (b *Broker[K, T]) Stats() Stats[K] {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	stats := Stats[K]{
+		Closed:       b.closed,
+		Topics:       make(map[K]int, len(b.topics)),
+		Dropped:      b.dropped.Load(),
+		Disconnected: b.disconnected.Load(),
+	}
+	for topic, subs := range b.topics {
+		stats.Topics[topic] = len(subs)
+		stats.Subscribers += len(subs)
+	}
+	return stats
+}
@@ -0,0 +1,134 @@
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishDelivers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := New[int, int](Config{BufferSize: 2})
+	ch := b.Subscribe(ctx, 1)
+	other := b.Subscribe(ctx, 2)
+
+	b.Publish(1, 10)
+
+	assert.Equal(t, 10, <-ch)
+	assert.Len(t, other, 0)
+	assert.Equal(t, map[int]int{1: 1, 2: 1}, b.Stats().Topics)
+}
+
+func TestStringTopics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := New[string, int](Config{})
+	alice := b.Subscribe(ctx, "alice")
+	bob := b.Subscribe(ctx, "bob")
+
+	b.Publish("bob", 1)
+
+	assert.Len(t, alice, 0)
+	assert.Equal(t, 1, <-bob)
+	assert.Equal(t, map[string]int{"alice": 1, "bob": 1}, b.Stats().Topics)
+}
+
+func TestDropNewest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := New[int, int](Config{BufferSize: 2, Policy: DropNewest})
+	ch := b.Subscribe(ctx, 1)
+	for i := 1; i <= 4; i++ {
+		b.Publish(1, i)
+	}
+
+	assert.Equal(t, 1, <-ch)
+	assert.Equal(t, 2, <-ch)
+	assert.Equal(t, uint64(2), b.Stats().Dropped)
+}
+
+func TestDropOldest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := New[int, int](Config{BufferSize: 2, Policy: DropOldest})
+	ch := b.Subscribe(ctx, 1)
+	for i := 1; i <= 4; i++ {
+		b.Publish(1, i)
+	}
+
+	assert.Equal(t, 3, <-ch)
+	assert.Equal(t, 4, <-ch)
+	assert.Equal(t, uint64(2), b.Stats().Dropped)
+}
+
+func TestDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var reported error
+	b := New[int, int](Config{
+		BufferSize: 1,
+		Policy:     Disconnect,
+		OnDisconnect: func(ctx context.Context, topic any, err error) {
+			reported = err
+		},
+	})
+	ch := b.Subscribe(ctx, 1)
+	b.Publish(1, 1)
+	b.Publish(1, 2)
+
+	assert.Equal(t, 1, <-ch)
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.ErrorIs(t, reported, ErrSlowConsumer)
+	assert.Equal(t, uint64(1), b.Stats().Disconnected)
+	assert.Equal(t, 0, b.Stats().Subscribers)
+
+	// publishing to an evicted subscriber must not panic
+	b.Publish(1, 3)
+}
+
+func TestUnsubscribeOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b := New[int, int](Config{})
+	ch := b.Subscribe(ctx, 1)
+	cancel()
+
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Equal(t, 0, b.Stats().Subscribers)
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{DropNewest, DropOldest, Disconnect} {
+		parsed, err := ParsePolicy(p.String())
+		assert.NoError(t, err)
+		assert.Equal(t, p, parsed)
+	}
+
+	_, err := ParsePolicy("block")
+	assert.Error(t, err)
+}
+
+func TestClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := New[int, int](Config{})
+	ch := b.Subscribe(ctx, 1)
+	b.Close()
+
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	_, ok = <-b.Subscribe(ctx, 1)
+	assert.False(t, ok)
+	assert.Equal(t, 0, b.Stats().Subscribers)
+}
@@ -0,0 +1,391 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"log/slog"
+	"os"
+	"post-comments/pkg/broker"
+	"post-comments/pkg/database"
+	"post-comments/pkg/logging"
+	"post-comments/pkg/server"
+	"post-comments/pkg/settings"
+	"post-comments/pkg/storage"
+	"post-comments/pkg/storage/cache"
+	"post-comments/pkg/tracing"
+	"strings"
+	"time"
+)
+
+const (
+	envPrefix         = "POSTCOMMENTS"
+	defaultConfigFile = "configs/config.yml"
+
+	StorageInMemory = "in_memory"
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+)
+
+type Config struct {
+	Port          string                 `mapstructure:"port"`
+	ShutdownDelay time.Duration          `mapstructure:"shutdown_delay"`
+	DrainTimeout  time.Duration          `mapstructure:"drain_timeout"`
+	Storage       StorageConfig          `mapstructure:"storage"`
+	DB            database.DBConfig      `mapstructure:"db"`
+	SQLite        database.SQLiteConfig  `mapstructure:"sqlite"`
+	Health        HealthConfig           `mapstructure:"health"`
+	Logging       logging.Config         `mapstructure:"logging"`
+	Tracing       tracing.Config         `mapstructure:"tracing"`
+	Transport     server.TransportConfig `mapstructure:"transport"`
+	Subscriptions broker.Config          `mapstructure:"subscriptions"`
+	Cache         cache.Config           `mapstructure:"cache"`
+	// Limits are applied while the server runs, see Watch.
+	Limits settings.Limits `mapstructure:"limits"`
+}
+
+type StorageConfig struct {
+	// Type is one of "in_memory", "postgres" or "sqlite".
+	Type string `mapstructure:"type"`
+	// InMemory persists the in_memory storage when its Dir is set.
+	InMemory storage.DurableConfig `mapstructure:"in_memory"`
+}
+
+type HealthConfig struct {
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+func setDefaults() {
+	viper.SetDefault("port", ":8080")
+	viper.SetDefault("shutdown_delay", "5s")
+	viper.SetDefault("drain_timeout", "15s")
+	viper.SetDefault("storage.type", StorageInMemory)
+	viper.SetDefault("storage.in_memory.dir", "")
+	viper.SetDefault("storage.in_memory.fsync", "always")
+	viper.SetDefault("storage.in_memory.fsync_interval", "1s")
+	viper.SetDefault("storage.in_memory.snapshot_interval", "5m")
+	viper.SetDefault("storage.in_memory.snapshot_every", 10000)
+
+	viper.SetDefault("db.host", "localhost")
+	viper.SetDefault("db.port", 5432)
+	viper.SetDefault("db.user", "")
+	viper.SetDefault("db.password", "")
+	viper.SetDefault("db.password_file", "")
+	viper.SetDefault("db.dbname", "postgres")
+	viper.SetDefault("db.sslmode", "disable")
+	viper.SetDefault("db.migrate", true)
+	viper.SetDefault("db.max_open_conns", 25)
+	viper.SetDefault("db.max_idle_conns", 25)
+	viper.SetDefault("db.conn_max_lifetime", "30m")
+	viper.SetDefault("db.conn_max_idle_time", "5m")
+	viper.SetDefault("db.statement_timeout", "10s")
+	viper.SetDefault("db.connect_timeout", "30s")
+	viper.SetDefault("db.retry_interval", "250ms")
+	viper.SetDefault("db.retry_max_interval", "5s")
+	viper.SetDefault("db.replicas", []string{})
+	viper.SetDefault("db.replica_pin", "5s")
+
+	viper.SetDefault("sqlite.path", "post-comments.db")
+	viper.SetDefault("sqlite.busy_timeout", "5s")
+	viper.SetDefault("sqlite.migrate", true)
+
+	viper.SetDefault("health.timeout", "2s")
+
+	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("logging.slow_operation", "500ms")
+	viper.SetDefault("logging.redact_variables", []string{"password", "token", "secret"})
+
+	viper.SetDefault("tracing.exporter", "none")
+	viper.SetDefault("tracing.endpoint", "localhost:4318")
+	viper.SetDefault("tracing.insecure", false)
+	viper.SetDefault("tracing.service_name", "post-comments")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+
+	viper.SetDefault("transport.allowed_origins", []string{})
+	viper.SetDefault("transport.keepalive", "10s")
+	viper.SetDefault("transport.max_conns_per_ip", 0)
+
+	viper.SetDefault("subscriptions.buffer_size", 16)
+	viper.SetDefault("subscriptions.policy", "drop_newest")
+
+	viper.SetDefault("cache.enabled", false)
+	viper.SetDefault("cache.size", 10000)
+	viper.SetDefault("cache.post_ttl", "1m")
+	viper.SetDefault("cache.list_ttl", "10s")
+
+	limits := settings.DefaultLimits()
+	viper.SetDefault("limits.comment_max_len", limits.CommentMaxLen)
+	viper.SetDefault("limits.post_title_max_len", limits.PostTitleMaxLen)
+	viper.SetDefault("limits.post_body_max_len", limits.PostBodyMaxLen)
+	viper.SetDefault("limits.max_thread_depth", limits.MaxThreadDepth)
+	viper.SetDefault("limits.max_complexity", limits.MaxComplexity)
+	viper.SetDefault("limits.max_mentions", limits.MaxMentions)
+	viper.SetDefault("limits.max_tags", limits.MaxTags)
+	viper.SetDefault("limits.rate_limit.per_minute", limits.RateLimit.PerMinute)
+	viper.SetDefault("limits.rate_limit.burst", limits.RateLimit.Burst)
+	viper.SetDefault("limits.reactions", limits.Reactions)
+	viper.SetDefault("limits.ranking.hot_decay", limits.Ranking.HotDecay)
+	viper.SetDefault("limits.spam.threshold", limits.Spam.Threshold)
+	viper.SetDefault("limits.spam.min_training", limits.Spam.MinTraining)
+	viper.SetDefault("limits.moderators", []string{})
+}
+
+// Load builds the configuration from, in order of precedence, command line
+// flags, POSTCOMMENTS_* environment variables (POSTCOMMENTS_DB_HOST sets
+// db.host), the config file and the defaults. It returns the arguments left
+// after the flags.
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("post-comments", flag.ContinueOnError)
+	configFile := fs.String("config", defaultConfigFile, "path to the config file")
+	fs.String("storage", "", "type of storage to use (postgres, sqlite or in_memory)")
+	fs.String("sqlite-path", "", "database file for sqlite storage")
+	fs.String("port", "", "address to listen on, e.g. :8080")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	setDefaults()
+
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	// unprefixed names kept for existing .env files and secret mounts
+	if err := viper.BindEnv("db.password", envPrefix+"_DB_PASSWORD", "DB_PASSWORD"); err != nil {
+		return nil, nil, err
+	}
+	if err := viper.BindEnv("db.password_file", envPrefix+"_DB_PASSWORD_FILE", "DB_PASSWORD_FILE"); err != nil {
+		return nil, nil, err
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	viper.SetConfigFile(*configFile)
+	if err := viper.ReadInConfig(); err != nil {
+		// the default file is optional, e.g. when everything comes from env
+		if explicit["config"] || !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("reading config file %s: %w", *configFile, err)
+		}
+	}
+
+	if explicit["storage"] {
+		viper.Set("storage.type", fs.Lookup("storage").Value.String())
+	}
+	if explicit["sqlite-path"] {
+		viper.Set("sqlite.path", fs.Lookup("sqlite-path").Value.String())
+	}
+	if explicit["port"] {
+		viper.Set("port", fs.Lookup("port").Value.String())
+	}
+
+	cfg, err := decode()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func decode() (*Config, error) {
+	cfg := &Config{}
+	err := viper.Unmarshal(cfg, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		mapstructure.TextUnmarshallerHookFunc(),
+	)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding config: %w", err)
+	}
+
+	if cfg.DB.PasswordFile != "" {
+		password, err := os.ReadFile(cfg.DB.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("db.password_file: %w", err)
+		}
+		cfg.DB.Password = strings.TrimRight(string(password), "\r\n")
+	}
+	return cfg, nil
+}
+
+// Watch calls onChange with the reloaded configuration every time the
+// config file changes. Changes that don't pass validation are logged and
+// ignored.
+func Watch(onChange func(*Config)) {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		cfg, err := decode()
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			slog.Error("ignoring config change", slog.String("file", e.Name), slog.String("error", err.Error()))
+			return
+		}
+		onChange(cfg)
+	})
+	viper.WatchConfig()
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Port == "" {
+		invalid("port", "must not be empty")
+	}
+
+	switch c.Storage.Type {
+	case StorageInMemory:
+		durable := c.Storage.InMemory
+		if durable.Dir != "" {
+			if durable.Fsync == storage.FsyncInterval && durable.FsyncInterval <= 0 {
+				invalid("storage.in_memory.fsync_interval", "must be positive with the interval fsync policy")
+			}
+			if durable.SnapshotInterval < 0 {
+				invalid("storage.in_memory.snapshot_interval", "must not be negative, 0 disables it")
+			}
+			if durable.SnapshotEvery < 0 {
+				invalid("storage.in_memory.snapshot_every", "must not be negative, 0 disables it")
+			}
+		}
+	case StoragePostgres:
+		if c.DB.Host == "" {
+			invalid("db.host", "required for postgres storage")
+		}
+		if c.DB.User == "" {
+			invalid("db.user", "required for postgres storage")
+		}
+		if c.DB.DB == "" {
+			invalid("db.dbname", "required for postgres storage")
+		}
+		if c.DB.MaxOpenConns < 0 {
+			invalid("db.max_open_conns", "must not be negative, 0 means unlimited")
+		}
+		if c.DB.MaxIdleConns < 0 {
+			invalid("db.max_idle_conns", "must not be negative")
+		}
+		if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+			invalid("db.max_idle_conns", "must not exceed db.max_open_conns")
+		}
+		if c.DB.StatementTimeout < 0 {
+			invalid("db.statement_timeout", "must not be negative, 0 disables it")
+		}
+		if c.DB.ConnectTimeout < 0 || c.DB.RetryInterval < 0 || c.DB.RetryMaxInterval < 0 {
+			invalid("db.connect_timeout", "connect_timeout, retry_interval and retry_max_interval must not be negative")
+		}
+		if _, err := c.DB.ReplicaConfigs(); err != nil {
+			invalid("db.replicas", "%s", err)
+		}
+		if c.DB.ReplicaPin < 0 {
+			invalid("db.replica_pin", "must not be negative, 0 disables read-your-writes")
+		}
+	case StorageSQLite:
+		if c.SQLite.Path == "" {
+			invalid("sqlite.path", "required for sqlite storage")
+		}
+		if c.SQLite.BusyTimeout < 0 {
+			invalid("sqlite.busy_timeout", "must not be negative")
+		}
+	default:
+		invalid("storage.type", "must be %q, %q or %q, got %q", StorageInMemory, StoragePostgres, StorageSQLite, c.Storage.Type)
+	}
+
+	if c.ShutdownDelay < 0 {
+		invalid("shutdown_delay", "must not be negative")
+	}
+	if c.DrainTimeout <= 0 {
+		invalid("drain_timeout", "must be positive")
+	}
+	if c.Health.Timeout <= 0 {
+		invalid("health.timeout", "must be positive")
+	}
+
+	if _, err := logging.ParseLevel(c.Logging.Level); err != nil {
+		invalid("logging.level", "%s", err)
+	}
+	if c.Logging.SlowOperation < 0 {
+		invalid("logging.slow_operation", "must not be negative")
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "none", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint == "" {
+			invalid("tracing.endpoint", "required for the otlp exporter")
+		}
+	default:
+		invalid("tracing.exporter", "must be otlp, stdout or none, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sample_ratio", "must be between 0 and 1")
+	}
+
+	if c.Transport.KeepAlive < 0 {
+		invalid("transport.keepalive", "must not be negative")
+	}
+	if c.Transport.MaxConnsPerIP < 0 {
+		invalid("transport.max_conns_per_ip", "must not be negative")
+	}
+
+	if c.Subscriptions.BufferSize < 1 {
+		invalid("subscriptions.buffer_size", "must be at least 1")
+	}
+
+	if c.Cache.Enabled {
+		if c.Cache.Size < 1 {
+			invalid("cache.size", "must be at least 1")
+		}
+		if c.Cache.PostTTL <= 0 || c.Cache.ListTTL <= 0 {
+			invalid("cache.post_ttl", "post_ttl and list_ttl must be positive")
+		}
+	}
+
+	for _, limit := range []struct {
+		key   string
+		value int
+	}{
+		{"limits.comment_max_len", c.Limits.CommentMaxLen},
+		{"limits.post_title_max_len", c.Limits.PostTitleMaxLen},
+		{"limits.post_body_max_len", c.Limits.PostBodyMaxLen},
+		{"limits.max_thread_depth", c.Limits.MaxThreadDepth},
+		{"limits.max_complexity", c.Limits.MaxComplexity},
+		{"limits.max_mentions", c.Limits.MaxMentions},
+		{"limits.max_tags", c.Limits.MaxTags},
+		{"limits.rate_limit.per_minute", c.Limits.RateLimit.PerMinute},
+		{"limits.rate_limit.burst", c.Limits.RateLimit.Burst},
+		{"limits.spam.min_training", c.Limits.Spam.MinTraining},
+	} {
+		if limit.value < 0 {
+			invalid(limit.key, "must not be negative, 0 disables the limit")
+		}
+	}
+	seen := map[string]bool{}
+	for _, emoji := range c.Limits.Reactions {
+		if strings.TrimSpace(emoji) == "" || seen[emoji] {
+			invalid("limits.reactions", "must not have empty or duplicate emojis, got %q", emoji)
+		}
+		seen[emoji] = true
+	}
+	if c.Limits.Ranking.HotDecay <= 0 {
+		invalid("limits.ranking.hot_decay", "must be positive")
+	}
+	if c.Limits.Spam.Threshold < 0 || c.Limits.Spam.Threshold > 1 {
+		invalid("limits.spam.threshold", "must be between 0 and 1, 0 disabling the classifier")
+	}
+	if err := c.Limits.Filters.Validate(); err != nil {
+		invalid("limits.filters", "%v", err)
+	}
+	for _, moderator := range c.Limits.Moderators {
+		if strings.TrimSpace(moderator) == "" {
+			invalid("limits.moderators", "must not have empty names")
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"post-comments/pkg/broker"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	viper.Reset()
+	path := writeConfig(t, `
+port: ":7000"
+storage:
+  type: "postgres"
+db:
+  user: "file-user"
+subscriptions:
+  policy: "drop_oldest"
+drain_timeout: "3s"
+`)
+	t.Setenv("POSTCOMMENTS_DB_USER", "env-user")
+	t.Setenv("POSTCOMMENTS_PORT", ":7100")
+
+	cfg, args, err := Load([]string{"-config", path, "-port", ":7200", "config", "print"})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"config", "print"}, args)
+	assert.Equal(t, ":7200", cfg.Port)
+	assert.Equal(t, "env-user", cfg.DB.User)
+	assert.Equal(t, StoragePostgres, cfg.Storage.Type)
+	assert.Equal(t, broker.DropOldest, cfg.Subscriptions.Policy)
+	assert.Equal(t, 3*time.Second, cfg.DrainTimeout)
+	assert.Equal(t, 5*time.Second, cfg.ShutdownDelay)
+	assert.Equal(t, 16, cfg.Subscriptions.BufferSize)
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoadPasswordFile(t *testing.T) {
+	viper.Reset()
+	secret := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(secret, []byte("s3cret\n"), 0o600))
+	t.Setenv("DB_PASSWORD_FILE", secret)
+
+	cfg, _, err := Load([]string{"-config", writeConfig(t, "")})
+
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", cfg.DB.Password)
+}
+
+func TestLoadMissingExplicitFile(t *testing.T) {
+	viper.Reset()
+
+	_, _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.yml")})
+
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	viper.Reset()
+	cfg, _, err := Load([]string{"-config", writeConfig(t, ""), "-storage", "mongo"})
+	require.NoError(t, err)
+	cfg.Subscriptions.BufferSize = 0
+
+	err = cfg.Validate()
+
+	assert.ErrorContains(t, err, "storage.type")
+	assert.ErrorContains(t, err, "subscriptions.buffer_size")
+}
+
+func TestLoadFilters(t *testing.T) {
+	viper.Reset()
+	path := writeConfig(t, `
+limits:
+  filters:
+    default_profile: "Strict"
+    profiles:
+      Strict:
+        links:
+          max: 1
+          action: "flag"
+        duplicates:
+          window: "5m"
+          action: "reject"
+`)
+
+	cfg, _, err := Load([]string{"-config", path})
+
+	require.NoError(t, err)
+	require.True(t, cfg.Limits.Filters.HasProfile("strict"))
+	profile := cfg.Limits.Filters.Profiles["strict"]
+	require.NotNil(t, profile.Duplicates)
+	assert.Equal(t, 5*time.Minute, profile.Duplicates.Window)
+	assert.Nil(t, profile.BannedWords)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Limits.Filters.DefaultProfile = "missing"
+	assert.ErrorContains(t, cfg.Validate(), "limits.filters")
+}
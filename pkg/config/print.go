@@ -0,0 +1,61 @@
+package config
+
+import (
+	"encoding"
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v3"
+	"io"
+	"reflect"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// Print writes the effective configuration as YAML with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	safe := *c
+	if safe.DB.Password != "" {
+		safe.DB.Password = redacted
+	}
+
+	out := map[string]any{}
+	if err := mapstructure.Decode(safe, &out); err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(printable(out)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// printable converts the values yaml would print as plain numbers, like
+// durations and enums, to their text form.
+func printable(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			v[key] = printable(value)
+		}
+		return v
+	case time.Duration:
+		return v.String()
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err != nil {
+			return v
+		}
+		return string(text)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Struct {
+		out := map[string]any{}
+		if err := mapstructure.Decode(v, &out); err == nil {
+			return printable(out)
+		}
+	}
+	return v
+}
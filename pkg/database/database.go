@@ -1,41 +1,149 @@
 package database
 
 import (
+	"context"
 	"fmt"
+	"github.com/XSAM/otelsql"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
-	"github.com/spf13/viper"
-	"os"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
 )
 
+const driverName = "pgx"
+
 type DBConfig struct {
-	Host     string
-	Port     uint16
-	User     string
-	Password string
-	DB       string
-	SSLMode  string
+	Host     string `mapstructure:"host"`
+	Port     uint16 `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	// PasswordFile, when set, is read to get Password.
+	PasswordFile string `mapstructure:"password_file"`
+	DB           string `mapstructure:"dbname"`
+	SSLMode      string `mapstructure:"sslmode"`
+	// Migrate applies pending migrations on startup.
+	Migrate bool `mapstructure:"migrate"`
+
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `mapstructure:"conn_max_idle_time"`
+	// StatementTimeout makes Postgres cancel any statement running longer.
+	StatementTimeout time.Duration `mapstructure:"statement_timeout"`
+
+	// ConnectTimeout is how long NewDB keeps retrying to reach the database,
+	// waiting RetryInterval after the first failure and doubling the wait up
+	// to RetryMaxInterval.
+	ConnectTimeout   time.Duration `mapstructure:"connect_timeout"`
+	RetryInterval    time.Duration `mapstructure:"retry_interval"`
+	RetryMaxInterval time.Duration `mapstructure:"retry_max_interval"`
+
+	// Replicas are "host:port" addresses of read replicas, reached with the
+	// same credentials as the primary.
+	Replicas []string `mapstructure:"replicas"`
+	// ReplicaPin is how long a session reads from the primary after writing,
+	// so that it sees its own writes despite replication lag.
+	ReplicaPin time.Duration `mapstructure:"replica_pin"`
 }
 
-func LoadDBConfig() DBConfig {
-	return DBConfig{
-		Host:     viper.GetString("db.host"),
-		Port:     viper.GetUint16("db.port"),
-		User:     viper.GetString("db.user"),
-		Password: os.Getenv("DB_PASSWORD"),
-		DB:       viper.GetString("db.dbname"),
-		SSLMode:  viper.GetString("db.sslmode"),
+// ReplicaConfigs returns the configs to connect to each replica.
+func (cfg DBConfig) ReplicaConfigs() ([]DBConfig, error) {
+	replicas := make([]DBConfig, 0, len(cfg.Replicas))
+	for _, addr := range cfg.Replicas {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("replica %q: %w", addr, err)
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("replica %q: bad port: %w", addr, err)
+		}
+		replica := cfg
+		replica.Host = host
+		replica.Port = uint16(p)
+		replica.Replicas = nil
+		replicas = append(replicas, replica)
 	}
+	return replicas, nil
 }
 
-func NewDB(cfg DBConfig) (*sqlx.DB, error) {
-	dataSource := fmt.Sprintf("user=%s password=%s host=%s port=%d dbname=%s sslmode=%s",
-		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.DB, cfg.SSLMode)
+// DSN returns the connection URL, usable with both database/sql and pgx.
+func (cfg DBConfig) DSN() string {
+	query := url.Values{}
+	if cfg.SSLMode != "" {
+		query.Set("sslmode", cfg.SSLMode)
+	}
+	if cfg.StatementTimeout > 0 {
+		query.Set("statement_timeout", strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10))
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(int(cfg.Port))),
+		Path:     "/" + cfg.DB,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
 
-	connect, err := sqlx.Connect("postgres", dataSource)
+// NewDB opens the connection pool, retrying with exponential backoff until
+// the database answers, cfg.ConnectTimeout passes or ctx is done.
+func NewDB(ctx context.Context, cfg DBConfig) (*sqlx.DB, error) {
+	// every statement gets a span carrying the query text
+	db, err := otelsql.Open(driverName, cfg.DSN(),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	connect := sqlx.NewDb(db, driverName)
+	if err := ping(ctx, connect, cfg); err != nil {
+		connect.Close()
+		return nil, err
+	}
 
 	return connect, nil
 }
+
+func ping(ctx context.Context, db *sqlx.DB, cfg DBConfig) error {
+	deadline := time.Now().Add(cfg.ConnectTimeout)
+	wait := cfg.RetryInterval
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if wait <= 0 || time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("database unreachable after %d attempts: %w", attempt, err)
+		}
+
+		slog.Warn("database unreachable, retrying",
+			slog.Int("attempt", attempt),
+			slog.Duration("wait", wait),
+			slog.String("error", err.Error()),
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if cfg.RetryMaxInterval > 0 && wait > cfg.RetryMaxInterval {
+			wait = cfg.RetryMaxInterval
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDSN(t *testing.T) {
+	cfg := DBConfig{
+		Host:             "db.local",
+		Port:             5433,
+		User:             "admin",
+		Password:         "p@ss word/",
+		DB:               "comments",
+		SSLMode:          "disable",
+		StatementTimeout: 2 * time.Second,
+	}
+
+	parsed, err := pgx.ParseConfig(cfg.DSN())
+
+	require.NoError(t, err)
+	assert.Equal(t, "db.local", parsed.Host)
+	assert.Equal(t, uint16(5433), parsed.Port)
+	assert.Equal(t, "admin", parsed.User)
+	assert.Equal(t, "p@ss word/", parsed.Password)
+	assert.Equal(t, "comments", parsed.Database)
+	assert.Equal(t, "2000", parsed.RuntimeParams["statement_timeout"])
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every dialect has its own directory with the same migration versions.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// DialectOf tells the dialect from the driver db was opened with.
+func DialectOf(db *sqlx.DB) (Dialect, error) {
+	switch db.DriverName() {
+	case driverName:
+		return Postgres, nil
+	case sqliteDriverName:
+		return SQLite, nil
+	}
+	return "", fmt.Errorf("no migrations for driver %q", db.DriverName())
+}
+
+// migrationLock is the pg_advisory_lock key that serializes migrations run by
+// several instances starting at the same time.
+const migrationLock = 7231
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations(dialect Dialect) ([]migration, error) {
+	dir := "migrations/" + string(dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<description>.sql", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %w", name, err)
+		}
+		body, err := fs.ReadFile(migrationFiles, dir+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// LatestVersion returns the version of the newest embedded migration, which
+// is the same for every dialect.
+func LatestVersion() (int, error) {
+	migrations, err := loadMigrations(Postgres)
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].version, nil
+}
+
+// SchemaVersion returns the version of the last migration applied to db.
+func SchemaVersion(ctx context.Context, db *sqlx.DB) (int, error) {
+	var version int
+	err := db.GetContext(ctx, &version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+	return version, err
+}
+
+// CheckMigrations returns an error unless every embedded migration is applied.
+func CheckMigrations(ctx context.Context, db *sqlx.DB) error {
+	latest, err := LatestVersion()
+	if err != nil {
+		return err
+	}
+	current, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current < latest {
+		return fmt.Errorf("schema version %d, want %d", current, latest)
+	}
+	return nil
+}
+
+// Migrate applies the embedded migrations of db's dialect that db hasn't
+// seen yet, each in its own transaction.
+func Migrate(ctx context.Context, db *sqlx.DB) error {
+	dialect, err := DialectOf(db)
+	if err != nil {
+		return err
+	}
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	createTable := `
+  CREATE TABLE IF NOT EXISTS schema_migrations (
+   version INT PRIMARY KEY,
+   applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+  )`
+	if dialect == SQLite {
+		// SQLite locks the whole file for writes, no other lock needed
+		createTable = `
+  CREATE TABLE IF NOT EXISTS schema_migrations (
+   version INTEGER PRIMARY KEY,
+   applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+  )`
+	} else {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock)
+	}
+
+	if _, err := conn.ExecContext(ctx, createTable); err != nil {
+		return err
+	}
+
+	var current int
+	if err := conn.GetContext(ctx, &current, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := conn.BeginTxx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, db.Rebind("INSERT INTO schema_migrations (version) VALUES (?)"), m.version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialectsShareVersions(t *testing.T) {
+	versions := func(dialect Dialect) []int {
+		migrations, err := loadMigrations(dialect)
+		require.NoError(t, err)
+		var versions []int
+		for _, m := range migrations {
+			versions = append(versions, m.version)
+		}
+		return versions
+	}
+
+	assert.NotEmpty(t, versions(Postgres))
+	assert.Equal(t, versions(Postgres), versions(SQLite))
+}
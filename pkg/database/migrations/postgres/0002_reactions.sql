@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS reactions (
+    post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id INT REFERENCES comments(id) ON DELETE CASCADE,
+    user_name TEXT NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- reactions to the post itself have no comment_id
+CREATE UNIQUE INDEX IF NOT EXISTS reactions_unique
+    ON reactions (post_id, COALESCE(comment_id, 0), emoji, user_name);
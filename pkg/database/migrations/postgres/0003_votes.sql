@@ -0,0 +1,24 @@
+ALTER TABLE comments
+    ADD COLUMN IF NOT EXISTS upvotes INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS downvotes INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS comment_votes (
+    comment_id INT NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    user_name TEXT NOT NULL,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    PRIMARY KEY (comment_id, user_name)
+);
+
+-- keyset pagination of the top-level comments of a post, one index per
+-- order; HOT depends on a configured decay and has no index
+CREATE INDEX IF NOT EXISTS comments_roots_by_id
+    ON comments (post_id, id) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS comments_roots_top
+    ON comments (post_id, (upvotes - downvotes) DESC, id) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS comments_roots_controversial
+    ON comments (post_id, (CASE WHEN upvotes > 0 AND downvotes > 0
+        THEN power((upvotes + downvotes)::float8, least(upvotes, downvotes)::float8 / greatest(upvotes, downvotes))
+        ELSE 0 END) DESC, id) WHERE parent_id IS NULL;
+
+-- walking down reply threads
+CREATE INDEX IF NOT EXISTS comments_parent_id ON comments (parent_id);
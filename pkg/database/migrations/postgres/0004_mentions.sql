@@ -0,0 +1,27 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS author TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS author TEXT NOT NULL DEFAULT '';
+
+-- resolving mentions to authors regardless of case
+CREATE INDEX IF NOT EXISTS posts_author_lower ON posts (lower(author));
+CREATE INDEX IF NOT EXISTS comments_author_lower ON comments (lower(author));
+
+CREATE TABLE IF NOT EXISTS comment_mentions (
+    comment_id INT NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    -- order of appearance in the body
+    position INT NOT NULL,
+    user_name TEXT NOT NULL,
+    PRIMARY KEY (comment_id, position)
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    user_name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    post_id INT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id INT REFERENCES comments(id) ON DELETE CASCADE,
+    actor TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    read BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user ON notifications (user_name, id DESC);
@@ -0,0 +1 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS filter_profile TEXT NOT NULL DEFAULT '';
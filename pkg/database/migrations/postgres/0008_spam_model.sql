@@ -0,0 +1,12 @@
+-- comments the spam classifier learned from, per class
+CREATE TABLE IF NOT EXISTS spam_classes (
+    spam BOOLEAN PRIMARY KEY,
+    documents INT NOT NULL
+);
+
+-- comments of each class every token appeared in
+CREATE TABLE IF NOT EXISTS spam_tokens (
+    token TEXT PRIMARY KEY,
+    spam INT NOT NULL DEFAULT 0,
+    ham INT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS reactions (
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    user_name TEXT NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- reactions to the post itself have no comment_id
+CREATE UNIQUE INDEX IF NOT EXISTS reactions_unique
+    ON reactions (post_id, COALESCE(comment_id, 0), emoji, user_name);
@@ -0,0 +1,27 @@
+ALTER TABLE posts ADD COLUMN author TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN author TEXT NOT NULL DEFAULT '';
+
+-- resolving mentions to authors regardless of case
+CREATE INDEX IF NOT EXISTS posts_author_lower ON posts (lower(author));
+CREATE INDEX IF NOT EXISTS comments_author_lower ON comments (lower(author));
+
+CREATE TABLE IF NOT EXISTS comment_mentions (
+    comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    -- order of appearance in the body
+    position INTEGER NOT NULL,
+    user_name TEXT NOT NULL,
+    PRIMARY KEY (comment_id, position)
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    actor TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    read BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user ON notifications (user_name, id DESC);
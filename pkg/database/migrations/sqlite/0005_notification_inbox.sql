@@ -0,0 +1,3 @@
+-- listing unread notifications
+CREATE INDEX IF NOT EXISTS notifications_user_unread
+    ON notifications (user_name, id DESC) WHERE NOT read;
@@ -0,0 +1,15 @@
+ALTER TABLE comments ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- open reports; resolving them deletes them
+CREATE TABLE IF NOT EXISTS comment_reports (
+    comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    reporter TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (comment_id, reporter)
+);
+
+CREATE TABLE IF NOT EXISTS banned_users (
+    user_name TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL
+);
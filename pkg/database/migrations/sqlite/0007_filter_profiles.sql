@@ -0,0 +1 @@
+ALTER TABLE posts ADD COLUMN filter_profile TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const sqliteDriverName = "sqlite"
+
+func init() {
+	sqlx.BindDriver(sqliteDriverName, sqlx.QUESTION)
+}
+
+type SQLiteConfig struct {
+	// Path is the database file, created if missing.
+	Path string `mapstructure:"path"`
+	// BusyTimeout is how long a write waits for another one to finish.
+	BusyTimeout time.Duration `mapstructure:"busy_timeout"`
+	// Migrate applies pending migrations on startup.
+	Migrate bool `mapstructure:"migrate"`
+}
+
+// DSN returns the file URI with the pragmas every connection needs.
+func (cfg SQLiteConfig) DSN() string {
+	query := url.Values{}
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "busy_timeout("+strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10)+")")
+	// transactions take the write lock when they begin, so a read followed
+	// by a write in one transaction sees no concurrent change
+	query.Set("_txlock", "immediate")
+	return "file:" + cfg.Path + "?" + query.Encode()
+}
+
+// NewSQLiteDB opens the database file.
+func NewSQLiteDB(ctx context.Context, cfg SQLiteConfig) (*sqlx.DB, error) {
+	db, err := sqlx.Open(sqliteDriverName, cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,61 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readyz(t *testing.T, h *Health) (int, Report) {
+	rec := httptest.NewRecorder()
+	h.Readiness().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report Report
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestReady(t *testing.T) {
+	h := New(0)
+	h.AddCheck("db", func(ctx context.Context) (any, error) {
+		return nil, nil
+	})
+
+	code, report := readyz(t, h)
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", report.Status)
+	assert.Equal(t, "ok", report.Checks["db"].Status)
+}
+
+func TestNotReady(t *testing.T) {
+	h := New(0)
+	h.AddCheck("db", func(ctx context.Context) (any, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	code, report := readyz(t, h)
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not_ready", report.Status)
+	assert.Equal(t, "connection refused", report.Checks["db"].Error)
+}
+
+func TestShuttingDown(t *testing.T) {
+	h := New(0)
+	h.SetShuttingDown()
+
+	code, report := readyz(t, h)
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "shutting_down", report.Status)
+
+	rec := httptest.NewRecorder()
+	h.Liveness().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,131 @@
+package logging
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// Tracer is a gqlgen handler extension that logs every query and mutation,
+// and every subscription once it ends.
+type Tracer struct {
+	SlowOperation time.Duration
+	redact        map[string]bool
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+} = &Tracer{}
+
+func NewTracer(cfg Config) *Tracer {
+	t := &Tracer{
+		SlowOperation: cfg.SlowOperation,
+		redact:        make(map[string]bool, len(cfg.RedactVariables)),
+	}
+	for _, name := range cfg.RedactVariables {
+		t.redact[strings.ToLower(name)] = true
+	}
+	return t
+}
+
+func (t *Tracer) ExtensionName() string {
+	return "Logging"
+}
+
+func (t *Tracer) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (t *Tracer) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	oc := graphql.GetOperationContext(ctx)
+	resp := next(ctx)
+	if oc.Operation == nil {
+		return resp
+	}
+
+	isSubscription := oc.Operation.Operation == ast.Subscription
+	switch {
+	case isSubscription && resp == nil:
+		t.log(ctx, oc, "subscription completed", nil)
+	case isSubscription && len(resp.Errors) > 0:
+		t.log(ctx, oc, "subscription event failed", resp)
+	case !isSubscription:
+		t.log(ctx, oc, "graphql operation", resp)
+	}
+	return resp
+}
+
+func (t *Tracer) log(ctx context.Context, oc *graphql.OperationContext, msg string, resp *graphql.Response) {
+	duration := time.Since(oc.Stats.OperationStart)
+	level := slog.LevelInfo
+	if t.SlowOperation > 0 && duration > t.SlowOperation && oc.Operation.Operation != ast.Subscription {
+		level = slog.LevelWarn
+		msg = "slow graphql operation"
+	}
+
+	name := oc.Operation.Name
+	if name == "" {
+		name = "anonymous"
+	}
+	attrs := []slog.Attr{
+		slog.String("operation", name),
+		slog.String("type", string(oc.Operation.Operation)),
+		slog.Any("variables", t.redactVariables(oc.Variables)),
+		slog.Duration("duration", duration),
+	}
+	if resp != nil && len(resp.Errors) > 0 {
+		if level < slog.LevelWarn {
+			level = slog.LevelWarn
+		}
+		codes := make([]string, 0, len(resp.Errors))
+		messages := make([]string, 0, len(resp.Errors))
+		for _, err := range resp.Errors {
+			code, _ := err.Extensions["code"].(string)
+			if code == "" {
+				code = "UNKNOWN"
+			}
+			codes = append(codes, code)
+			messages = append(messages, err.Message)
+		}
+		attrs = append(attrs, slog.Any("error_codes", codes), slog.Any("errors", messages))
+	}
+
+	slog.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func (t *Tracer) redactVariables(vars map[string]any) map[string]any {
+	if len(vars) == 0 {
+		return nil
+	}
+	out := make(map[string]any, len(vars))
+	for name, value := range vars {
+		out[name] = t.redactValue(name, value)
+	}
+	return out
+}
+
+func (t *Tracer) redactValue(name string, value any) any {
+	if t.redact["*"] || t.redact[strings.ToLower(name)] {
+		return redacted
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		return t.redactVariables(v)
+	case []any:
+		out := make([]any, len(v))
+		for i := range v {
+			out[i] = t.redactValue(name, v[i])
+		}
+		return out
+	}
+	return value
+}
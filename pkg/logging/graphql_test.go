@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactVariables(t *testing.T) {
+	tracer := NewTracer(Config{RedactVariables: []string{"Password"}})
+
+	vars := tracer.redactVariables(map[string]any{
+		"title": "hello",
+		"input": map[string]any{
+			"login":    "user",
+			"password": "secret",
+		},
+	})
+
+	assert.Equal(t, map[string]any{
+		"title": "hello",
+		"input": map[string]any{
+			"login":    "user",
+			"password": redacted,
+		},
+	}, vars)
+}
+
+func TestRedactAllVariables(t *testing.T) {
+	tracer := NewTracer(Config{RedactVariables: []string{"*"}})
+
+	vars := tracer.redactVariables(map[string]any{"title": "hello"})
+
+	assert.Equal(t, map[string]any{"title": redacted}, vars)
+}
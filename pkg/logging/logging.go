@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+type Config struct {
+	Level string `mapstructure:"level"`
+	// SlowOperation is the duration above which an operation is logged as a warning.
+	SlowOperation time.Duration `mapstructure:"slow_operation"`
+	// RedactVariables lists GraphQL variable names whose values are never
+	// logged, "*" redacts every variable.
+	RedactVariables []string `mapstructure:"redact_variables"`
+}
+
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return level, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("bad log level %q", s)
+	}
+	return level, nil
+}
+
+// Setup makes a JSON slog.Logger the default logger. Messages written with
+// the log package end up there too. Records logged with the context of a
+// request, as by slog.WarnContext(ctx, ...), are tagged with its ID.
+func Setup(cfg Config) error {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(NewHandler(handler)))
+	return nil
+}
+
+type ctxKey struct{}
+
+// WithRequestID returns a context whose records are tagged with id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// handler adds the request ID of the context to the records of next.
+type handler struct {
+	next slog.Handler
+}
+
+// NewHandler wraps next so that records logged with the context of a
+// request carry its request_id.
+func NewHandler(next slog.Handler) slog.Handler {
+	return handler{next: next}
+}
+
+func (h handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return handler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h handler) WithGroup(name string) slog.Handler {
+	return handler{next: h.next.WithGroup(name)}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,69 @@
+package markdown
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCacheSize is how many renderings a Cache keeps by default.
+const DefaultCacheSize = 10000
+
+type cached struct {
+	revision string
+	html     string
+}
+
+// Cache keeps the HTML of the most recently rendered revisions of bodies.
+// A revision names a version of a body, so that an edited body, being
+// another revision, gets rendered again.
+type Cache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:    max(size, 1),
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Render returns the HTML of src, the body at revision, rendering it unless
+// it is cached.
+func (c *Cache) Render(revision, src string) string {
+	if src == "" {
+		return ""
+	}
+	c.mu.Lock()
+	if el, ok := c.entries[revision]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*cached).html
+	}
+	c.mu.Unlock()
+
+	rendered := Render(src)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[revision]; !ok {
+		c.entries[revision] = c.order.PushFront(&cached{revision: revision, html: rendered})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cached).revision)
+		}
+	}
+	return rendered
+}
+
+// Len returns the number of cached renderings.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
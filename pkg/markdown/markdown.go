@@ -0,0 +1,365 @@
+// Package markdown renders the restricted Markdown of post and comment
+// bodies into HTML safe to insert in a page.
+//
+// The dialect has paragraphs, whose line breaks are kept, block quotes,
+// bulleted and numbered lists, fenced code blocks, and inline **strong**,
+// *emphasis* (or _emphasis_), `code`, [links](https://example.com) and bare
+// http(s) URLs. Everything else, HTML included, is text: the output is
+// built from escaped text and a fixed set of tags, links only go to http,
+// https and mailto URLs, and carry rel="nofollow".
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxQuoteDepth is how deep block quotes nest, deeper '>' being text.
+	maxQuoteDepth = 4
+	// maxNesting is how deep emphasis nests, deeper delimiters being text.
+	maxNesting = 4
+)
+
+// Render returns the HTML of the Markdown src.
+func Render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	var out strings.Builder
+	renderBlocks(&out, strings.Split(src, "\n"), 0)
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+// quoted returns line without its '>' marker, if it has one.
+func quoted(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimLeft(line, " "), ">")
+	if !ok {
+		return "", false
+	}
+	return strings.TrimPrefix(rest, " "), true
+}
+
+// listItem returns the text of line if it starts a list item, whether the
+// list is numbered, and the number.
+func listItem(line string) (text string, ordered bool, number int, ok bool) {
+	line = strings.TrimLeft(line, " ")
+	if len(line) >= 2 && strings.ContainsRune("-*+", rune(line[0])) && line[1] == ' ' {
+		return strings.TrimSpace(line[2:]), false, 0, true
+	}
+	digits := 0
+	for digits < len(line) && digits < 9 && line[digits] >= '0' && line[digits] <= '9' {
+		digits++
+	}
+	if digits == 0 || digits+1 >= len(line) || (line[digits] != '.' && line[digits] != ')') || line[digits+1] != ' ' {
+		return "", false, 0, false
+	}
+	number, _ = strconv.Atoi(line[:digits])
+	return strings.TrimSpace(line[digits+2:]), true, number, true
+}
+
+// startsBlock reports whether line interrupts a paragraph or a list item.
+func startsBlock(line string, depth int) bool {
+	if isFence(line) {
+		return true
+	}
+	if _, ok := quoted(line); ok && depth < maxQuoteDepth {
+		return true
+	}
+	_, _, _, ok := listItem(line)
+	return ok
+}
+
+func renderBlocks(out *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case isFence(line):
+			i++
+			var code []string
+			for i < len(lines) && !isFence(lines[i]) {
+				code = append(code, lines[i])
+				i++
+			}
+			i++ // the closing fence, if any
+			out.WriteString("<pre><code>")
+			out.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			out.WriteString("</code></pre>\n")
+
+		case depth < maxQuoteDepth && strings.HasPrefix(strings.TrimLeft(line, " "), ">"):
+			var inner []string
+			for ; i < len(lines); i++ {
+				text, ok := quoted(lines[i])
+				if !ok {
+					break
+				}
+				inner = append(inner, text)
+			}
+			out.WriteString("<blockquote>\n")
+			renderBlocks(out, inner, depth+1)
+			out.WriteString("</blockquote>\n")
+
+		default:
+			if _, ordered, number, ok := listItem(line); ok {
+				i = renderList(out, lines, i, ordered, number, depth)
+				continue
+			}
+			var para []string
+			for ; i < len(lines) && !isBlank(lines[i]) && (len(para) == 0 || !startsBlock(lines[i], depth)); i++ {
+				para = append(para, strings.TrimSpace(lines[i]))
+			}
+			out.WriteString("<p>")
+			renderLines(out, para)
+			out.WriteString("</p>\n")
+		}
+	}
+}
+
+// renderList renders the list starting at lines[i] and returns the index of
+// the line after it. Lines not starting an item continue the previous one,
+// and the list ends at a blank line or an item of the other kind.
+func renderList(out *strings.Builder, lines []string, i int, ordered bool, number, depth int) int {
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	out.WriteString("<" + tag)
+	if ordered && number != 1 {
+		out.WriteString(` start="` + strconv.Itoa(number) + `"`)
+	}
+	out.WriteString(">\n")
+	for i < len(lines) {
+		text, itemOrdered, _, ok := listItem(lines[i])
+		if !ok || itemOrdered != ordered {
+			break
+		}
+		item := []string{text}
+		for i++; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i], depth); i++ {
+			item = append(item, strings.TrimSpace(lines[i]))
+		}
+		out.WriteString("<li>")
+		renderLines(out, item)
+		out.WriteString("</li>\n")
+	}
+	out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+func renderLines(out *strings.Builder, lines []string) {
+	for i, line := range lines {
+		if i > 0 {
+			out.WriteString("<br>\n")
+		}
+		renderInline(out, line, false, 0)
+	}
+}
+
+// safeURL returns the escaped href of raw, "" unless it is an absolute
+// http(s) or mailto URL.
+func safeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return ""
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return ""
+		}
+	default:
+		return ""
+	}
+	return html.EscapeString(u.String())
+}
+
+func writeLink(out *strings.Builder, href string) {
+	out.WriteString(`<a href="` + href + `" rel="nofollow">`)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// wordBefore and wordAt report whether a letter, a digit or '_' ends s[:i]
+// or starts s[i:].
+func wordBefore(s string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return i > 0 && isWordRune(r)
+}
+
+func wordAt(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return i < len(s) && isWordRune(r)
+}
+
+// opens reports whether the delimiter at s[i] can open emphasis: it must
+// precede a non-space and, for '_', not be inside a word, so that
+// snake_case stays as it is.
+func opens(s string, i int, delim string) bool {
+	start := i + len(delim)
+	if start >= len(s) || s[start] == ' ' {
+		return false
+	}
+	return delim != "_" || !wordBefore(s, i)
+}
+
+// closer returns the index of the delimiter closing the one opening at
+// s[i], or -1. A closer follows a non-space and, for '_', ends a word.
+func closer(s string, i int, delim string) int {
+	for j := i + len(delim) + 1; j+len(delim) <= len(s); j++ {
+		if !strings.HasPrefix(s[j:], delim) || s[j-1] == ' ' {
+			continue
+		}
+		if delim == "_" && wordAt(s, j+1) {
+			continue
+		}
+		if delim == "*" && strings.HasPrefix(s[j:], "**") {
+			// the closer of a **strong** inside, or a stray **
+			j++
+			continue
+		}
+		return j
+	}
+	return -1
+}
+
+// escapable are the characters a backslash makes literal.
+const escapable = "\\`*_[]()<>#+-.!~|"
+
+// autolinkEnd are the characters trimmed from the end of bare URLs, more
+// often punctuation of the sentence than part of the URL.
+const autolinkEnd = ".,;:!?)\"'"
+
+// renderInline renders s, nested in nesting emphasis and in a link if
+// inLink.
+func renderInline(out *strings.Builder, s string, inLink bool, nesting int) {
+	// unclosed remembers the delimiters no closer was found for from some
+	// index, and so from any later one
+	unclosed := map[string]bool{}
+	// badLink is the index of a "](" no link can end at, or -1
+	badLink := -1
+	text := 0
+	flush := func(i int) {
+		out.WriteString(html.EscapeString(s[text:i]))
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(escapable, s[i+1]) >= 0:
+			flush(i)
+			out.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			text = i
+
+		case c == '`':
+			end := strings.IndexByte(s[i+1:], '`')
+			if end < 0 {
+				i++
+				continue
+			}
+			flush(i)
+			out.WriteString("<code>")
+			out.WriteString(html.EscapeString(s[i+1 : i+1+end]))
+			out.WriteString("</code>")
+			i += end + 2
+			text = i
+
+		case (c == '*' || c == '_') && nesting < maxNesting:
+			delim := s[i : i+1]
+			tag := "em"
+			if strings.HasPrefix(s[i:], "**") {
+				delim, tag = "**", "strong"
+			}
+			end := -1
+			if opens(s, i, delim) && !unclosed[delim] {
+				if end = closer(s, i, delim); end < 0 {
+					unclosed[delim] = true
+				}
+			}
+			if end < 0 {
+				i += len(delim)
+				continue
+			}
+			flush(i)
+			out.WriteString("<" + tag + ">")
+			renderInline(out, s[i+len(delim):end], inLink, nesting+1)
+			out.WriteString("</" + tag + ">")
+			i = end + len(delim)
+			text = i
+
+		case c == '[' && !inLink && i > badLink:
+			label, rest, ok := strings.Cut(s[i+1:], "](")
+			if !ok {
+				badLink = len(s)
+				continue
+			}
+			if j := strings.LastIndexAny(label, "[]"); j >= 0 {
+				// the label can only start after the last bracket in it
+				i += 1 + j
+				continue
+			}
+			target, _, ok := strings.Cut(rest, ")")
+			if !ok || strings.ContainsAny(target, " \t") {
+				// every '[' before it would end with the same target
+				badLink = i + 1 + len(label)
+				continue
+			}
+			flush(i)
+			if href := safeURL(target); href != "" {
+				writeLink(out, href)
+				renderInline(out, label, true, nesting)
+				out.WriteString("</a>")
+			} else {
+				renderInline(out, label, true, nesting)
+			}
+			i += 1 + len(label) + 2 + len(target) + 1
+			text = i
+
+		case (c == 'h' || c == 'H') && !inLink && !wordBefore(s, i) &&
+			(hasPrefixFold(s[i:], "http://") || hasPrefixFold(s[i:], "https://")):
+			end := i + strings.IndexFunc(s[i:], func(r rune) bool { return unicode.IsSpace(r) || r == '<' })
+			if end < i {
+				end = len(s)
+			}
+			for end > i && strings.IndexByte(autolinkEnd, s[end-1]) >= 0 {
+				end--
+			}
+			href := safeURL(s[i:end])
+			if href == "" {
+				i++
+				continue
+			}
+			flush(i)
+			writeLink(out, href)
+			out.WriteString(html.EscapeString(s[i:end]))
+			out.WriteString("</a>")
+			i = end
+			text = i
+
+		default:
+			i++
+		}
+	}
+	flush(len(s))
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,81 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"", ""},
+		{"hello", "<p>hello</p>"},
+		{"one\ntwo\n\nthree", "<p>one<br>\ntwo</p>\n<p>three</p>"},
+		{"**bold** and *em* and _em_ and `x < y`", "<p><strong>bold</strong> and <em>em</em> and <em>em</em> and <code>x &lt; y</code></p>"},
+		{"**bold *and em* too**", "<p><strong>bold <em>and em</em> too</strong></p>"},
+		{"snake_case_name, 2 * 3 * 4, a*b", "<p>snake_case_name, 2 * 3 * 4, a*b</p>"},
+		{`\*not em\*`, "<p>*not em*</p>"},
+		{"- one\n- two\ncontinued\n\n3. three\n4. four", "<ul>\n<li>one</li>\n<li>two<br>\ncontinued</li>\n</ul>\n<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>"},
+		{"> quoted\n> > twice\n\nafter", "<blockquote>\n<p>quoted</p>\n<blockquote>\n<p>twice</p>\n</blockquote>\n</blockquote>\n<p>after</p>"},
+		{"```go\nif a < b {\n\n}\n```\ntext", "<pre><code>if a &lt; b {\n\n}</code></pre>\n<p>text</p>"},
+		{"[site](https://example.com/a?b=1&c=2)", `<p><a href="https://example.com/a?b=1&amp;c=2" rel="nofollow">site</a></p>`},
+		{"see https://example.com/x.", `<p>see <a href="https://example.com/x" rel="nofollow">https://example.com/x</a>.</p>`},
+		{"[**mail**](mailto:a@example.com)", `<p><a href="mailto:a@example.com" rel="nofollow"><strong>mail</strong></a></p>`},
+		{"[https://a.example](https://b.example)", `<p><a href="https://b.example" rel="nofollow">https://a.example</a></p>`},
+	} {
+		assert.Equal(t, tc.want, Render(tc.src), tc.src)
+	}
+}
+
+func TestRenderSanitizes(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{`<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>"},
+		{"[click](javascript:alert(1))", "<p>click)</p>"},
+		{"[click](javascript:alert)", "<p>click</p>"},
+		{"[click](data:text/html;base64,PHNjcmlwdD4=)", "<p>click</p>"},
+		{"[click](/relative)", "<p>click</p>"},
+		{`[x](https://example.com/"onmouseover="alert(1))`, `<p><a href="https://example.com/%22onmouseover=%22alert%281" rel="nofollow">x</a>)</p>`},
+		{"`<b>`", "<p><code>&lt;b&gt;</code></p>"},
+	} {
+		got := Render(tc.src)
+		assert.Equal(t, tc.want, got, tc.src)
+		assert.NotContains(t, got, "<script")
+		assert.NotContains(t, got, "javascript:")
+	}
+}
+
+func TestRenderPathological(t *testing.T) {
+	// delimiters left unclosed or nested deep must not make rendering slow
+	for _, src := range []string{
+		strings.Repeat("*a ", 20000),
+		strings.Repeat("_", 50000),
+		strings.Repeat("*", 50000),
+		strings.Repeat("[", 50000),
+		strings.Repeat("> ", 50000),
+	} {
+		assert.NotEmpty(t, Render(src))
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(2)
+
+	assert.Equal(t, "<p><em>a</em></p>", c.Render("post:1:1", "*a*"))
+	// the revision decides, the body is not compared again
+	assert.Equal(t, "<p><em>a</em></p>", c.Render("post:1:1", "*b*"))
+	assert.Equal(t, "<p><em>b</em></p>", c.Render("post:1:2", "*b*"))
+	assert.Equal(t, "", c.Render("post:2:1", ""))
+	assert.Equal(t, 2, c.Len())
+
+	for i := 0; i < 5; i++ {
+		c.Render(fmt.Sprintf("comment:%d:1", i), "x")
+	}
+	assert.Equal(t, 2, c.Len())
+}
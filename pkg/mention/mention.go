@@ -0,0 +1,48 @@
+// Package mention finds the @username mentions in comment bodies.
+package mention
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxLen is the longest name a mention can have, longer ones being ignored.
+const MaxLen = 64
+
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' ||
+		b == '_' || b == '.' || b == '-'
+}
+
+// Parse returns the names mentioned in body, each once, in order of
+// appearance and spelled as first written. A mention is an @ followed by
+// ASCII letters, digits, '_', '.' and '-', not ending with '.' or '-', and not
+// preceded by a letter, a digit or another @, so e-mail addresses aren't
+// taken for mentions.
+func Parse(body string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for i := 0; i < len(body); i++ {
+		if body[i] != '@' {
+			continue
+		}
+		if prev, _ := utf8.DecodeLastRuneInString(body[:i]); i > 0 && (prev == '@' || prev == '_' || unicode.IsLetter(prev) || unicode.IsDigit(prev)) {
+			continue
+		}
+		end := i + 1
+		for end < len(body) && isNameByte(body[end]) {
+			end++
+		}
+		name := strings.TrimRight(body[i+1:end], ".-")
+		i = end - 1
+		if name == "" || len(name) > MaxLen {
+			continue
+		}
+		if key := strings.ToLower(name); !seen[key] {
+			seen[key] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,30 @@
+package mention
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"no mentions", nil},
+		{"@alice", []string{"alice"}},
+		{"hi @alice and @Bob_2!", []string{"alice", "Bob_2"}},
+		{"(@alice), @alice, @ALICE", []string{"alice"}},
+		{"ask @john.doe.", []string{"john.doe"}},
+		{"@a-b- @-", []string{"a-b"}},
+		{"mail me at bob@example.com", nil},
+		{"@@alice é@bob", nil},
+		{"émoji 🎉@carol", []string{"carol"}},
+		{"@" + strings.Repeat("a", MaxLen+1), nil},
+		{"@" + strings.Repeat("a", MaxLen), []string{strings.Repeat("a", MaxLen)}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Parse(tt.body), tt.body)
+	}
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"post-comments/pkg/broker"
+)
+
+type brokerCollector[K comparable] struct {
+	stats func() broker.Stats[K]
+	// perTopic tells whether subscribers has a label for the topic.
+	perTopic     bool
+	subscribers  *prometheus.Desc
+	dropped      *prometheus.Desc
+	disconnected *prometheus.Desc
+}
+
+// RegisterBroker exports the subscriber counts per post and the events lost
+// to slow subscribers of a broker. name tells brokers apart.
+func RegisterBroker(name string, stats func() broker.Stats[int]) {
+	registerBroker(name, stats, true, prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "subscriptions", "active"),
+		"Active subscriptions per post.",
+		[]string{"post_id"}, prometheus.Labels{"broker": name},
+	))
+}
+
+// RegisterUserBroker is RegisterBroker for brokers whose topics are user
+// names, which are kept out of the metrics: only the total of subscribers
+// is exported.
+func RegisterUserBroker(name string, stats func() broker.Stats[string]) {
+	registerBroker(name, stats, false, prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "subscriptions", "user_active"),
+		"Active subscriptions to user topics.",
+		nil, prometheus.Labels{"broker": name},
+	))
+}
+
+func registerBroker[K comparable](name string, stats func() broker.Stats[K], perTopic bool, subscribers *prometheus.Desc) {
+	labels := prometheus.Labels{"broker": name}
+	prometheus.MustRegister(&brokerCollector[K]{
+		stats:       stats,
+		perTopic:    perTopic,
+		subscribers: subscribers,
+		dropped: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "subscriptions", "dropped_events_total"),
+			"Events dropped because a subscriber's buffer was full.",
+			nil, labels,
+		),
+		disconnected: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "subscriptions", "disconnected_total"),
+			"Subscribers disconnected for being too slow.",
+			nil, labels,
+		),
+	})
+}
+
+func (c *brokerCollector[K]) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.subscribers
+	ch <- c.dropped
+	ch <- c.disconnected
+}
+
+func (c *brokerCollector[K]) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+	if c.perTopic {
+		for topic, n := range stats.Topics {
+			ch <- prometheus.MustNewConstMetric(c.subscribers, prometheus.GaugeValue, float64(n), fmt.Sprint(topic))
+		}
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.subscribers, prometheus.GaugeValue, float64(stats.Subscribers))
+	}
+	ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(stats.Dropped))
+	ch <- prometheus.MustNewConstMetric(c.disconnected, prometheus.CounterValue, float64(stats.Disconnected))
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/vektah/gqlparser/v2/ast"
+	"time"
+)
+
+var (
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "graphql",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of GraphQL queries and mutations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type", "field"})
+	operationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "graphql",
+		Name:      "operation_errors_total",
+		Help:      "Errors returned in GraphQL responses.",
+	}, []string{"type", "field"})
+	subscriptionEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "graphql",
+		Name:      "subscription_events_total",
+		Help:      "Events sent to subscribers.",
+	}, []string{"field"})
+)
+
+// Tracer is a gqlgen handler extension recording per-operation latency and
+// error counts. Operations are labeled by their top-level field rather than
+// by the name the client chose, which would let clients create any number
+// of series.
+type Tracer struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+} = Tracer{}
+
+func (Tracer) ExtensionName() string {
+	return "Metrics"
+}
+
+func (Tracer) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (Tracer) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	oc := graphql.GetOperationContext(ctx)
+	resp := next(ctx)
+	if oc.Operation == nil {
+		return resp
+	}
+
+	opType := string(oc.Operation.Operation)
+	name := topLevelField(oc.Operation)
+	if oc.Operation.Operation == ast.Subscription {
+		if resp != nil {
+			subscriptionEvents.WithLabelValues(name).Inc()
+		}
+	} else {
+		operationDuration.WithLabelValues(opType, name).Observe(time.Since(oc.Stats.OperationStart).Seconds())
+	}
+	if resp != nil && len(resp.Errors) > 0 {
+		operationErrors.WithLabelValues(opType, name).Add(float64(len(resp.Errors)))
+	}
+	return resp
+}
+
+// topLevelField returns the name of the field an operation selects, or
+// "multiple" when it selects several or uses fragments at the top level.
+// Either way the label is bounded by the schema.
+func topLevelField(op *ast.OperationDefinition) string {
+	name := ""
+	for _, selection := range op.SelectionSet {
+		field, ok := selection.(*ast.Field)
+		if !ok || name != "" && field.Name != name {
+			return "multiple"
+		}
+		name = field.Name
+	}
+	if name == "" {
+		return "multiple"
+	}
+	return name
+}
@@ -0,0 +1,234 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"post-comments/pkg/model"
+	"post-comments/pkg/storage"
+	"time"
+)
+
+var storageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Subsystem: "storage",
+	Name:      "call_duration_seconds",
+	Help:      "Duration of Storage method calls.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"method", "status"})
+
+// Storage records the latency of every call to the wrapped storage.
+type Storage struct {
+	next storage.Storage
+}
+
+func NewStorage(next storage.Storage) *Storage {
+	return &Storage{next: next}
+}
+
+func observe(method string, start time.Time, err error) {
+	storageDuration.WithLabelValues(method, status(err)).Observe(time.Since(start).Seconds())
+}
+
+func (s *Storage) CreatePost(ctx context.Context, post *model.Post) error {
+	start := time.Now()
+	err := s.next.CreatePost(ctx, post)
+	observe("CreatePost", start, err)
+	return err
+}
+
+func (s *Storage) GetPosts(ctx context.Context) ([]*model.Post, error) {
+	start := time.Now()
+	posts, err := s.next.GetPosts(ctx)
+	observe("GetPosts", start, err)
+	return posts, err
+}
+
+func (s *Storage) GetPost(ctx context.Context, id int) (*model.Post, error) {
+	start := time.Now()
+	post, err := s.next.GetPost(ctx, id)
+	observe("GetPost", start, err)
+	return post, err
+}
+
+func (s *Storage) GetPostHeader(ctx context.Context, id int) (*model.Post, error) {
+	start := time.Now()
+	post, err := s.next.GetPostHeader(ctx, id)
+	observe("GetPostHeader", start, err)
+	return post, err
+}
+
+func (s *Storage) UpdatePost(ctx context.Context, id int, update storage.PostUpdate) (*model.Post, error) {
+	start := time.Now()
+	post, err := s.next.UpdatePost(ctx, id, update)
+	observe("UpdatePost", start, err)
+	return post, err
+}
+
+func (s *Storage) GetPostsByTag(ctx context.Context, tag string) ([]*model.Post, error) {
+	start := time.Now()
+	posts, err := s.next.GetPostsByTag(ctx, tag)
+	observe("GetPostsByTag", start, err)
+	return posts, err
+}
+
+func (s *Storage) GetTags(ctx context.Context) ([]*model.TagCount, error) {
+	start := time.Now()
+	tags, err := s.next.GetTags(ctx)
+	observe("GetTags", start, err)
+	return tags, err
+}
+
+func (s *Storage) CreateComment(ctx context.Context, comment *model.Comment) error {
+	start := time.Now()
+	err := s.next.CreateComment(ctx, comment)
+	observe("CreateComment", start, err)
+	return err
+}
+
+func (s *Storage) DisableComments(ctx context.Context, postID int) (*model.Post, error) {
+	start := time.Now()
+	post, err := s.next.DisableComments(ctx, postID)
+	observe("DisableComments", start, err)
+	return post, err
+}
+
+func (s *Storage) EnableComments(ctx context.Context, postID int) (*model.Post, error) {
+	start := time.Now()
+	post, err := s.next.EnableComments(ctx, postID)
+	observe("EnableComments", start, err)
+	return post, err
+}
+
+func (s *Storage) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	start := time.Now()
+	added, err := s.next.AddReaction(ctx, reaction)
+	observe("AddReaction", start, err)
+	return added, err
+}
+
+func (s *Storage) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	start := time.Now()
+	removed, err := s.next.RemoveReaction(ctx, reaction)
+	observe("RemoveReaction", start, err)
+	return removed, err
+}
+
+func (s *Storage) GetReactionCounts(ctx context.Context, postID int, commentID *int) ([]*model.ReactionCount, error) {
+	start := time.Now()
+	counts, err := s.next.GetReactionCounts(ctx, postID, commentID)
+	observe("GetReactionCounts", start, err)
+	return counts, err
+}
+
+func (s *Storage) GetUserReactions(ctx context.Context, postID int, commentID *int, user string) ([]string, error) {
+	start := time.Now()
+	emojis, err := s.next.GetUserReactions(ctx, postID, commentID, user)
+	observe("GetUserReactions", start, err)
+	return emojis, err
+}
+
+func (s *Storage) Vote(ctx context.Context, vote *model.Vote) (*model.Comment, error) {
+	start := time.Now()
+	comment, err := s.next.Vote(ctx, vote)
+	observe("Vote", start, err)
+	return comment, err
+}
+
+func (s *Storage) GetUserVote(ctx context.Context, commentID int, user string) (int, error) {
+	start := time.Now()
+	value, err := s.next.GetUserVote(ctx, commentID, user)
+	observe("GetUserVote", start, err)
+	return value, err
+}
+
+func (s *Storage) GetComments(ctx context.Context, q storage.CommentQuery) ([]*model.Comment, error) {
+	start := time.Now()
+	comments, err := s.next.GetComments(ctx, q)
+	observe("GetComments", start, err)
+	return comments, err
+}
+
+func (s *Storage) GetComment(ctx context.Context, id int) (*model.Comment, error) {
+	start := time.Now()
+	comment, err := s.next.GetComment(ctx, id)
+	observe("GetComment", start, err)
+	return comment, err
+}
+
+func (s *Storage) GetCommentDepth(ctx context.Context, id int) (int, error) {
+	start := time.Now()
+	depth, err := s.next.GetCommentDepth(ctx, id)
+	observe("GetCommentDepth", start, err)
+	return depth, err
+}
+
+func (s *Storage) ResolveUsers(ctx context.Context, names []string) ([]string, error) {
+	start := time.Now()
+	users, err := s.next.ResolveUsers(ctx, names)
+	observe("ResolveUsers", start, err)
+	return users, err
+}
+
+func (s *Storage) CreateNotification(ctx context.Context, notification *model.Notification) error {
+	start := time.Now()
+	err := s.next.CreateNotification(ctx, notification)
+	observe("CreateNotification", start, err)
+	return err
+}
+
+func (s *Storage) GetNotifications(ctx context.Context, q storage.NotificationQuery) ([]*model.Notification, error) {
+	start := time.Now()
+	notifications, err := s.next.GetNotifications(ctx, q)
+	observe("GetNotifications", start, err)
+	return notifications, err
+}
+
+func (s *Storage) MarkNotificationsRead(ctx context.Context, user string, ids []int) (int, error) {
+	start := time.Now()
+	marked, err := s.next.MarkNotificationsRead(ctx, user, ids)
+	observe("MarkNotificationsRead", start, err)
+	return marked, err
+}
+
+func (s *Storage) ReportComment(ctx context.Context, report *model.Report) error {
+	start := time.Now()
+	err := s.next.ReportComment(ctx, report)
+	observe("ReportComment", start, err)
+	return err
+}
+
+func (s *Storage) GetModerationQueue(ctx context.Context, limit int) ([]*model.ReportedComment, error) {
+	start := time.Now()
+	queue, err := s.next.GetModerationQueue(ctx, limit)
+	observe("GetModerationQueue", start, err)
+	return queue, err
+}
+
+func (s *Storage) ResolveReports(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, int, error) {
+	start := time.Now()
+	comment, closed, err := s.next.ResolveReports(ctx, commentID, action)
+	observe("ResolveReports", start, err)
+	return comment, closed, err
+}
+
+func (s *Storage) IsBanned(ctx context.Context, user string) (bool, error) {
+	start := time.Now()
+	banned, err := s.next.IsBanned(ctx, user)
+	observe("IsBanned", start, err)
+	return banned, err
+}
+
+func (s *Storage) TrainSpam(ctx context.Context, tokens []string, spam bool) error {
+	start := time.Now()
+	err := s.next.TrainSpam(ctx, tokens, spam)
+	observe("TrainSpam", start, err)
+	return err
+}
+
+func (s *Storage) GetSpamModel(ctx context.Context) (*model.SpamModel, error) {
+	start := time.Now()
+	m, err := s.next.GetSpamModel(ctx)
+	observe("GetSpamModel", start, err)
+	return m, err
+}
@@ -9,24 +9,256 @@ import (
 )
 
 type Comment struct {
-	ID        int       `json:"id"`
-	PostID    int       `json:"postId"`
-	ParentID  *int      `json:"parentId,omitempty"`
-	Body      string    `json:"body"`
+	ID       int    `json:"id"`
+	PostID   int    `json:"postId"`
+	ParentID *int   `json:"parentId,omitempty"`
+	Body     string `json:"body"`
+	// Author is the user who wrote the comment, "" for anonymous comments.
+	Author string `json:"author,omitempty"`
+	// Mentions lists the users mentioned in Body, in order of appearance.
+	Mentions []string `json:"mentions,omitempty"`
+	// Hidden is set by moderators; hidden comments are shown to other
+	// users as placeholders.
+	Hidden    bool      `json:"hidden,omitempty"`
+	Upvotes   int       `json:"upvotes"`
+	Downvotes int       `json:"downvotes"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+func (c *Comment) Score() int {
+	return c.Upvotes - c.Downvotes
+}
+
 type Post struct {
-	ID               int        `json:"id"`
-	Title            string     `json:"title"`
-	Body             string     `json:"body"`
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	Author string `json:"author,omitempty"`
+	// FilterProfile names the moderation filters of the post and its
+	// comments, "" for the default ones.
+	FilterProfile string `json:"filterProfile,omitempty"`
+	// Tags are lower-case, sorted and distinct.
+	Tags             []string   `json:"tags,omitempty"`
 	Comments         []*Comment `json:"comments"`
 	CommentsDisabled bool       `json:"commentsDisabled"`
 	CreatedAt        time.Time  `json:"createdAt"`
 	UpdatedAt        time.Time  `json:"updatedAt"`
 }
 
+// TagCount is how many posts are tagged with Tag.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// Reaction is an emoji a user left on a post, or on one of its comments when
+// CommentID is set.
+type Reaction struct {
+	PostID    int    `json:"postId"`
+	CommentID *int   `json:"commentId,omitempty"`
+	User      string `json:"user"`
+	Emoji     string `json:"emoji"`
+}
+
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// Vote is a user's vote on a comment: 1 up, -1 down, 0 none.
+type Vote struct {
+	CommentID int    `json:"commentId"`
+	User      string `json:"user"`
+	Value     int    `json:"value"`
+}
+
+// Notification tells User that Actor did something of Kind on a post, or
+// on one of its comments when CommentID is set.
+type Notification struct {
+	ID        int              `json:"id"`
+	User      string           `json:"user"`
+	Kind      NotificationKind `json:"kind"`
+	PostID    int              `json:"postId"`
+	CommentID *int             `json:"commentId,omitempty"`
+	Actor     string           `json:"actor,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Read      bool             `json:"read"`
+}
+
+// Report is a user's complaint about a comment, waiting for a moderator.
+type Report struct {
+	CommentID int       `json:"commentId"`
+	Reporter  string    `json:"reporter"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ReportedComment is a comment of the moderation queue with its reports,
+// oldest first.
+type ReportedComment struct {
+	Comment *Comment  `json:"comment"`
+	Reports []*Report `json:"reports"`
+}
+
+// SpamModel is what the spam classifier learned from moderation decisions:
+// how many comments of each class it saw, and how many of them each token
+// appeared in.
+type SpamModel struct {
+	Spam   int                    `json:"spam"`
+	Ham    int                    `json:"ham"`
+	Tokens map[string]TokenCounts `json:"tokens"`
+}
+
+type TokenCounts struct {
+	Spam int `json:"spam"`
+	Ham  int `json:"ham"`
+}
+
+type ModerationAction string
+
+const (
+	// ModerationActionDismiss closes the reports, leaving the comment be.
+	ModerationActionDismiss ModerationAction = "DISMISS"
+	// ModerationActionHide hides the comment.
+	ModerationActionHide ModerationAction = "HIDE"
+	// ModerationActionBan hides the comment and bans its author.
+	ModerationActionBan ModerationAction = "BAN"
+)
+
+var AllModerationAction = []ModerationAction{
+	ModerationActionDismiss,
+	ModerationActionHide,
+	ModerationActionBan,
+}
+
+func (e ModerationAction) IsValid() bool {
+	switch e {
+	case ModerationActionDismiss, ModerationActionHide, ModerationActionBan:
+		return true
+	}
+	return false
+}
+
+func (e ModerationAction) String() string {
+	return string(e)
+}
+
+func (e *ModerationAction) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ModerationAction(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ModerationAction", str)
+	}
+	return nil
+}
+
+func (e ModerationAction) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type NotificationKind string
+
+const (
+	// NotificationKindReply is sent to the author of the comment replied to.
+	NotificationKindReply NotificationKind = "REPLY"
+	// NotificationKindPostComment is sent to the author of the post
+	// commented on.
+	NotificationKindPostComment NotificationKind = "POST_COMMENT"
+	// NotificationKindMention is sent to the users mentioned in a comment.
+	NotificationKindMention NotificationKind = "MENTION"
+	// NotificationKindModeration is sent to the author of a post or comment
+	// a moderator acted on.
+	NotificationKindModeration NotificationKind = "MODERATION"
+)
+
+var AllNotificationKind = []NotificationKind{
+	NotificationKindReply,
+	NotificationKindPostComment,
+	NotificationKindMention,
+	NotificationKindModeration,
+}
+
+func (e NotificationKind) IsValid() bool {
+	switch e {
+	case NotificationKindReply, NotificationKindPostComment, NotificationKindMention, NotificationKindModeration:
+		return true
+	}
+	return false
+}
+
+func (e NotificationKind) String() string {
+	return string(e)
+}
+
+func (e *NotificationKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = NotificationKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid NotificationKind", str)
+	}
+	return nil
+}
+
+func (e NotificationKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type CommentOrder string
+
+const (
+	CommentOrderTop           CommentOrder = "TOP"
+	CommentOrderHot           CommentOrder = "HOT"
+	CommentOrderControversial CommentOrder = "CONTROVERSIAL"
+	CommentOrderNewest        CommentOrder = "NEWEST"
+	CommentOrderOldest        CommentOrder = "OLDEST"
+)
+
+var AllCommentOrder = []CommentOrder{
+	CommentOrderTop,
+	CommentOrderHot,
+	CommentOrderControversial,
+	CommentOrderNewest,
+	CommentOrderOldest,
+}
+
+func (e CommentOrder) IsValid() bool {
+	switch e {
+	case CommentOrderTop, CommentOrderHot, CommentOrderControversial, CommentOrderNewest, CommentOrderOldest:
+		return true
+	}
+	return false
+}
+
+func (e CommentOrder) String() string {
+	return string(e)
+}
+
+func (e *CommentOrder) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = CommentOrder(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid CommentOrder", str)
+	}
+	return nil
+}
+
+func (e CommentOrder) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
 func MarshalID(id int) graphql.Marshaler {
 	return graphql.WriterFunc(func(w io.Writer) {
 		_, _ = io.WriteString(w, strconv.Quote(fmt.Sprintf("%d", id)))
@@ -0,0 +1,234 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+type BannedWordsConfig struct {
+	// Words are matched as whole words, regardless of case.
+	Words  []string `mapstructure:"words"`
+	Action Action   `mapstructure:"action"`
+}
+
+// BannedWords objects to bodies using any of a list of words, masking
+// replaces each letter of them with '*'.
+type BannedWords struct {
+	action Action
+	words  map[string]bool
+}
+
+func NewBannedWords(cfg BannedWordsConfig) *BannedWords {
+	f := &BannedWords{action: cfg.Action, words: make(map[string]bool, len(cfg.Words))}
+	for _, word := range cfg.Words {
+		f.words[strings.ToLower(word)] = true
+	}
+	return f
+}
+
+func (f *BannedWords) Name() string { return "banned_words" }
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (f *BannedWords) Check(ctx context.Context, content Content) (Verdict, error) {
+	var masked strings.Builder
+	var found []string
+	body := content.Body
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		if !isWordRune(r) {
+			masked.WriteString(body[i : i+size])
+			i += size
+			continue
+		}
+		end := i + strings.IndexFunc(body[i:], func(r rune) bool { return !isWordRune(r) })
+		if end < i {
+			end = len(body)
+		}
+		word := body[i:end]
+		if f.words[strings.ToLower(word)] {
+			found = append(found, word)
+			word = strings.Repeat("*", utf8.RuneCountInString(word))
+		}
+		masked.WriteString(word)
+		i = end
+	}
+	if len(found) == 0 {
+		return Verdict{}, nil
+	}
+	return Verdict{
+		Action: f.action,
+		Reason: fmt.Sprintf("uses banned words: %s", strings.Join(found, ", ")),
+		Body:   masked.String(),
+	}, nil
+}
+
+type LinksConfig struct {
+	// Max is how many links a body can have.
+	Max    int    `mapstructure:"max"`
+	Action Action `mapstructure:"action"`
+}
+
+// linkPattern matches http(s) URLs and bare www. addresses.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// Links objects to bodies with more than a number of links, masking
+// replaces the links past that number with "[link removed]".
+type Links struct {
+	cfg LinksConfig
+}
+
+func (f *Links) Name() string { return "links" }
+
+func (f *Links) Check(ctx context.Context, content Content) (Verdict, error) {
+	links := linkPattern.FindAllStringIndex(content.Body, -1)
+	if len(links) <= f.cfg.Max {
+		return Verdict{}, nil
+	}
+	var masked strings.Builder
+	last := 0
+	for _, link := range links[f.cfg.Max:] {
+		masked.WriteString(content.Body[last:link[0]])
+		masked.WriteString("[link removed]")
+		last = link[1]
+	}
+	masked.WriteString(content.Body[last:])
+	return Verdict{
+		Action: f.cfg.Action,
+		Reason: fmt.Sprintf("too many links (%d, at most %d)", len(links), f.cfg.Max),
+		Body:   masked.String(),
+	}, nil
+}
+
+type RepeatsConfig struct {
+	// Max is how many times in a row a character can be repeated.
+	Max    int    `mapstructure:"max"`
+	Action Action `mapstructure:"action"`
+}
+
+// Repeats objects to bodies repeating a character, spaces aside, more than
+// a number of times in a row; masking cuts the runs down to that number.
+type Repeats struct {
+	cfg RepeatsConfig
+}
+
+func (f *Repeats) Name() string { return "repeats" }
+
+func (f *Repeats) Check(ctx context.Context, content Content) (Verdict, error) {
+	var masked strings.Builder
+	var prev rune
+	run, longest := 0, 0
+	for _, r := range content.Body {
+		if r == prev && !unicode.IsSpace(r) {
+			run++
+		} else {
+			prev, run = r, 1
+		}
+		longest = max(longest, run)
+		if run <= f.cfg.Max {
+			masked.WriteRune(r)
+		}
+	}
+	if longest <= f.cfg.Max {
+		return Verdict{}, nil
+	}
+	return Verdict{
+		Action: f.cfg.Action,
+		Reason: fmt.Sprintf("repeats a character %d times in a row", longest),
+		Body:   masked.String(),
+	}, nil
+}
+
+type DuplicatesConfig struct {
+	// Window is how long a body is remembered.
+	Window time.Duration `mapstructure:"window"`
+	Action Action        `mapstructure:"action"`
+}
+
+// Duplicates objects to a writer sending again a body they sent within a
+// window, regardless of case and spacing; titles and edits are not compared.
+// Duplicates can't be masked.
+type Duplicates struct {
+	cfg    DuplicatesConfig
+	recent *Recent
+}
+
+func (f *Duplicates) Name() string { return "duplicates" }
+
+func (f *Duplicates) Check(ctx context.Context, content Content) (Verdict, error) {
+	if content.Title || content.Edit || !f.recent.Seen(content.Key, content.Body, f.cfg.Window) {
+		return Verdict{}, nil
+	}
+	return Verdict{
+		Action: f.cfg.Action,
+		Reason: fmt.Sprintf("was already sent in the last %s", f.cfg.Window),
+	}, nil
+}
+
+type recentKey struct {
+	key  string
+	hash uint64
+}
+
+// Recent remembers hashes of the bodies each writer sent, for the
+// duplicates filter. It outlives configuration reloads, so it is shared by
+// the chains built from successive configurations.
+type Recent struct {
+	mu   sync.Mutex
+	sent map[recentKey]time.Time
+	// keep is the longest window asked for, entries older than it being
+	// dropped.
+	keep      time.Duration
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func NewRecent() *Recent {
+	return &Recent{sent: map[recentKey]time.Time{}, now: time.Now}
+}
+
+func recentKeyOf(key, body string) recentKey {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(strings.Join(strings.Fields(body), " "))))
+	return recentKey{key: key, hash: h.Sum64()}
+}
+
+// Seen reports whether key sent body within window. It doesn't record
+// body, which Record does once the body is stored, so that a write failing
+// doesn't make its retry a duplicate.
+func (r *Recent) Seen(key, body string, window time.Duration) bool {
+	k := recentKeyOf(key, body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keep = max(r.keep, window)
+	at, ok := r.sent[k]
+	return ok && r.now().Sub(at) <= window
+}
+
+// Record records that key sent body.
+func (r *Recent) Record(key, body string) {
+	k := recentKeyOf(key, body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if now.Sub(r.lastPrune) >= r.keep {
+		for k, at := range r.sent {
+			if now.Sub(at) > r.keep {
+				delete(r.sent, k)
+			}
+		}
+		r.lastPrune = now
+	}
+	r.sent[k] = now
+}
@@ -0,0 +1,199 @@
+// Package moderation runs the titles and bodies of new posts and the bodies
+// of comments through chains of filters, which can let them through, mask
+// parts of them, flag them for review or reject them.
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Action is what a filter does with a body it objects to.
+type Action string
+
+const (
+	// Reject refuses the body.
+	Reject Action = "reject"
+	// Flag accepts the body and asks a moderator to review it.
+	Flag Action = "flag"
+	// Mask accepts the body with the offending parts replaced.
+	Mask Action = "mask"
+)
+
+func (a Action) valid() bool {
+	return a == Reject || a == Flag || a == Mask
+}
+
+// Content is a body being filtered.
+type Content struct {
+	// Key identifies the writer, for the filters comparing a body with the
+	// same writer's earlier ones.
+	Key  string
+	Body string
+	// Title is set when Body is the title of a post, which the duplicates
+	// filter, comparing bodies, lets through.
+	Title bool
+	// Edit is set when Body replaces the writer's own, which the duplicates
+	// filter lets through too.
+	Edit bool
+}
+
+// Verdict is what a filter decided about a body. The zero Verdict lets it
+// through unchanged.
+type Verdict struct {
+	// Action is "" when the filter has no objection.
+	Action Action
+	// Reason tells the writer or the moderators what the filter found.
+	Reason string
+	// Body replaces the body when Action is Mask.
+	Body string
+}
+
+type Filter interface {
+	// Name identifies the filter in errors and reports.
+	Name() string
+	Check(ctx context.Context, content Content) (Verdict, error)
+}
+
+// FlagReason is why a filter flagged a body.
+type FlagReason struct {
+	Filter string
+	Reason string
+}
+
+// Result is the outcome of a chain that accepted a body.
+type Result struct {
+	// Body is the body to store, masked by the filters that asked for it.
+	Body  string
+	Flags []FlagReason
+}
+
+// RejectedError is returned by Chain.Apply for a body a filter refused.
+type RejectedError struct {
+	Filter string
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected by the %s filter: %s", e.Filter, e.Reason)
+}
+
+// Chain applies filters in order, each one seeing the body as masked by the
+// ones before it.
+type Chain []Filter
+
+// Apply runs content through the chain, stopping at the first filter
+// rejecting it with a *RejectedError.
+func (c Chain) Apply(ctx context.Context, content Content) (Result, error) {
+	result := Result{Body: content.Body}
+	for _, filter := range c {
+		content.Body = result.Body
+		verdict, err := filter.Check(ctx, content)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s filter: %w", filter.Name(), err)
+		}
+		switch verdict.Action {
+		case Reject:
+			return Result{}, &RejectedError{Filter: filter.Name(), Reason: verdict.Reason}
+		case Flag:
+			result.Flags = append(result.Flags, FlagReason{Filter: filter.Name(), Reason: verdict.Reason})
+		case Mask:
+			result.Body = verdict.Body
+		}
+	}
+	return result, nil
+}
+
+// Config holds the filter profiles posts can choose from. Comments are
+// filtered with the profile of their post.
+type Config struct {
+	// DefaultProfile filters the posts that didn't choose a profile, or
+	// chose one that was since removed; "" leaves them unfiltered.
+	DefaultProfile string             `mapstructure:"default_profile"`
+	Profiles       map[string]Profile `mapstructure:"profiles"`
+}
+
+// Profile configures the built-in filters, which run in the order of the
+// fields; a nil field leaves its filter out.
+type Profile struct {
+	BannedWords *BannedWordsConfig `mapstructure:"banned_words"`
+	Links       *LinksConfig       `mapstructure:"links"`
+	Repeats     *RepeatsConfig     `mapstructure:"repeats"`
+	Duplicates  *DuplicatesConfig  `mapstructure:"duplicates"`
+}
+
+// HasProfile reports whether name is a configured profile. Names are
+// compared regardless of case, the configuration keys being lower-cased.
+func (cfg Config) HasProfile(name string) bool {
+	_, ok := cfg.Profiles[strings.ToLower(name)]
+	return ok
+}
+
+// Chain returns the filters of the named profile, or of the default one
+// when name is "" or unknown. recent remembers the bodies the duplicates
+// filter compares with.
+func (cfg Config) Chain(name string, recent *Recent) Chain {
+	profile, ok := cfg.Profiles[strings.ToLower(name)]
+	if !ok {
+		profile = cfg.Profiles[strings.ToLower(cfg.DefaultProfile)]
+	}
+	var chain Chain
+	if profile.BannedWords != nil {
+		chain = append(chain, NewBannedWords(*profile.BannedWords))
+	}
+	if profile.Links != nil {
+		chain = append(chain, &Links{cfg: *profile.Links})
+	}
+	if profile.Repeats != nil {
+		chain = append(chain, &Repeats{cfg: *profile.Repeats})
+	}
+	if profile.Duplicates != nil {
+		chain = append(chain, &Duplicates{cfg: *profile.Duplicates, recent: recent})
+	}
+	return chain
+}
+
+// Validate returns what is wrong with the configuration, if anything.
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.DefaultProfile != "" && !cfg.HasProfile(cfg.DefaultProfile) {
+		errs = append(errs, fmt.Errorf("default profile %q is not configured", cfg.DefaultProfile))
+	}
+	for name, profile := range cfg.Profiles {
+		invalid := func(filter, format string, args ...any) {
+			errs = append(errs, fmt.Errorf("profile %q: %s: %s", name, filter, fmt.Sprintf(format, args...)))
+		}
+		action := func(filter string, action Action) {
+			if !action.valid() {
+				invalid(filter, "action must be reject, flag or mask, got %q", action)
+			}
+		}
+		if f := profile.BannedWords; f != nil {
+			action("banned_words", f.Action)
+		}
+		if f := profile.Links; f != nil {
+			action("links", f.Action)
+			if f.Max < 0 {
+				invalid("links", "max must not be negative")
+			}
+		}
+		if f := profile.Repeats; f != nil {
+			action("repeats", f.Action)
+			if f.Max <= 0 {
+				invalid("repeats", "max must be positive")
+			}
+		}
+		if f := profile.Duplicates; f != nil {
+			if f.Action == Mask {
+				invalid("duplicates", "duplicates can't be masked")
+			}
+			action("duplicates", f.Action)
+			if f.Window <= 0 {
+				invalid("duplicates", "window must be positive")
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
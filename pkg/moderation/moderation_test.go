@@ -0,0 +1,120 @@
+package moderation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func check(t *testing.T, f Filter, body string) Verdict {
+	t.Helper()
+	verdict, err := f.Check(context.Background(), Content{Key: "alice", Body: body})
+	require.NoError(t, err)
+	return verdict
+}
+
+func TestBannedWords(t *testing.T) {
+	f := NewBannedWords(BannedWordsConfig{Words: []string{"Spam", "éclair"}, Action: Mask})
+
+	assert.Equal(t, Verdict{}, check(t, f, "spammers spamming"))
+	verdict := check(t, f, "SPAM, spam and Éclair!")
+	assert.Equal(t, Mask, verdict.Action)
+	assert.Equal(t, "****, **** and ******!", verdict.Body)
+	assert.Contains(t, verdict.Reason, "SPAM, spam, Éclair")
+}
+
+func TestLinks(t *testing.T) {
+	f := &Links{cfg: LinksConfig{Max: 1, Action: Mask}}
+
+	assert.Equal(t, Verdict{}, check(t, f, "see https://example.com"))
+	verdict := check(t, f, "a http://a.example b www.b.example c HTTPS://c.example/x?y")
+	assert.Equal(t, Mask, verdict.Action)
+	assert.Equal(t, "a http://a.example b [link removed] c [link removed]", verdict.Body)
+}
+
+func TestRepeats(t *testing.T) {
+	f := &Repeats{cfg: RepeatsConfig{Max: 3, Action: Mask}}
+
+	assert.Equal(t, Verdict{}, check(t, f, "sooo good...      ok"))
+	verdict := check(t, f, "nooooo!!!!!")
+	assert.Equal(t, Mask, verdict.Action)
+	assert.Equal(t, "nooo!!!", verdict.Body)
+}
+
+func TestDuplicates(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := NewRecent()
+	recent.now = func() time.Time { return now }
+	f := &Duplicates{cfg: DuplicatesConfig{Window: time.Minute, Action: Reject}, recent: recent}
+
+	assert.Equal(t, Verdict{}, check(t, f, "Buy  now"))
+	assert.Equal(t, Verdict{}, check(t, f, "Buy  now"), "bodies count once recorded")
+	recent.Record("alice", "Buy  now")
+	assert.Equal(t, Reject, check(t, f, "buy now ").Action)
+	verdict, err := f.Check(context.Background(), Content{Key: "alice", Body: "buy now", Title: true})
+	require.NoError(t, err)
+	assert.Equal(t, Verdict{}, verdict, "titles are not compared")
+	verdict, err = f.Check(context.Background(), Content{Key: "alice", Body: "buy now", Edit: true})
+	require.NoError(t, err)
+	assert.Equal(t, Verdict{}, verdict, "edits are not compared")
+	verdict, err = f.Check(context.Background(), Content{Key: "bob", Body: "buy now"})
+	require.NoError(t, err)
+	assert.Equal(t, Verdict{}, verdict, "other writers may say the same")
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, Verdict{}, check(t, f, "buy now"))
+}
+
+func TestChain(t *testing.T) {
+	cfg := Config{
+		DefaultProfile: "standard",
+		Profiles: map[string]Profile{
+			"standard": {
+				BannedWords: &BannedWordsConfig{Words: []string{"darn"}, Action: Mask},
+				Links:       &LinksConfig{Max: 0, Action: Flag},
+			},
+			"strict": {
+				BannedWords: &BannedWordsConfig{Words: []string{"darn"}, Action: Reject},
+			},
+		},
+	}
+	require.NoError(t, cfg.Validate())
+	ctx := context.Background()
+
+	result, err := cfg.Chain("", NewRecent()).Apply(ctx, Content{Body: "darn, see www.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "****, see www.example.com", result.Body)
+	assert.Equal(t, []FlagReason{{Filter: "links", Reason: "too many links (1, at most 0)"}}, result.Flags)
+
+	_, err = cfg.Chain("Strict", NewRecent()).Apply(ctx, Content{Body: "darn"})
+	var rejected *RejectedError
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, "banned_words", rejected.Filter)
+
+	// profiles removed from the configuration fall back to the default
+	assert.Len(t, cfg.Chain("removed", NewRecent()), 2)
+	assert.True(t, cfg.HasProfile("STRICT"))
+	assert.False(t, cfg.HasProfile("removed"))
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{
+		DefaultProfile: "missing",
+		Profiles: map[string]Profile{
+			"bad": {
+				BannedWords: &BannedWordsConfig{Action: "delete"},
+				Repeats:     &RepeatsConfig{Action: Flag},
+				Duplicates:  &DuplicatesConfig{Window: time.Minute, Action: Mask},
+			},
+		},
+	}
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, want := range []string{"default profile", "banned_words", "repeats", "duplicates"} {
+		assert.ErrorContains(t, err, want)
+	}
+	assert.NoError(t, Config{}.Validate())
+}
@@ -0,0 +1,134 @@
+// Package ranking sorts comments for Post.comments(orderBy).
+package ranking
+
+import (
+	"errors"
+	"math"
+	"post-comments/pkg/model"
+	"slices"
+	"sort"
+	"time"
+)
+
+const DefaultHotDecay = 12*time.Hour + 30*time.Minute
+
+type Config struct {
+	// HotDecay is how much newer a comment ranks as high in HOT as one
+	// with ten times its score.
+	HotDecay time.Duration `mapstructure:"hot_decay"`
+}
+
+// Decay returns HotDecay, or DefaultHotDecay when it isn't positive.
+func (cfg Config) Decay() time.Duration {
+	if cfg.HotDecay <= 0 {
+		return DefaultHotDecay
+	}
+	return cfg.HotDecay
+}
+
+// Key returns the value comments are sorted by in order, greatest first,
+// ties going to the older comment. It doesn't depend on the current time,
+// so the position of a comment only changes when it is voted on.
+func (cfg Config) Key(order model.CommentOrder, c *model.Comment) float64 {
+	switch order {
+	case model.CommentOrderTop:
+		return float64(c.Score())
+	case model.CommentOrderHot:
+		return Hot(c.Score(), c.CreatedAt, cfg.Decay())
+	case model.CommentOrderControversial:
+		return Controversy(c.Upvotes, c.Downvotes)
+	case model.CommentOrderNewest:
+		return float64(c.ID)
+	}
+	return -float64(c.ID)
+}
+
+// Hot is the logarithm of the score plus a bonus growing with the creation
+// time, so that new comments start above older ones of the same score.
+func Hot(score int, createdAt time.Time, decay time.Duration) float64 {
+	magnitude := math.Log10(math.Max(math.Abs(float64(score)), 1))
+	sign := 0.0
+	if score > 0 {
+		sign = 1
+	} else if score < 0 {
+		sign = -1
+	}
+	return sign*magnitude + float64(createdAt.Unix())/decay.Seconds()
+}
+
+// Controversy is high for comments with many votes split evenly between up
+// and down, and 0 for comments voted only one way.
+func Controversy(upvotes, downvotes int) float64 {
+	if upvotes <= 0 || downvotes <= 0 {
+		return 0
+	}
+	balance := float64(min(upvotes, downvotes)) / float64(max(upvotes, downvotes))
+	return math.Pow(float64(upvotes+downvotes), balance)
+}
+
+func (cfg Config) less(order model.CommentOrder) func(a, b *model.Comment) bool {
+	return func(a, b *model.Comment) bool {
+		ka, kb := cfg.Key(order, a), cfg.Key(order, b)
+		if ka != kb {
+			return ka > kb
+		}
+		return a.ID < b.ID
+	}
+}
+
+// Threads lays comments out as threads: every top-level comment of roots,
+// kept in the given order, is followed by its replies, each followed by its
+// own replies, siblings being sorted by order. Replies to comments missing
+// from both lists are left out.
+func (cfg Config) Threads(roots, replies []*model.Comment, order model.CommentOrder) []*model.Comment {
+	children := make(map[int][]*model.Comment)
+	for _, c := range replies {
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+	less := cfg.less(order)
+	for _, siblings := range children {
+		sort.Slice(siblings, func(i, j int) bool { return less(siblings[i], siblings[j]) })
+	}
+
+	threads := make([]*model.Comment, 0, len(roots)+len(replies))
+	var walk func(c *model.Comment)
+	walk = func(c *model.Comment) {
+		threads = append(threads, c)
+		for _, child := range children[c.ID] {
+			walk(child)
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+	return threads
+}
+
+// Page returns the threads of up to limit top-level comments, 0 meaning no
+// limit, starting after the top-level comment after when it isn't nil.
+func (cfg Config) Page(comments []*model.Comment, order model.CommentOrder, after *int, limit int) ([]*model.Comment, error) {
+	var roots, replies []*model.Comment
+	for _, c := range comments {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else {
+			replies = append(replies, c)
+		}
+	}
+	less := cfg.less(order)
+	sort.Slice(roots, func(i, j int) bool { return less(roots[i], roots[j]) })
+
+	if after != nil {
+		i := slices.IndexFunc(roots, func(c *model.Comment) bool { return c.ID == *after })
+		if i < 0 {
+			return nil, errors.New("comment not found")
+		}
+		roots = roots[i+1:]
+	}
+	if limit > 0 && len(roots) > limit {
+		roots = roots[:limit]
+	}
+	return cfg.Threads(roots, replies, order), nil
+}
@@ -0,0 +1,64 @@
+package ranking
+
+import (
+	"post-comments/pkg/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHot(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	decay := time.Hour
+
+	assert.Greater(t, Hot(1, now.Add(time.Minute), decay), Hot(1, now, decay))
+	assert.Greater(t, Hot(100, now, decay), Hot(10, now, decay))
+	assert.Less(t, Hot(-10, now, decay), Hot(0, now, decay))
+	// ten times the score is worth one decay of age
+	assert.InDelta(t, Hot(100, now, decay), Hot(10, now.Add(decay), decay), 1e-9)
+}
+
+func TestControversy(t *testing.T) {
+	assert.Zero(t, Controversy(10, 0))
+	assert.Zero(t, Controversy(0, 10))
+	assert.Greater(t, Controversy(5, 5), Controversy(9, 1))
+	assert.Greater(t, Controversy(50, 50), Controversy(5, 5))
+}
+
+func TestPage(t *testing.T) {
+	parent := func(id int) *int { return &id }
+	comments := []*model.Comment{
+		{ID: 1, Upvotes: 1},
+		{ID: 2, Upvotes: 5},
+		{ID: 3, ParentID: parent(1), Downvotes: 1},
+		{ID: 4, ParentID: parent(1), Upvotes: 2},
+		{ID: 5, ParentID: parent(4)},
+		{ID: 6, Upvotes: 1},
+		{ID: 7, ParentID: parent(99)},
+	}
+	ids := func(comments []*model.Comment) []int {
+		var ids []int
+		for _, c := range comments {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+	var cfg Config
+
+	page, err := cfg.Page(comments, model.CommentOrderTop, nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1, 4, 5, 3, 6}, ids(page))
+
+	page, err = cfg.Page(comments, model.CommentOrderTop, parent(2), 1)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 4, 5, 3}, ids(page))
+
+	page, err = cfg.Page(comments, model.CommentOrderNewest, nil, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{6, 2}, ids(page))
+
+	_, err = cfg.Page(comments, model.CommentOrderTop, parent(3), 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"post-comments/pkg/viewer"
+	"sync"
+	"time"
+)
+
+// idleTTL is how long a client's bucket is kept after its last request.
+const idleTTL = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket per client. The rate is looked up on every call,
+// so it can be changed while the server runs.
+type Limiter struct {
+	rate    func() (perMinute int, burst int)
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+func New(rate func() (perMinute int, burst int)) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the client's bucket. It always succeeds when the
+// rate is not positive.
+func (l *Limiter) Allow(client string) bool {
+	perMinute, burst := l.rate()
+	if perMinute <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * float64(perMinute)
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < idleTTL {
+		return
+	}
+	l.swept = now
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > idleTTL {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+type ctxKey struct{}
+
+// WithClient stores the address of the client.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, client)
+}
+
+func Client(ctx context.Context) string {
+	client, _ := ctx.Value(ctxKey{}).(string)
+	return client
+}
+
+// Key returns what a request is rate limited by: the signed-in user, as all
+// the users behind the authenticating proxy share its address, or the
+// client address for anonymous requests.
+func Key(ctx context.Context) string {
+	if user := viewer.User(ctx); user != "" {
+		return "user " + user
+	}
+	return "client " + Client(ctx)
+}
+
+// Middleware identifies clients by their IP address.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		next.ServeHTTP(w, r.WithContext(WithClient(r.Context(), host)))
+	})
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"post-comments/pkg/viewer"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Now()
+	l := New(func() (int, int) { return 60, 2 })
+	l.now = func() time.Time { return now }
+
+	assert.True(t, l.Allow("a"))
+	assert.True(t, l.Allow("a"))
+	assert.False(t, l.Allow("a"))
+	assert.True(t, l.Allow("b"))
+
+	now = now.Add(time.Second)
+	assert.True(t, l.Allow("a"))
+	assert.False(t, l.Allow("a"))
+}
+
+func TestAllowDisabled(t *testing.T) {
+	l := New(func() (int, int) { return 0, 0 })
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, l.Allow("a"))
+	}
+}
+
+func TestKey(t *testing.T) {
+	ctx := WithClient(context.Background(), "10.0.0.1")
+
+	assert.Equal(t, "client 10.0.0.1", Key(ctx))
+	assert.Equal(t, "user alice", Key(viewer.WithUser(ctx, "alice")))
+	assert.NotEqual(t, Key(viewer.WithUser(ctx, "alice")), Key(viewer.WithUser(ctx, "bob")))
+}
@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"context"
+	"log/slog"
+	"post-comments/pkg/model"
+	"post-comments/pkg/moderation"
+	"post-comments/pkg/ratelimit"
+)
+
+// filterReporter prefixes the filter names in the reporter of the reports
+// filters file about flagged comments.
+const filterReporter = "filter:"
+
+// filter runs body through chain for the viewer user, returning the body
+// to store and why it was flagged, if it was.
+func (r *Resolver) filter(ctx context.Context, chain moderation.Chain, user, body string) (moderation.Result, error) {
+	return chain.Apply(ctx, moderation.Content{Key: writerKey(ctx, user), Body: body})
+}
+
+// filterTitle runs the title of a post through chain for the viewer user,
+// as filter does bodies.
+func (r *Resolver) filterTitle(ctx context.Context, chain moderation.Chain, user, title string) (moderation.Result, error) {
+	return chain.Apply(ctx, moderation.Content{Key: writerKey(ctx, user), Body: title, Title: true})
+}
+
+// filterEdit runs the new body of a post through chain for the viewer user,
+// as filter does, but without comparing it with earlier bodies.
+func (r *Resolver) filterEdit(ctx context.Context, chain moderation.Chain, user, body string) (moderation.Result, error) {
+	return chain.Apply(ctx, moderation.Content{Key: writerKey(ctx, user), Body: body, Edit: true})
+}
+
+// sent records the body user stored, for the duplicates filter to compare
+// later bodies with.
+func (r *Resolver) sent(ctx context.Context, user, body string) {
+	r.Recent.Record(writerKey(ctx, user), body)
+}
+
+func writerKey(ctx context.Context, user string) string {
+	if user == "" {
+		// anonymous writers are told apart by client, as for rate limits
+		return "client " + ratelimit.Client(ctx)
+	}
+	return user
+}
+
+// reportFlags files a report for each filter that flagged a new comment,
+// putting it in the moderation queue. Failures are logged, the comment
+// being created already.
+func (r *Resolver) reportFlags(ctx context.Context, comment *model.Comment, flags []moderation.FlagReason) {
+	for _, flag := range flags {
+		report := &model.Report{CommentID: comment.ID, Reporter: filterReporter + flag.Filter, Reason: flag.Reason}
+		if err := r.Storage.ReportComment(ctx, report); err != nil {
+			slog.WarnContext(ctx, "reporting flagged comment failed",
+				slog.Int("comment_id", comment.ID), slog.String("filter", flag.Filter), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// trainSpam teaches the spam classifier the moderator's decision about a
+// comment: hidden comments are spam, comments whose reports were dismissed
+// aren't. Failures are logged, the decision being applied already.
+func (r *Resolver) trainSpam(ctx context.Context, comment *model.Comment, action model.ModerationAction) {
+	if err := r.Spam.Train(ctx, comment.Body, action != model.ModerationActionDismiss); err != nil {
+		slog.WarnContext(ctx, "training spam classifier failed",
+			slog.Int("comment_id", comment.ID), slog.String("error", err.Error()))
+	}
+}
+
+// logFlags records the filters that flagged a new post. Posts have no
+// moderation queue, so the flags only end up in the logs.
+func logFlags(ctx context.Context, post *model.Post, flags []moderation.FlagReason) {
+	for _, flag := range flags {
+		slog.WarnContext(ctx, "post flagged by filter",
+			slog.Int("post_id", post.ID), slog.String("filter", flag.Filter), slog.String("reason", flag.Reason))
+	}
+}
@@ -0,0 +1,38 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"post-comments/pkg/ratelimit"
+	"unicode/utf8"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded, try again later")
+
+func tooLong(s string, maxLen int) bool {
+	return maxLen > 0 && utf8.RuneCountInString(s) > maxLen
+}
+
+func (r *Resolver) checkRateLimit(ctx context.Context) error {
+	if !r.Limiter.Allow(ratelimit.Key(ctx)) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// checkThreadDepth rejects a reply that would nest deeper than maxDepth,
+// top-level comments being at depth 1.
+func (r *Resolver) checkThreadDepth(ctx context.Context, parentID *int, maxDepth int) error {
+	if maxDepth <= 0 || parentID == nil {
+		return nil
+	}
+	depth, err := r.Storage.GetCommentDepth(ctx, *parentID)
+	if err != nil {
+		return err
+	}
+	if depth >= maxDepth {
+		return fmt.Errorf("replies can't be nested deeper than %d levels", maxDepth)
+	}
+	return nil
+}
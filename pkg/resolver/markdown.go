@@ -0,0 +1,13 @@
+package resolver
+
+import (
+	"strconv"
+	"time"
+)
+
+// revision names the version of the body of a post or comment that was
+// last updated at updatedAt, the key of its rendering in the Markdown
+// cache.
+func revision(kind string, id int, updatedAt time.Time) string {
+	return kind + ":" + strconv.Itoa(id) + ":" + strconv.FormatInt(updatedAt.UnixNano(), 10)
+}
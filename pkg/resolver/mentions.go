@@ -0,0 +1,32 @@
+package resolver
+
+import (
+	"context"
+	"post-comments/pkg/mention"
+)
+
+// author returns the GraphQL value of an author, null for anonymous posts
+// and comments.
+func author(user string) *string {
+	if user == "" {
+		return nil
+	}
+	return &user
+}
+
+// resolveMentions returns the known users mentioned in body, at most
+// maxMentions of them when it is positive.
+func (r *Resolver) resolveMentions(ctx context.Context, body string, maxMentions int) ([]string, error) {
+	names := mention.Parse(body)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	users, err := r.Storage.ResolveUsers(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	if maxMentions > 0 && len(users) > maxMentions {
+		users = users[:maxMentions]
+	}
+	return users, nil
+}
@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"post-comments/pkg/model"
+	"post-comments/pkg/viewer"
+)
+
+var (
+	ErrNotModerator = errors.New("you must be a moderator")
+	ErrBanned       = errors.New("you are banned")
+)
+
+// moderator returns the viewer if they are one of the configured
+// moderators.
+func (r *Resolver) moderator(ctx context.Context) (string, error) {
+	user, err := signedIn(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !r.Settings.Get().IsModerator(user) {
+		return "", ErrNotModerator
+	}
+	return user, nil
+}
+
+// authorOrModerator returns the viewer if they wrote the post or are a
+// moderator, reporting which.
+func (r *Resolver) authorOrModerator(ctx context.Context, postID int) (string, bool, error) {
+	user, err := signedIn(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	if r.Settings.Get().IsModerator(user) {
+		return user, true, nil
+	}
+	post, err := r.Storage.GetPostHeader(ctx, postID)
+	if err != nil {
+		return "", false, err
+	}
+	if post.Author != user {
+		return "", false, ErrNotAuthor
+	}
+	return user, false, nil
+}
+
+// checkBanned rejects the writes of banned users. Anonymous users can't be
+// told apart, so none of them is ever banned.
+func (r *Resolver) checkBanned(ctx context.Context, user string) error {
+	if user == "" {
+		return nil
+	}
+	banned, err := r.Storage.IsBanned(ctx, user)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrBanned
+	}
+	return nil
+}
+
+// visible returns comments as the viewer may see them: hidden comments are
+// replaced by placeholders unless the viewer is a moderator.
+func (r *Resolver) visible(ctx context.Context, comments []*model.Comment) []*model.Comment {
+	if r.Settings.Get().IsModerator(viewer.User(ctx)) {
+		return comments
+	}
+	visible := make([]*model.Comment, len(comments))
+	for i, comment := range comments {
+		if comment.Hidden {
+			comment = placeholder(comment)
+		}
+		visible[i] = comment
+	}
+	return visible
+}
+
+// placeholder returns a copy of a hidden comment without what it said or
+// who said it.
+func placeholder(comment *model.Comment) *model.Comment {
+	cp := *comment
+	cp.Body = ""
+	cp.Author = ""
+	cp.Mentions = nil
+	return &cp
+}
@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"context"
+	"log/slog"
+	"post-comments/pkg/model"
+)
+
+// notifyCommentAdded publishes a new comment on post to the subscribers of
+// the post and notifies the users it concerns: the author of the comment
+// replied to, the users mentioned and the author of the post, each once and
+// the most specifically, never the comment's own author.
+func (r *Resolver) notifyCommentAdded(ctx context.Context, post *model.Post, comment *model.Comment) {
+	r.Comments.Publish(comment.PostID, comment)
+
+	// anonymous users have no inbox
+	notified := map[string]bool{"": true, comment.Author: true}
+	notify := func(user string, kind model.NotificationKind) {
+		if notified[user] {
+			return
+		}
+		notified[user] = true
+		commentID := comment.ID
+		r.notify(ctx, &model.Notification{
+			User:      user,
+			Kind:      kind,
+			PostID:    comment.PostID,
+			CommentID: &commentID,
+			Actor:     comment.Author,
+		})
+	}
+
+	if comment.ParentID != nil {
+		parent, err := r.Storage.GetComment(ctx, *comment.ParentID)
+		if err != nil {
+			slog.WarnContext(ctx, "loading the parent of a new comment for notifications failed",
+				slog.Int("comment_id", comment.ID), slog.String("error", err.Error()))
+		} else {
+			notify(parent.Author, model.NotificationKindReply)
+		}
+	}
+	for _, user := range comment.Mentions {
+		notify(user, model.NotificationKindMention)
+	}
+	notify(post.Author, model.NotificationKindPostComment)
+}
+
+// notifyModeration tells the author of a post, or of one of its comments
+// when commentID is set, that actor, a moderator, acted on it.
+func (r *Resolver) notifyModeration(ctx context.Context, author string, postID int, commentID *int, actor string) {
+	if author == "" || author == actor {
+		return
+	}
+	r.notify(ctx, &model.Notification{
+		User:      author,
+		Kind:      model.NotificationKindModeration,
+		PostID:    postID,
+		CommentID: commentID,
+		Actor:     actor,
+	})
+}
+
+// notify saves a notification and delivers it to the user's subscriptions.
+// What caused it being done already, failures are logged rather than
+// failing the mutation.
+func (r *Resolver) notify(ctx context.Context, notification *model.Notification) {
+	if err := r.Storage.CreateNotification(ctx, notification); err != nil {
+		slog.WarnContext(ctx, "creating notification failed",
+			slog.String("kind", string(notification.Kind)), slog.String("error", err.Error()))
+		return
+	}
+	r.Notifications.Publish(notification.User, notification)
+}
+
+func (r *notificationResolver) Actor(ctx context.Context, obj *model.Notification) (*string, error) {
+	return author(obj.Actor), nil
+}
@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"post-comments"
+	"post-comments/pkg/model"
+	"post-comments/pkg/viewer"
+	"slices"
+	"sort"
+)
+
+var ErrNotSignedIn = errors.New("you must be signed in")
+
+func signedIn(ctx context.Context) (string, error) {
+	user := viewer.User(ctx)
+	if user == "" {
+		return "", ErrNotSignedIn
+	}
+	return user, nil
+}
+
+// setReaction adds or removes the viewer's reaction and publishes the change
+// to the post's subscribers. Emojis no longer configured can still be
+// removed.
+func (r *Resolver) setReaction(ctx context.Context, input post_comments.ReactionInput, add bool) (*post_comments.ReactionEvent, error) {
+	user, err := signedIn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkBanned(ctx, user); err != nil {
+		return nil, err
+	}
+	limits := r.Settings.Get()
+	if add && !slices.Contains(limits.Reactions, input.Emoji) {
+		return nil, fmt.Errorf("unknown reaction %q", input.Emoji)
+	}
+	if err := r.checkRateLimit(ctx); err != nil {
+		return nil, err
+	}
+
+	reaction := &model.Reaction{
+		PostID:    input.PostID,
+		CommentID: input.CommentID,
+		User:      user,
+		Emoji:     input.Emoji,
+	}
+	var changed bool
+	if add {
+		changed, err = r.Storage.AddReaction(ctx, reaction)
+	} else {
+		changed, err = r.Storage.RemoveReaction(ctx, reaction)
+	}
+	if err != nil {
+		return nil, err
+	}
+	counts, err := r.reactionCounts(ctx, input.PostID, input.CommentID)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &post_comments.ReactionEvent{
+		PostID:         input.PostID,
+		CommentID:      input.CommentID,
+		User:           user,
+		Emoji:          input.Emoji,
+		Added:          add,
+		ReactionCounts: counts,
+	}
+	if changed {
+		r.Reactions.Publish(input.PostID, event)
+	}
+	return event, nil
+}
+
+// reactionCounts lists the counts in the configured order of the emojis,
+// those no longer configured going last.
+func (r *Resolver) reactionCounts(ctx context.Context, postID int, commentID *int) ([]*model.ReactionCount, error) {
+	counts, err := r.Storage.GetReactionCounts(ctx, postID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	order := r.Settings.Get().Reactions
+	rank := func(emoji string) int {
+		if i := slices.Index(order, emoji); i >= 0 {
+			return i
+		}
+		return len(order)
+	}
+	sort.SliceStable(counts, func(i, j int) bool {
+		return rank(counts[i].Emoji) < rank(counts[j].Emoji)
+	})
+	return counts, nil
+}
+
+func (r *Resolver) viewerReactions(ctx context.Context, postID int, commentID *int) ([]string, error) {
+	user := viewer.User(ctx)
+	if user == "" {
+		return []string{}, nil
+	}
+	return r.Storage.GetUserReactions(ctx, postID, commentID, user)
+}
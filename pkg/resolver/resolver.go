@@ -3,139 +3,524 @@ package resolver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"post-comments/pkg/broker"
 	"post-comments/pkg/generated"
-	"sync"
-	"unicode/utf8"
+	"post-comments/pkg/markdown"
+	"post-comments/pkg/moderation"
+	"post-comments/pkg/ratelimit"
+	"post-comments/pkg/server"
+	"post-comments/pkg/settings"
+	"post-comments/pkg/spam"
+	"post-comments/pkg/viewer"
+	"strings"
 
 	"post-comments"
 	"post-comments/pkg/model"
 	"post-comments/pkg/storage"
 )
 
-const CommentMaxLen = 2000
-
-var commentAddedChannels map[int][]chan *model.Comment
-var mu sync.Mutex
-
-func init() {
-	commentAddedChannels = make(map[int][]chan *model.Comment)
-}
-
 type Resolver struct {
-	Storage storage.Storage
+	Storage  storage.Storage
+	Comments *broker.Broker[int, *model.Comment]
+	// Reactions publishes reaction changes on the post's topic.
+	Reactions *broker.Broker[int, *post_comments.ReactionEvent]
+	// Notifications publishes new notifications on their user's topic.
+	Notifications *broker.Broker[string, *model.Notification]
+	// Settings holds the limits, which may change while the server runs.
+	Settings *settings.Settings
+	// Recent remembers the bodies the duplicates filter compares with.
+	Recent *moderation.Recent
+	// Spam flags new comments looking like the ones moderators hid.
+	Spam *spam.Classifier
+	// Markdown keeps the HTML of recently read bodies.
+	Markdown *markdown.Cache
+	Limiter  *ratelimit.Limiter
 }
 
 func NewResolver(storage storage.Storage) *Resolver {
-	return &Resolver{Storage: storage}
+	return NewResolverWithBroker(storage, broker.Config{})
+}
+
+func NewResolverWithBroker(storage storage.Storage, cfg broker.Config) *Resolver {
+	commentsCfg, reactionsCfg, notificationsCfg := cfg, cfg, cfg
+	commentsCfg.OnDisconnect = subscriptionError("commentAdded", "postId")
+	reactionsCfg.OnDisconnect = subscriptionError("reactionChanged", "postId")
+	notificationsCfg.OnDisconnect = subscriptionError("notificationReceived", "")
+	r := &Resolver{
+		Storage:       storage,
+		Comments:      broker.New[int, *model.Comment](commentsCfg),
+		Reactions:     broker.New[int, *post_comments.ReactionEvent](reactionsCfg),
+		Notifications: broker.New[string, *model.Notification](notificationsCfg),
+		Settings:      settings.New(settings.DefaultLimits()),
+		Recent:        moderation.NewRecent(),
+		Spam:          spam.New(storage),
+		Markdown:      markdown.NewCache(markdown.DefaultCacheSize),
+	}
+	r.Limiter = ratelimit.New(func() (int, int) {
+		limit := r.Settings.Get().RateLimit
+		return limit.PerMinute, limit.Burst
+	})
+	return r
 }
 
+type commentResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
+type notificationResolver struct{ *Resolver }
+type postResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
 type subscriptionResolver struct{ *Resolver }
 
+func (r *commentResolver) Author(ctx context.Context, obj *model.Comment) (*string, error) {
+	return author(obj.Author), nil
+}
+
+func (r *commentResolver) BodyHTML(ctx context.Context, obj *model.Comment) (string, error) {
+	return r.Markdown.Render(revision("comment", obj.ID, obj.UpdatedAt), obj.Body), nil
+}
+
+func (r *commentResolver) ViewerVote(ctx context.Context, obj *model.Comment) (post_comments.VoteValue, error) {
+	user := viewer.User(ctx)
+	if user == "" {
+		return post_comments.VoteValueNone, nil
+	}
+	value, err := r.Storage.GetUserVote(ctx, obj.ID, user)
+	if err != nil {
+		return "", err
+	}
+	return voteValue(value), nil
+}
+
+func (r *commentResolver) ReactionCounts(ctx context.Context, obj *model.Comment) ([]*model.ReactionCount, error) {
+	return r.reactionCounts(ctx, obj.PostID, &obj.ID)
+}
+
+func (r *commentResolver) ViewerReactions(ctx context.Context, obj *model.Comment) ([]string, error) {
+	return r.viewerReactions(ctx, obj.PostID, &obj.ID)
+}
+
 func (r *mutationResolver) CreatePost(ctx context.Context, input post_comments.NewPost) (*model.Post, error) {
+	limits := r.Settings.Get()
+	if tooLong(input.Title, limits.PostTitleMaxLen) {
+		return nil, errors.New("title too long")
+	}
+	if tooLong(input.Body, limits.PostBodyMaxLen) {
+		return nil, errors.New("body too long")
+	}
+	user := viewer.User(ctx)
+	if err := r.checkBanned(ctx, user); err != nil {
+		return nil, err
+	}
+	var profile string
+	if input.FilterProfile != nil {
+		profile = strings.ToLower(*input.FilterProfile)
+		if !limits.Filters.HasProfile(profile) {
+			return nil, fmt.Errorf("unknown filter profile %q", *input.FilterProfile)
+		}
+	}
+	tags, err := normalizeTags(input.Tags, limits.MaxTags)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkRateLimit(ctx); err != nil {
+		return nil, err
+	}
+	chain := limits.Filters.Chain(profile, r.Recent)
+	title, err := r.filterTitle(ctx, chain, user, input.Title)
+	if err != nil {
+		return nil, err
+	}
+	filtered, err := r.filter(ctx, chain, user, input.Body)
+	if err != nil {
+		return nil, err
+	}
+
 	post := &model.Post{
-		Title:    input.Title,
-		Body:     input.Body,
-		Comments: []*model.Comment{},
+		Title:         title.Body,
+		Body:          filtered.Body,
+		Author:        user,
+		FilterProfile: profile,
+		Tags:          tags,
+		Comments:      []*model.Comment{},
 	}
-	err := r.Storage.CreatePost(ctx, post)
+	err = r.Storage.CreatePost(ctx, post)
 	if err != nil {
 		return nil, err
 	}
+	r.sent(ctx, user, post.Body)
+	logFlags(ctx, post, append(title.Flags, filtered.Flags...))
+	return post, nil
+}
+
+func (r *mutationResolver) UpdatePost(ctx context.Context, id int, input post_comments.UpdatePost) (*model.Post, error) {
+	limits := r.Settings.Get()
+	user, err := signedIn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkBanned(ctx, user); err != nil {
+		return nil, err
+	}
+	post, err := r.Storage.GetPostHeader(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if post.Author != user && !limits.IsModerator(user) {
+		return nil, ErrNotAuthor
+	}
+	if input.Title != nil && tooLong(*input.Title, limits.PostTitleMaxLen) {
+		return nil, errors.New("title too long")
+	}
+	if input.Body != nil && tooLong(*input.Body, limits.PostBodyMaxLen) {
+		return nil, errors.New("body too long")
+	}
+	var update storage.PostUpdate
+	if input.Tags != nil {
+		tags, err := normalizeTags(input.Tags, limits.MaxTags)
+		if err != nil {
+			return nil, err
+		}
+		update.Tags = &tags
+	}
+	if err := r.checkRateLimit(ctx); err != nil {
+		return nil, err
+	}
+	// what didn't change passed the filters already
+	chain := limits.Filters.Chain(post.FilterProfile, r.Recent)
+	var flags []moderation.FlagReason
+	if input.Title != nil && *input.Title != post.Title {
+		title, err := r.filterTitle(ctx, chain, user, *input.Title)
+		if err != nil {
+			return nil, err
+		}
+		update.Title, flags = &title.Body, title.Flags
+	}
+	if input.Body != nil && *input.Body != post.Body {
+		filtered, err := r.filterEdit(ctx, chain, user, *input.Body)
+		if err != nil {
+			return nil, err
+		}
+		update.Body, flags = &filtered.Body, append(flags, filtered.Flags...)
+	}
+
+	post, err = r.Storage.UpdatePost(ctx, id, update)
+	if err != nil {
+		return nil, err
+	}
+	if update.Body != nil {
+		r.sent(ctx, user, *update.Body)
+	}
+	logFlags(ctx, post, flags)
 	return post, nil
 }
 
 func (r *mutationResolver) CreateComment(ctx context.Context, input post_comments.NewComment) (*model.Comment, error) {
-	if utf8.RuneCountInString(input.Body) > CommentMaxLen {
+	limits := r.Settings.Get()
+	if tooLong(input.Body, limits.CommentMaxLen) {
 		return nil, errors.New("body too long")
 	}
+	user := viewer.User(ctx)
+	if err := r.checkBanned(ctx, user); err != nil {
+		return nil, err
+	}
+	if err := r.checkThreadDepth(ctx, input.ParentID, limits.MaxThreadDepth); err != nil {
+		return nil, err
+	}
+	// the post's header picks the filters and whom to notify
+	post, err := r.Storage.GetPostHeader(ctx, input.PostID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkRateLimit(ctx); err != nil {
+		return nil, err
+	}
+	chain := append(limits.Filters.Chain(post.FilterProfile, r.Recent), r.Spam.Filter(limits.Spam))
+	filtered, err := r.filter(ctx, chain, user, input.Body)
+	if err != nil {
+		return nil, err
+	}
+	mentions, err := r.resolveMentions(ctx, filtered.Body, limits.MaxMentions)
+	if err != nil {
+		return nil, err
+	}
 
 	comment := &model.Comment{
 		PostID:   input.PostID,
 		ParentID: input.ParentID,
-		Body:     input.Body,
+		Body:     filtered.Body,
+		Author:   user,
+		Mentions: mentions,
 	}
-	err := r.Storage.CreateComment(ctx, comment)
+	err = r.Storage.CreateComment(ctx, comment)
 	if err != nil {
 		return nil, err
 	}
+	r.sent(ctx, user, comment.Body)
 
-	// notify subscribers
-	r.notifyCommentAdded(comment.PostID, comment)
+	r.reportFlags(ctx, comment, filtered.Flags)
+	r.notifyCommentAdded(ctx, post, comment)
 	return comment, nil
 }
 
 func (r *mutationResolver) DisableComments(ctx context.Context, postID int) (*model.Post, error) {
+	user, moderator, err := r.authorOrModerator(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
 	post, err := r.Storage.DisableComments(ctx, postID)
 	if err != nil {
 		return nil, err
 	}
+	if moderator {
+		r.notifyModeration(ctx, post.Author, post.ID, nil, user)
+	}
 	return post, nil
 }
 
 func (r *mutationResolver) EnableComments(ctx context.Context, postID int) (*model.Post, error) {
+	user, moderator, err := r.authorOrModerator(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
 	post, err := r.Storage.EnableComments(ctx, postID)
 	if err != nil {
 		return nil, err
 	}
+	if moderator {
+		r.notifyModeration(ctx, post.Author, post.ID, nil, user)
+	}
 	return post, nil
 }
 
-func (r *queryResolver) Posts(ctx context.Context) ([]*model.Post, error) {
-	return r.Storage.GetPosts(ctx)
+func (r *mutationResolver) AddReaction(ctx context.Context, input post_comments.ReactionInput) (*post_comments.ReactionEvent, error) {
+	return r.setReaction(ctx, input, true)
 }
 
-func (r *queryResolver) Post(ctx context.Context, id int) (*model.Post, error) {
-	return r.Storage.GetPost(ctx, id)
+func (r *mutationResolver) RemoveReaction(ctx context.Context, input post_comments.ReactionInput) (*post_comments.ReactionEvent, error) {
+	return r.setReaction(ctx, input, false)
 }
 
-// CommentAdded is the resolver for the commentAdded field.
-func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID int) (<-chan *model.Comment, error) {
-	commentChannel := make(chan *model.Comment, 1)
+func (r *mutationResolver) VoteComment(ctx context.Context, commentID int, value post_comments.VoteValue) (*model.Comment, error) {
+	user, err := signedIn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkBanned(ctx, user); err != nil {
+		return nil, err
+	}
+	if err := r.checkRateLimit(ctx); err != nil {
+		return nil, err
+	}
+	comment, err := r.Storage.Vote(ctx, &model.Vote{CommentID: commentID, User: user, Value: voteValues[value]})
+	if err != nil {
+		return nil, err
+	}
+	return r.visible(ctx, []*model.Comment{comment})[0], nil
+}
 
-	mu.Lock()
-	commentAddedChannels[postID] = append(commentAddedChannels[postID], commentChannel)
-	mu.Unlock()
+func (r *mutationResolver) MarkNotificationsRead(ctx context.Context, ids []int) (int, error) {
+	user, err := signedIn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return r.Storage.MarkNotificationsRead(ctx, user, ids)
+}
 
-	go func() {
-		<-ctx.Done()
-		r.unsubscribeFromComments(postID, commentChannel)
-	}()
+func (r *mutationResolver) ReportComment(ctx context.Context, commentID int, reason string) (*model.Report, error) {
+	user, err := signedIn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, errors.New("reason must not be empty")
+	}
+	if tooLong(reason, r.Settings.Get().CommentMaxLen) {
+		return nil, errors.New("reason too long")
+	}
+	if err := r.checkBanned(ctx, user); err != nil {
+		return nil, err
+	}
+	if err := r.checkRateLimit(ctx); err != nil {
+		return nil, err
+	}
+	report := &model.Report{CommentID: commentID, Reporter: user, Reason: reason}
+	if err := r.Storage.ReportComment(ctx, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
 
-	return commentChannel, nil
+func (r *mutationResolver) ResolveReport(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, error) {
+	user, err := r.moderator(ctx)
+	if err != nil {
+		return nil, err
+	}
+	comment, closed, err := r.Storage.ResolveReports(ctx, commentID, action)
+	if err != nil {
+		return nil, err
+	}
+	// without open reports there is no decision to learn from, and a
+	// repeated resolution would count the comment twice
+	if closed > 0 {
+		r.trainSpam(ctx, comment, action)
+	}
+	if action != model.ModerationActionDismiss {
+		r.notifyModeration(ctx, comment.Author, comment.PostID, &comment.ID, user)
+	}
+	return comment, nil
 }
 
-func (r *subscriptionResolver) unsubscribeFromComments(postID int, ch chan *model.Comment) {
-	mu.Lock()
-	defer mu.Unlock()
-	channels := commentAddedChannels[postID]
-	for i, c := range channels {
-		if c == ch {
-			commentAddedChannels[postID] = append(channels[:i], channels[i+1:]...)
-			close(c)
-			break
+func (r *postResolver) Author(ctx context.Context, obj *model.Post) (*string, error) {
+	return author(obj.Author), nil
+}
+
+func (r *postResolver) BodyHTML(ctx context.Context, obj *model.Post) (string, error) {
+	return r.Markdown.Render(revision("post", obj.ID, obj.UpdatedAt), obj.Body), nil
+}
+
+func (r *postResolver) FilterProfile(ctx context.Context, obj *model.Post) (*string, error) {
+	if obj.FilterProfile == "" {
+		return nil, nil
+	}
+	return &obj.FilterProfile, nil
+}
+
+func (r *postResolver) Comments(ctx context.Context, obj *model.Post, orderBy *model.CommentOrder, first *int, after *int) ([]*model.Comment, error) {
+	if orderBy == nil && first == nil && after == nil {
+		return r.visible(ctx, obj.Comments), nil
+	}
+	q := storage.CommentQuery{
+		PostID:  obj.ID,
+		Order:   model.CommentOrderOldest,
+		After:   after,
+		Ranking: r.Settings.Get().Ranking,
+	}
+	if orderBy != nil {
+		q.Order = *orderBy
+	}
+	if first != nil {
+		if *first <= 0 {
+			return nil, errors.New("first must be positive")
 		}
+		q.Limit = *first
 	}
+	comments, err := r.Storage.GetComments(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return r.visible(ctx, comments), nil
+}
+
+func (r *postResolver) ReactionCounts(ctx context.Context, obj *model.Post) ([]*model.ReactionCount, error) {
+	return r.reactionCounts(ctx, obj.ID, nil)
+}
+
+func (r *postResolver) ViewerReactions(ctx context.Context, obj *model.Post) ([]string, error) {
+	return r.viewerReactions(ctx, obj.ID, nil)
+}
+
+func (r *queryResolver) Posts(ctx context.Context, tag *string) ([]*model.Post, error) {
+	if tag == nil {
+		return r.Storage.GetPosts(ctx)
+	}
+	normalized, err := normalizeTag(*tag)
+	if err != nil {
+		return nil, err
+	}
+	return r.Storage.GetPostsByTag(ctx, normalized)
+}
+
+func (r *queryResolver) Post(ctx context.Context, id int) (*model.Post, error) {
+	return r.Storage.GetPost(ctx, id)
+}
+
+func (r *queryResolver) Notifications(ctx context.Context, first *int, after *int, unreadOnly *bool) ([]*model.Notification, error) {
+	user, err := signedIn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := storage.NotificationQuery{User: user, After: after}
+	if first != nil {
+		if *first <= 0 {
+			return nil, errors.New("first must be positive")
+		}
+		q.Limit = *first
+	}
+	if unreadOnly != nil {
+		q.UnreadOnly = *unreadOnly
+	}
+	return r.Storage.GetNotifications(ctx, q)
 }
 
-func (r *Resolver) notifyCommentAdded(postID int, comment *model.Comment) {
-	mu.Lock()
-	channels := commentAddedChannels[postID]
-	mu.Unlock()
+func (r *queryResolver) Tags(ctx context.Context) ([]*model.TagCount, error) {
+	return r.Storage.GetTags(ctx)
+}
 
-	for _, ch := range channels {
-		select {
-		case ch <- comment:
-		default:
-			// If sending fails, it means the receiver is not listening anymore.
+func (r *queryResolver) ModerationQueue(ctx context.Context, first *int) ([]*model.ReportedComment, error) {
+	if _, err := r.moderator(ctx); err != nil {
+		return nil, err
+	}
+	limit := 0
+	if first != nil {
+		if *first <= 0 {
+			return nil, errors.New("first must be positive")
 		}
+		limit = *first
 	}
+	return r.Storage.GetModerationQueue(ctx, limit)
+}
+
+// CommentAdded is the resolver for the commentAdded field.
+func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID int) (<-chan *model.Comment, error) {
+	return r.Comments.Subscribe(ctx, postID), nil
+}
+
+// ReactionChanged is the resolver for the reactionChanged field.
+func (r *subscriptionResolver) ReactionChanged(ctx context.Context, postID int) (<-chan *post_comments.ReactionEvent, error) {
+	return r.Reactions.Subscribe(ctx, postID), nil
 }
 
+// NotificationReceived is the resolver for the notificationReceived field.
+func (r *subscriptionResolver) NotificationReceived(ctx context.Context) (<-chan *model.Notification, error) {
+	user, err := signedIn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.Notifications.Subscribe(ctx, user), nil
+}
+
+// subscriptionError returns a function reporting why a subscription to
+// field is being closed, arg naming the argument the topic came from, if
+// any. Only the websocket transport keeps a place for such errors in the
+// context, other subscriptions just end.
+func subscriptionError(field, arg string) func(ctx context.Context, topic any, err error) {
+	return func(ctx context.Context, topic any, err error) {
+		if !server.CanAddSubscriptionError(ctx) {
+			return
+		}
+		subscription := field
+		if arg != "" {
+			subscription = fmt.Sprintf("%s(%s: %v)", field, arg, topic)
+		}
+		transport.AddSubscriptionError(ctx, gqlerror.Errorf("%s: %s", subscription, err))
+	}
+}
+
+// Comment returns generated.CommentResolver implementation.
+func (r *Resolver) Comment() generated.CommentResolver { return &commentResolver{r} }
+
 // Mutation returns generated.MutationResolver implementation.
 func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
 
+// Notification returns generated.NotificationResolver implementation.
+func (r *Resolver) Notification() generated.NotificationResolver { return &notificationResolver{r} }
+
+// Post returns generated.PostResolver implementation.
+func (r *Resolver) Post() generated.PostResolver { return &postResolver{r} }
+
 // Query returns generated.QueryResolver implementation.
 func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 
@@ -2,13 +2,21 @@ package resolver
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/vektah/gqlparser/v2/ast"
 	"post-comments"
 	"post-comments/pkg/model"
+	"post-comments/pkg/moderation"
+	"post-comments/pkg/spam"
+	"post-comments/pkg/storage"
+	"post-comments/pkg/viewer"
 )
 
 type MockStorage struct {
@@ -30,6 +38,26 @@ func (m *MockStorage) GetPost(ctx context.Context, id int) (*model.Post, error)
 	return args.Get(0).(*model.Post), args.Error(1)
 }
 
+func (m *MockStorage) GetPostHeader(ctx context.Context, id int) (*model.Post, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Post), args.Error(1)
+}
+
+func (m *MockStorage) UpdatePost(ctx context.Context, id int, update storage.PostUpdate) (*model.Post, error) {
+	args := m.Called(ctx, id, update)
+	return args.Get(0).(*model.Post), args.Error(1)
+}
+
+func (m *MockStorage) GetPostsByTag(ctx context.Context, tag string) ([]*model.Post, error) {
+	args := m.Called(ctx, tag)
+	return args.Get(0).([]*model.Post), args.Error(1)
+}
+
+func (m *MockStorage) GetTags(ctx context.Context) ([]*model.TagCount, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]*model.TagCount), args.Error(1)
+}
+
 func (m *MockStorage) CreateComment(ctx context.Context, comment *model.Comment) error {
 	args := m.Called(ctx, comment)
 	return args.Error(0)
@@ -45,6 +73,101 @@ func (m *MockStorage) EnableComments(ctx context.Context, postID int) (*model.Po
 	return args.Get(0).(*model.Post), args.Error(1)
 }
 
+func (m *MockStorage) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	args := m.Called(ctx, reaction)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	args := m.Called(ctx, reaction)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) GetReactionCounts(ctx context.Context, postID int, commentID *int) ([]*model.ReactionCount, error) {
+	args := m.Called(ctx, postID, commentID)
+	return args.Get(0).([]*model.ReactionCount), args.Error(1)
+}
+
+func (m *MockStorage) GetUserReactions(ctx context.Context, postID int, commentID *int, user string) ([]string, error) {
+	args := m.Called(ctx, postID, commentID, user)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockStorage) Vote(ctx context.Context, vote *model.Vote) (*model.Comment, error) {
+	args := m.Called(ctx, vote)
+	return args.Get(0).(*model.Comment), args.Error(1)
+}
+
+func (m *MockStorage) GetUserVote(ctx context.Context, commentID int, user string) (int, error) {
+	args := m.Called(ctx, commentID, user)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) GetComments(ctx context.Context, q storage.CommentQuery) ([]*model.Comment, error) {
+	args := m.Called(ctx, q)
+	return args.Get(0).([]*model.Comment), args.Error(1)
+}
+
+func (m *MockStorage) GetComment(ctx context.Context, id int) (*model.Comment, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Comment), args.Error(1)
+}
+
+func (m *MockStorage) GetCommentDepth(ctx context.Context, id int) (int, error) {
+	args := m.Called(ctx, id)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) ResolveUsers(ctx context.Context, names []string) ([]string, error) {
+	args := m.Called(ctx, names)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockStorage) CreateNotification(ctx context.Context, notification *model.Notification) error {
+	args := m.Called(ctx, notification)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetNotifications(ctx context.Context, q storage.NotificationQuery) ([]*model.Notification, error) {
+	args := m.Called(ctx, q)
+	return args.Get(0).([]*model.Notification), args.Error(1)
+}
+
+func (m *MockStorage) MarkNotificationsRead(ctx context.Context, user string, ids []int) (int, error) {
+	args := m.Called(ctx, user, ids)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) ReportComment(ctx context.Context, report *model.Report) error {
+	args := m.Called(ctx, report)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetModerationQueue(ctx context.Context, limit int) ([]*model.ReportedComment, error) {
+	args := m.Called(ctx, limit)
+	return args.Get(0).([]*model.ReportedComment), args.Error(1)
+}
+
+func (m *MockStorage) ResolveReports(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, int, error) {
+	args := m.Called(ctx, commentID, action)
+	return args.Get(0).(*model.Comment), args.Int(1), args.Error(2)
+}
+
+func (m *MockStorage) IsBanned(ctx context.Context, user string) (bool, error) {
+	args := m.Called(ctx, user)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) TrainSpam(ctx context.Context, tokens []string, spam bool) error {
+	args := m.Called(ctx, tokens, spam)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetSpamModel(ctx context.Context) (*model.SpamModel, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(*model.SpamModel), args.Error(1)
+}
+
 func TestCreatePost(t *testing.T) {
 	ctx := context.TODO()
 	postInput := post_comments.NewPost{
@@ -89,6 +212,7 @@ func TestCreateComment(t *testing.T) {
 
 	mockStorage := new(MockStorage)
 	mockStorage.On("CreateComment", ctx, mock.AnythingOfType("*model.Comment")).Return(nil)
+	mockStorage.On("GetPostHeader", ctx, 1).Return(&model.Post{ID: 1}, nil)
 
 	resolver := NewResolver(mockStorage)
 	mutResolver := resolver.Mutation()
@@ -102,6 +226,135 @@ func TestCreateComment(t *testing.T) {
 
 }
 
+func TestCreateCommentNotifications(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "alice")
+	parentID := 3
+	post := &model.Post{ID: 1, Author: "dave"}
+
+	var notifications []*model.Notification
+	mockStorage := new(MockStorage)
+	mockStorage.On("IsBanned", ctx, "alice").Return(false, nil)
+	mockStorage.On("ResolveUsers", ctx, []string{"Bob", "alice", "nobody", "carol", "erin"}).Return([]string{"bob", "alice", "carol", "erin"}, nil)
+	mockStorage.On("GetPostHeader", ctx, 1).Return(post, nil)
+	mockStorage.On("GetComment", ctx, parentID).Return(&model.Comment{ID: parentID, PostID: 1, Author: "erin"}, nil)
+	mockStorage.On("CreateComment", ctx, mock.AnythingOfType("*model.Comment")).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(1).(*model.Comment).ID = 7
+	})
+	mockStorage.On("CreateNotification", ctx, mock.AnythingOfType("*model.Notification")).Return(nil).Run(func(args mock.Arguments) {
+		notifications = append(notifications, args.Get(1).(*model.Notification))
+	})
+
+	resolver := NewResolver(mockStorage)
+	limits := resolver.Settings.Get()
+	limits.MaxMentions = 3
+	resolver.Settings.Set(limits)
+	received := resolver.Notifications.Subscribe(ctx, "bob")
+
+	result, err := resolver.Mutation().CreateComment(ctx, post_comments.NewComment{
+		PostID:   1,
+		ParentID: &parentID,
+		Body:     "@Bob, @alice, @nobody, @carol and @erin",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", result.Author)
+	assert.Equal(t, []string{"bob", "alice", "carol"}, result.Mentions)
+
+	// the author's own mention is skipped, erin was past the limit but is
+	// notified of the reply anyway
+	commentID := 7
+	notification := func(user string, kind model.NotificationKind) *model.Notification {
+		return &model.Notification{User: user, Kind: kind, PostID: 1, CommentID: &commentID, Actor: "alice"}
+	}
+	assert.Equal(t, []*model.Notification{
+		notification("erin", model.NotificationKindReply),
+		notification("bob", model.NotificationKindMention),
+		notification("carol", model.NotificationKindMention),
+		notification("dave", model.NotificationKindPostComment),
+	}, notifications)
+	assert.Equal(t, notifications[1], <-received)
+}
+
+func TestSubscriptionErrorOutsideWebsocket(t *testing.T) {
+	report := subscriptionError("commentAdded", "postId")
+	// as over Server-Sent Events, which have no place for the error
+	ctx := graphql.WithOperationContext(context.TODO(), &graphql.OperationContext{
+		Operation: &ast.OperationDefinition{Operation: ast.Subscription},
+	})
+
+	assert.NotPanics(t, func() {
+		report(context.TODO(), 1, errors.New("too slow"))
+		report(ctx, 1, errors.New("too slow"))
+	})
+}
+
+func TestNotificationsRequireSignIn(t *testing.T) {
+	ctx := context.TODO()
+	resolver := NewResolver(new(MockStorage))
+
+	_, err := resolver.Query().Notifications(ctx, nil, nil, nil)
+	assert.ErrorIs(t, err, ErrNotSignedIn)
+	_, err = resolver.Mutation().MarkNotificationsRead(ctx, nil)
+	assert.ErrorIs(t, err, ErrNotSignedIn)
+	_, err = resolver.Subscription().NotificationReceived(ctx)
+	assert.ErrorIs(t, err, ErrNotSignedIn)
+}
+
+func TestNotifications(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "alice")
+	page := []*model.Notification{{ID: 2, User: "alice"}}
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetNotifications", ctx, storage.NotificationQuery{User: "alice", After: nil, Limit: 5, UnreadOnly: true}).Return(page, nil)
+	mockStorage.On("MarkNotificationsRead", ctx, "alice", []int{2}).Return(1, nil)
+
+	resolver := NewResolver(mockStorage)
+	first, unreadOnly := 5, true
+	result, err := resolver.Query().Notifications(ctx, &first, nil, &unreadOnly)
+	assert.NoError(t, err)
+	assert.Equal(t, page, result)
+
+	marked, err := resolver.Mutation().MarkNotificationsRead(ctx, []int{2})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, marked)
+}
+
+func TestDisableCommentsNotifiesAuthor(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "mod")
+	post := &model.Post{ID: 1, Author: "alice", CommentsDisabled: true}
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("DisableComments", ctx, 1).Return(post, nil)
+	mockStorage.On("CreateNotification", ctx, &model.Notification{
+		User:   "alice",
+		Kind:   model.NotificationKindModeration,
+		PostID: 1,
+		Actor:  "mod",
+	}).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	limits := resolver.Settings.Get()
+	limits.Moderators = []string{"mod"}
+	resolver.Settings.Set(limits)
+	_, err := resolver.Mutation().DisableComments(ctx, 1)
+	assert.NoError(t, err)
+	mockStorage.AssertNumberOfCalls(t, "CreateNotification", 1)
+}
+
+func TestDisableCommentsByOthers(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "bob")
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetPostHeader", ctx, 1).Return(&model.Post{ID: 1, Author: "alice"}, nil)
+
+	resolver := NewResolver(mockStorage)
+	_, err := resolver.Mutation().DisableComments(ctx, 1)
+	assert.ErrorIs(t, err, ErrNotAuthor)
+	_, err = resolver.Mutation().EnableComments(context.TODO(), 1)
+	assert.ErrorIs(t, err, ErrNotSignedIn)
+	mockStorage.AssertNumberOfCalls(t, "DisableComments", 0)
+	mockStorage.AssertNumberOfCalls(t, "EnableComments", 0)
+}
+
 func TestCreateCommentLongBody(t *testing.T) {
 	ctx := context.TODO()
 
@@ -134,12 +387,14 @@ func TestCreateCommentLongBody(t *testing.T) {
 }
 
 func TestEnableComment(t *testing.T) {
-	ctx := context.TODO()
+	ctx := viewer.WithUser(context.TODO(), "alice")
 
 	mockStorage := new(MockStorage)
 	expectedPost := &model.Post{
+		Author:           "alice",
 		CommentsDisabled: false,
 	}
+	mockStorage.On("GetPostHeader", ctx, 1).Return(&model.Post{ID: 1, Author: "alice"}, nil)
 
 	mockStorage.On("EnableComments", ctx, 1).Return(expectedPost, nil)
 
@@ -154,12 +409,14 @@ func TestEnableComment(t *testing.T) {
 }
 
 func TestDisableComment(t *testing.T) {
-	ctx := context.TODO()
+	ctx := viewer.WithUser(context.TODO(), "alice")
 
 	mockStorage := new(MockStorage)
 	expectedPost := &model.Post{
+		Author:           "alice",
 		CommentsDisabled: true,
 	}
+	mockStorage.On("GetPostHeader", ctx, 1).Return(&model.Post{ID: 1, Author: "alice"}, nil)
 
 	mockStorage.On("EnableComments", ctx, 1).Return(expectedPost, nil)
 
@@ -193,7 +450,7 @@ func TestGetPosts(t *testing.T) {
 	resolver := NewResolver(mockStorage)
 	qResolver := resolver.Query()
 
-	result, err := qResolver.Posts(ctx)
+	result, err := qResolver.Posts(ctx, nil)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedPosts, result)
@@ -222,3 +479,546 @@ func TestGetPost(t *testing.T) {
 
 	mockStorage.AssertNumberOfCalls(t, "GetPost", 1)
 }
+
+func TestCreateCommentTooDeep(t *testing.T) {
+	ctx := context.TODO()
+
+	first, second := 1, 2
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetCommentDepth", ctx, first).Return(1, nil)
+	mockStorage.On("GetCommentDepth", ctx, second).Return(2, nil)
+	mockStorage.On("GetPostHeader", ctx, 1).Return(&model.Post{ID: 1}, nil)
+	mockStorage.On("GetComment", ctx, first).Return(&model.Comment{ID: first, PostID: 1}, nil)
+	mockStorage.On("CreateComment", ctx, mock.AnythingOfType("*model.Comment")).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	limits := resolver.Settings.Get()
+	limits.MaxThreadDepth = 2
+	resolver.Settings.Set(limits)
+	mutResolver := resolver.Mutation()
+
+	_, err := mutResolver.CreateComment(ctx, post_comments.NewComment{PostID: 1, ParentID: &first, Body: "reply"})
+	assert.NoError(t, err)
+
+	_, err = mutResolver.CreateComment(ctx, post_comments.NewComment{PostID: 1, ParentID: &second, Body: "too deep"})
+	assert.Error(t, err)
+
+	mockStorage.AssertNumberOfCalls(t, "CreateComment", 1)
+}
+
+func TestAddReaction(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "alice")
+	counts := []*model.ReactionCount{{Emoji: "😂", Count: 1}, {Emoji: "👍", Count: 2}}
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("IsBanned", ctx, "alice").Return(false, nil)
+	mockStorage.On("AddReaction", ctx, mock.AnythingOfType("*model.Reaction")).Return(true, nil)
+	mockStorage.On("GetReactionCounts", ctx, 1, (*int)(nil)).Return(counts, nil)
+
+	resolver := NewResolver(mockStorage)
+	events := resolver.Reactions.Subscribe(ctx, 1)
+	mutResolver := resolver.Mutation()
+
+	_, err := mutResolver.AddReaction(context.TODO(), post_comments.ReactionInput{PostID: 1, Emoji: "👍"})
+	assert.ErrorIs(t, err, ErrNotSignedIn)
+	_, err = mutResolver.AddReaction(ctx, post_comments.ReactionInput{PostID: 1, Emoji: "🦄"})
+	assert.Error(t, err)
+
+	event, err := mutResolver.AddReaction(ctx, post_comments.ReactionInput{PostID: 1, Emoji: "👍"})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", event.User)
+	assert.True(t, event.Added)
+	// listed in the configured order
+	assert.Equal(t, "👍", event.ReactionCounts[0].Emoji)
+	assert.Equal(t, event, <-events)
+
+	mockStorage.AssertNumberOfCalls(t, "AddReaction", 1)
+}
+
+func TestVoteComment(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "alice")
+	voted := &model.Comment{ID: 3, Downvotes: 1}
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("IsBanned", ctx, "alice").Return(false, nil)
+	mockStorage.On("Vote", ctx, &model.Vote{CommentID: 3, User: "alice", Value: -1}).Return(voted, nil)
+	mockStorage.On("GetUserVote", ctx, 3, "alice").Return(-1, nil)
+
+	resolver := NewResolver(mockStorage)
+	_, err := resolver.Mutation().VoteComment(context.TODO(), 3, post_comments.VoteValueDown)
+	assert.ErrorIs(t, err, ErrNotSignedIn)
+
+	result, err := resolver.Mutation().VoteComment(ctx, 3, post_comments.VoteValueDown)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, result.Score())
+
+	vote, err := resolver.Comment().ViewerVote(ctx, result)
+	assert.NoError(t, err)
+	assert.Equal(t, post_comments.VoteValueDown, vote)
+	vote, err = resolver.Comment().ViewerVote(context.TODO(), result)
+	assert.NoError(t, err)
+	assert.Equal(t, post_comments.VoteValueNone, vote)
+}
+
+func TestPostCommentsOrdered(t *testing.T) {
+	ctx := context.TODO()
+	post := &model.Post{ID: 1, Comments: []*model.Comment{{ID: 1}}}
+	page := []*model.Comment{{ID: 2}}
+	top := model.CommentOrderTop
+	first := 10
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetComments", ctx, mock.MatchedBy(func(q storage.CommentQuery) bool {
+		return q.PostID == 1 && q.Order == top && q.Limit == first && q.Ranking.HotDecay > 0
+	})).Return(page, nil)
+
+	resolver := NewResolver(mockStorage)
+	comments, err := resolver.Post().Comments(ctx, post, nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, post.Comments, comments)
+
+	comments, err = resolver.Post().Comments(ctx, post, &top, &first, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, page, comments)
+
+	zero := 0
+	_, err = resolver.Post().Comments(ctx, post, &top, &zero, nil)
+	assert.Error(t, err)
+	mockStorage.AssertNumberOfCalls(t, "GetComments", 1)
+}
+
+func TestReportComment(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "alice")
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("IsBanned", ctx, "alice").Return(false, nil)
+	mockStorage.On("ReportComment", ctx, &model.Report{CommentID: 3, Reporter: "alice", Reason: "spam"}).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	_, err := resolver.Mutation().ReportComment(context.TODO(), 3, "spam")
+	assert.ErrorIs(t, err, ErrNotSignedIn)
+	_, err = resolver.Mutation().ReportComment(ctx, 3, "  ")
+	assert.Error(t, err)
+	_, err = resolver.Mutation().ReportComment(ctx, 3, strings.Repeat("a", 2001))
+	assert.Error(t, err)
+
+	report, err := resolver.Mutation().ReportComment(ctx, 3, " spam\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "spam", report.Reason)
+	mockStorage.AssertNumberOfCalls(t, "ReportComment", 1)
+}
+
+func TestModerationRequiresModerator(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "alice")
+	resolver := NewResolver(new(MockStorage))
+
+	_, err := resolver.Query().ModerationQueue(context.TODO(), nil)
+	assert.ErrorIs(t, err, ErrNotSignedIn)
+	_, err = resolver.Query().ModerationQueue(ctx, nil)
+	assert.ErrorIs(t, err, ErrNotModerator)
+	_, err = resolver.Mutation().ResolveReport(ctx, 3, model.ModerationActionHide)
+	assert.ErrorIs(t, err, ErrNotModerator)
+}
+
+func TestResolveReport(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "mod")
+	queue := []*model.ReportedComment{{
+		Comment: &model.Comment{ID: 3, PostID: 1, Author: "bob"},
+		Reports: []*model.Report{{CommentID: 3, Reporter: "alice", Reason: "spam"}},
+	}}
+	commentID := 3
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetModerationQueue", ctx, 10).Return(queue, nil)
+	mockStorage.On("ResolveReports", ctx, 3, model.ModerationActionDismiss).Return(&model.Comment{ID: 3, PostID: 1, Author: "bob"}, 1, nil)
+	mockStorage.On("ResolveReports", ctx, 3, model.ModerationActionBan).Return(&model.Comment{ID: 3, PostID: 1, Author: "bob", Hidden: true}, 1, nil)
+	mockStorage.On("CreateNotification", ctx, &model.Notification{
+		User:      "bob",
+		Kind:      model.NotificationKindModeration,
+		PostID:    1,
+		CommentID: &commentID,
+		Actor:     "mod",
+	}).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	limits := resolver.Settings.Get()
+	limits.Moderators = []string{"mod"}
+	resolver.Settings.Set(limits)
+
+	first := 10
+	result, err := resolver.Query().ModerationQueue(ctx, &first)
+	assert.NoError(t, err)
+	assert.Equal(t, queue, result)
+
+	_, err = resolver.Mutation().ResolveReport(ctx, 3, model.ModerationActionDismiss)
+	assert.NoError(t, err)
+	mockStorage.AssertNumberOfCalls(t, "CreateNotification", 0)
+
+	comment, err := resolver.Mutation().ResolveReport(ctx, 3, model.ModerationActionBan)
+	assert.NoError(t, err)
+	assert.True(t, comment.Hidden)
+	assert.Equal(t, "bob", comment.Author, "moderators see hidden comments")
+	mockStorage.AssertNumberOfCalls(t, "CreateNotification", 1)
+}
+
+func TestHiddenCommentPlaceholders(t *testing.T) {
+	hidden := &model.Comment{ID: 2, PostID: 1, Body: "spam", Author: "bob", Mentions: []string{"carol"}, Hidden: true}
+	post := &model.Post{ID: 1, Comments: []*model.Comment{{ID: 1, PostID: 1, Body: "hi", Author: "alice"}, hidden}}
+
+	resolver := NewResolver(new(MockStorage))
+	limits := resolver.Settings.Get()
+	limits.Moderators = []string{"mod"}
+	resolver.Settings.Set(limits)
+
+	comments, err := resolver.Post().Comments(viewer.WithUser(context.TODO(), "alice"), post, nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, post.Comments[0], comments[0])
+	assert.Equal(t, &model.Comment{ID: 2, PostID: 1, Hidden: true}, comments[1])
+	assert.Equal(t, "spam", hidden.Body, "the stored comment is left alone")
+
+	comments, err = resolver.Post().Comments(viewer.WithUser(context.TODO(), "mod"), post, nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, post.Comments, comments)
+}
+
+func TestBodyHTML(t *testing.T) {
+	ctx := context.TODO()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	post := &model.Post{ID: 1, Body: "*hi* <b>", UpdatedAt: at}
+	comment := &model.Comment{ID: 1, PostID: 1, Body: "see https://example.com", UpdatedAt: at}
+
+	resolver := NewResolver(new(MockStorage))
+	html, err := resolver.Post().BodyHTML(ctx, post)
+	assert.NoError(t, err)
+	assert.Equal(t, "<p><em>hi</em> &lt;b&gt;</p>", html)
+	html, err = resolver.Comment().BodyHTML(ctx, comment)
+	assert.NoError(t, err)
+	assert.Equal(t, `<p>see <a href="https://example.com" rel="nofollow">https://example.com</a></p>`, html)
+	assert.Equal(t, 2, resolver.Markdown.Len(), "posts and comments have revisions of their own")
+
+	// an update is a new revision
+	post.Body, post.UpdatedAt = "**hi**", at.Add(time.Second)
+	html, err = resolver.Post().BodyHTML(ctx, post)
+	assert.NoError(t, err)
+	assert.Equal(t, "<p><strong>hi</strong></p>", html)
+
+	// placeholders of hidden comments render empty
+	html, err = resolver.Comment().BodyHTML(ctx, &model.Comment{ID: 2, PostID: 1, Hidden: true, UpdatedAt: at})
+	assert.NoError(t, err)
+	assert.Empty(t, html)
+}
+
+func TestBannedUserCannotWrite(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "bob")
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("IsBanned", ctx, "bob").Return(true, nil)
+
+	resolver := NewResolver(mockStorage)
+	_, err := resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "title", Body: "body"})
+	assert.ErrorIs(t, err, ErrBanned)
+	_, err = resolver.Mutation().CreateComment(ctx, post_comments.NewComment{PostID: 1, Body: "body"})
+	assert.ErrorIs(t, err, ErrBanned)
+	_, err = resolver.Mutation().VoteComment(ctx, 3, post_comments.VoteValueUp)
+	assert.ErrorIs(t, err, ErrBanned)
+	_, err = resolver.Mutation().ReportComment(ctx, 3, "spam")
+	assert.ErrorIs(t, err, ErrBanned)
+	mockStorage.AssertNotCalled(t, "CreatePost", mock.Anything, mock.Anything)
+	mockStorage.AssertNotCalled(t, "CreateComment", mock.Anything, mock.Anything)
+}
+
+func withFilters(resolver *Resolver) {
+	limits := resolver.Settings.Get()
+	limits.Filters = moderation.Config{
+		DefaultProfile: "standard",
+		Profiles: map[string]moderation.Profile{
+			"standard": {
+				BannedWords: &moderation.BannedWordsConfig{Words: []string{"darn"}, Action: moderation.Mask},
+				Links:       &moderation.LinksConfig{Max: 0, Action: moderation.Flag},
+			},
+			"strict": {
+				BannedWords: &moderation.BannedWordsConfig{Words: []string{"darn"}, Action: moderation.Reject},
+			},
+		},
+	}
+	resolver.Settings.Set(limits)
+}
+
+func TestCreatePostFilterProfile(t *testing.T) {
+	ctx := context.TODO()
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("CreatePost", ctx, mock.AnythingOfType("*model.Post")).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	withFilters(resolver)
+	strict, unknown := "Strict", "lenient"
+
+	_, err := resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "title", Body: "body", FilterProfile: &unknown})
+	assert.Error(t, err)
+	_, err = resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "title", Body: "darn", FilterProfile: &strict})
+	var rejected *moderation.RejectedError
+	assert.ErrorAs(t, err, &rejected)
+
+	post, err := resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "title", Body: "darn it", FilterProfile: nil})
+	assert.NoError(t, err)
+	assert.Equal(t, "**** it", post.Body)
+	profile, err := resolver.Post().FilterProfile(ctx, post)
+	assert.NoError(t, err)
+	assert.Nil(t, profile)
+
+	post, err = resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "title", Body: "fine", FilterProfile: &strict})
+	assert.NoError(t, err)
+	assert.Equal(t, "strict", post.FilterProfile)
+	mockStorage.AssertNumberOfCalls(t, "CreatePost", 2)
+}
+
+func TestCreatePostFiltersTitle(t *testing.T) {
+	ctx := context.TODO()
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("CreatePost", ctx, mock.AnythingOfType("*model.Post")).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	withFilters(resolver)
+	strict := "strict"
+
+	post, err := resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "darn title", Body: "body"})
+	assert.NoError(t, err)
+	assert.Equal(t, "**** title", post.Title)
+	assert.Equal(t, "body", post.Body)
+
+	_, err = resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "darn", Body: "fine", FilterProfile: &strict})
+	var rejected *moderation.RejectedError
+	assert.ErrorAs(t, err, &rejected)
+	mockStorage.AssertNumberOfCalls(t, "CreatePost", 1)
+}
+
+func TestCreateCommentFlagged(t *testing.T) {
+	ctx := context.TODO()
+	post := &model.Post{ID: 1}
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetPostHeader", ctx, 1).Return(post, nil)
+	mockStorage.On("CreateComment", ctx, mock.AnythingOfType("*model.Comment")).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(1).(*model.Comment).ID = 7
+	})
+	mockStorage.On("ReportComment", ctx, &model.Report{CommentID: 7, Reporter: "filter:links", Reason: "too many links (1, at most 0)"}).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	withFilters(resolver)
+
+	comment, err := resolver.Mutation().CreateComment(ctx, post_comments.NewComment{PostID: 1, Body: "darn, see https://example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "****, see https://example.com", comment.Body)
+	mockStorage.AssertNumberOfCalls(t, "ReportComment", 1)
+
+	post.FilterProfile = "strict"
+	_, err = resolver.Mutation().CreateComment(ctx, post_comments.NewComment{PostID: 1, Body: "darn"})
+	assert.ErrorContains(t, err, "banned_words")
+	mockStorage.AssertNumberOfCalls(t, "CreateComment", 1)
+	// the post is loaded once, for the filters and the notifications
+	mockStorage.AssertNumberOfCalls(t, "GetPostHeader", 2)
+}
+
+func TestDuplicateAfterFailedWrite(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "bob")
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("IsBanned", ctx, "bob").Return(false, nil)
+	mockStorage.On("GetPostHeader", ctx, 1).Return(&model.Post{ID: 1}, nil)
+	mockStorage.On("CreateComment", ctx, mock.AnythingOfType("*model.Comment")).Return(errors.New("database down")).Once()
+	mockStorage.On("CreateComment", ctx, mock.AnythingOfType("*model.Comment")).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	limits := resolver.Settings.Get()
+	limits.Filters = moderation.Config{
+		DefaultProfile: "standard",
+		Profiles: map[string]moderation.Profile{
+			"standard": {Duplicates: &moderation.DuplicatesConfig{Window: time.Minute, Action: moderation.Reject}},
+		},
+	}
+	resolver.Settings.Set(limits)
+
+	input := post_comments.NewComment{PostID: 1, Body: "first!"}
+	_, err := resolver.Mutation().CreateComment(ctx, input)
+	assert.ErrorContains(t, err, "database down")
+	// the retry isn't a duplicate of the comment that wasn't stored
+	_, err = resolver.Mutation().CreateComment(ctx, input)
+	assert.NoError(t, err)
+	_, err = resolver.Mutation().CreateComment(ctx, input)
+	var rejected *moderation.RejectedError
+	assert.ErrorAs(t, err, &rejected)
+	mockStorage.AssertNumberOfCalls(t, "CreateComment", 2)
+}
+
+func TestSpamClassifier(t *testing.T) {
+	ctx := viewer.WithUser(context.TODO(), "mod")
+	// the stored model once the hidden comment was learned
+	trained := &model.SpamModel{
+		Spam: 2,
+		Ham:  1,
+		Tokens: map[string]model.TokenCounts{
+			"cheap":  {Spam: 1},
+			"pills":  {Spam: 2},
+			"thanks": {Ham: 1},
+		},
+	}
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetSpamModel", ctx).Return(trained, nil).Once()
+	mockStorage.On("TrainSpam", ctx, []string{"cheap", "pills"}, true).Return(nil)
+	mockStorage.On("ResolveReports", ctx, 3, model.ModerationActionHide).Return(&model.Comment{ID: 3, PostID: 1, Body: "cheap pills", Hidden: true}, 1, nil).Once()
+	mockStorage.On("ResolveReports", ctx, 3, model.ModerationActionHide).Return(&model.Comment{ID: 3, PostID: 1, Body: "cheap pills", Hidden: true}, 0, nil)
+	mockStorage.On("GetPostHeader", ctx, 1).Return(&model.Post{ID: 1}, nil)
+	mockStorage.On("IsBanned", ctx, "mod").Return(false, nil)
+	mockStorage.On("CreateComment", ctx, mock.AnythingOfType("*model.Comment")).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(1).(*model.Comment).ID = 7
+	})
+	mockStorage.On("ReportComment", ctx, mock.MatchedBy(func(report *model.Report) bool {
+		return report.CommentID == 7 && report.Reporter == "filter:spam"
+	})).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	limits := resolver.Settings.Get()
+	limits.Moderators = []string{"mod"}
+	limits.Spam = spam.Config{Threshold: 0.7, MinTraining: 1}
+	resolver.Settings.Set(limits)
+
+	_, err := resolver.Mutation().ResolveReport(ctx, 3, model.ModerationActionHide)
+	assert.NoError(t, err)
+	// resolving again closes no report and teaches nothing
+	_, err = resolver.Mutation().ResolveReport(ctx, 3, model.ModerationActionHide)
+	assert.NoError(t, err)
+	mockStorage.AssertNumberOfCalls(t, "TrainSpam", 1)
+
+	_, err = resolver.Mutation().CreateComment(ctx, post_comments.NewComment{PostID: 1, Body: "thanks"})
+	assert.NoError(t, err)
+	_, err = resolver.Mutation().CreateComment(ctx, post_comments.NewComment{PostID: 1, Body: "pills"})
+	assert.NoError(t, err)
+	mockStorage.AssertNumberOfCalls(t, "ReportComment", 1)
+}
+
+func TestCreatePostTags(t *testing.T) {
+	ctx := context.TODO()
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("CreatePost", ctx, mock.AnythingOfType("*model.Post")).Return(nil)
+
+	resolver := NewResolver(mockStorage)
+	post, err := resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "title", Body: "body", Tags: []string{"News", " go ", "news", "café-2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"café-2", "go", "news"}, post.Tags)
+
+	_, err = resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "title", Body: "body", Tags: []string{"c++"}})
+	assert.ErrorContains(t, err, "letters, digits")
+	_, err = resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "title", Body: "body", Tags: []string{strings.Repeat("x", maxTagLen+1)}})
+	assert.Error(t, err)
+	_, err = resolver.Mutation().CreatePost(ctx, post_comments.NewPost{Title: "title", Body: "body", Tags: []string{"a", "b", "c", "d", "e", "f"}})
+	assert.ErrorContains(t, err, "too many tags")
+	mockStorage.AssertNumberOfCalls(t, "CreatePost", 1)
+}
+
+func TestUpdatePost(t *testing.T) {
+	alice := viewer.WithUser(context.TODO(), "alice")
+	bob := viewer.WithUser(context.TODO(), "bob")
+	mod := viewer.WithUser(context.TODO(), "mod")
+	post := &model.Post{ID: 1, Title: "title", Body: "body", Author: "alice"}
+	title, body := "darn title", "darn it"
+	maskedTitle, maskedBody := "**** title", "**** it"
+	tags := []string{"go"}
+	var none []string
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("IsBanned", mock.Anything, mock.Anything).Return(false, nil)
+	mockStorage.On("GetPostHeader", mock.Anything, 1).Return(post, nil)
+	mockStorage.On("UpdatePost", alice, 1, storage.PostUpdate{Title: &maskedTitle, Body: &maskedBody, Tags: &tags}).Return(&model.Post{ID: 1, Title: maskedTitle, Tags: tags}, nil)
+	mockStorage.On("UpdatePost", mod, 1, storage.PostUpdate{Tags: &none}).Return(post, nil)
+
+	resolver := NewResolver(mockStorage)
+	withFilters(resolver)
+	limits := resolver.Settings.Get()
+	limits.Moderators = []string{"mod"}
+	resolver.Settings.Set(limits)
+
+	_, err := resolver.Mutation().UpdatePost(context.TODO(), 1, post_comments.UpdatePost{Title: &title})
+	assert.ErrorIs(t, err, ErrNotSignedIn)
+	_, err = resolver.Mutation().UpdatePost(bob, 1, post_comments.UpdatePost{Title: &title})
+	assert.ErrorIs(t, err, ErrNotAuthor)
+
+	updated, err := resolver.Mutation().UpdatePost(alice, 1, post_comments.UpdatePost{Title: &title, Body: &body, Tags: []string{"Go", "go"}})
+	assert.NoError(t, err)
+	assert.Equal(t, tags, updated.Tags)
+	assert.Equal(t, maskedTitle, updated.Title)
+
+	// moderators can update any post, an empty list removing the tags
+	_, err = resolver.Mutation().UpdatePost(mod, 1, post_comments.UpdatePost{Tags: []string{}})
+	assert.NoError(t, err)
+	mockStorage.AssertNumberOfCalls(t, "UpdatePost", 2)
+}
+
+func TestUpdatePostUnchangedFields(t *testing.T) {
+	alice := viewer.WithUser(context.TODO(), "alice")
+	post := &model.Post{ID: 1, Title: "title", Body: "body", Author: "alice", FilterProfile: "strict"}
+	unchanged, respaced, darn := "body", "Body  ", "darn"
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("IsBanned", alice, "alice").Return(false, nil)
+	mockStorage.On("GetPostHeader", alice, 1).Return(post, nil)
+	mockStorage.On("UpdatePost", alice, 1, storage.PostUpdate{}).Return(post, nil)
+	mockStorage.On("UpdatePost", alice, 1, storage.PostUpdate{Body: &respaced}).Return(post, nil)
+
+	resolver := NewResolver(mockStorage)
+	limits := resolver.Settings.Get()
+	limits.Filters = moderation.Config{
+		Profiles: map[string]moderation.Profile{
+			"strict": {
+				BannedWords: &moderation.BannedWordsConfig{Words: []string{"darn", "title"}, Action: moderation.Reject},
+				Duplicates:  &moderation.DuplicatesConfig{Window: time.Minute, Action: moderation.Reject},
+			},
+		},
+	}
+	resolver.Settings.Set(limits)
+	resolver.sent(alice, "alice", "body")
+
+	// sending the fields back as they are is no duplicate, nor goes through
+	// the banned words again
+	title := post.Title
+	_, err := resolver.Mutation().UpdatePost(alice, 1, post_comments.UpdatePost{Title: &title, Body: &unchanged})
+	assert.NoError(t, err)
+	// edits aren't compared with earlier bodies
+	_, err = resolver.Mutation().UpdatePost(alice, 1, post_comments.UpdatePost{Body: &respaced})
+	assert.NoError(t, err)
+
+	var rejected *moderation.RejectedError
+	_, err = resolver.Mutation().UpdatePost(alice, 1, post_comments.UpdatePost{Title: &darn})
+	assert.ErrorAs(t, err, &rejected)
+	_, err = resolver.Mutation().UpdatePost(alice, 1, post_comments.UpdatePost{Body: &darn})
+	assert.ErrorAs(t, err, &rejected)
+	mockStorage.AssertNumberOfCalls(t, "UpdatePost", 2)
+}
+
+func TestPostsByTag(t *testing.T) {
+	ctx := context.TODO()
+	posts := []*model.Post{{ID: 1, Tags: []string{"go"}}}
+	counts := []*model.TagCount{{Tag: "go", Count: 1}}
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetPostsByTag", ctx, "go").Return(posts, nil)
+	mockStorage.On("GetTags", ctx).Return(counts, nil)
+
+	resolver := NewResolver(mockStorage)
+	tag := "Go"
+	result, err := resolver.Query().Posts(ctx, &tag)
+	assert.NoError(t, err)
+	assert.Equal(t, posts, result)
+	tag = "no spaces"
+	_, err = resolver.Query().Posts(ctx, &tag)
+	assert.Error(t, err)
+
+	tags, err := resolver.Query().Tags(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, counts, tags)
+	mockStorage.AssertNotCalled(t, "GetPosts", mock.Anything)
+}
@@ -0,0 +1,50 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxTagLen is the longest a tag can be, in characters.
+const maxTagLen = 32
+
+var ErrNotAuthor = errors.New("you can only update your own posts")
+
+// normalizeTag lower-cases tag and checks that it only has letters, digits
+// and '-'.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLen {
+		return "", fmt.Errorf("tag %q must have 1 to %d characters", tag, maxTagLen)
+	}
+	for _, r := range tag {
+		if r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return "", fmt.Errorf("tag %q can only have letters, digits and '-'", tag)
+		}
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes tags, dropping duplicates, and sorts them. There
+// can be at most maxTags of them when it is positive.
+func normalizeTags(tags []string, maxTags int) ([]string, error) {
+	var normalized []string
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if maxTags > 0 && len(normalized) > maxTags {
+		return nil, fmt.Errorf("too many tags (%d, at most %d)", len(normalized), maxTags)
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
@@ -0,0 +1,21 @@
+package resolver
+
+import (
+	"post-comments"
+)
+
+var voteValues = map[post_comments.VoteValue]int{
+	post_comments.VoteValueUp:   1,
+	post_comments.VoteValueDown: -1,
+	post_comments.VoteValueNone: 0,
+}
+
+func voteValue(value int) post_comments.VoteValue {
+	switch value {
+	case 1:
+		return post_comments.VoteValueUp
+	case -1:
+		return post_comments.VoteValueDown
+	}
+	return post_comments.VoteValueNone
+}
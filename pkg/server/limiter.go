@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ConnLimiter caps the number of concurrent long-lived connections
+// (WebSocket and SSE subscriptions) a single client IP may hold.
+type ConnLimiter struct {
+	max   int
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func NewConnLimiter(max int) *ConnLimiter {
+	return &ConnLimiter{
+		max:   max,
+		conns: make(map[string]int),
+	}
+}
+
+func (l *ConnLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.max <= 0 || !isStreaming(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		if !l.acquire(ip) {
+			http.Error(w, "too many connections", http.StatusTooManyRequests)
+			return
+		}
+		defer l.release(ip)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *ConnLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *ConnLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns[ip]--
+	if l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+func isStreaming(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
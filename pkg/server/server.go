@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+type closer struct {
+	name  string
+	close func() error
+}
+
+// Server is an http.Server that shuts down gracefully: it keeps serving for
+// the shutdown delay, subscriptions are completed, then in-flight requests
+// are drained for up to the drain timeout, WebSocket connections are closed
+// and finally the registered resources are released in the order they were
+// added.
+type Server struct {
+	srv          *http.Server
+	cancel       context.CancelFunc
+	delay        time.Duration
+	drainTimeout time.Duration
+	onShutdown   []func()
+	beforeDrain  []func()
+	closers      []closer
+}
+
+// New returns a server that, once asked to stop, keeps serving for delay
+// after the OnShutdown functions ran, so that load balancers notice the
+// failing readiness probe, and then drains requests for up to drainTimeout.
+func New(addr string, handler http.Handler, delay, drainTimeout time.Duration) *Server {
+	baseCtx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		srv: &http.Server{
+			Addr:    addr,
+			Handler: handler,
+			// hijacked WebSocket connections outlive Shutdown, they are
+			// closed by cancelling their base context
+			BaseContext: func(net.Listener) context.Context { return baseCtx },
+		},
+		cancel:       cancel,
+		delay:        delay,
+		drainTimeout: drainTimeout,
+	}
+}
+
+// OnShutdown registers fn to be called as soon as shutdown starts.
+func (s *Server) OnShutdown(fn func()) {
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
+// BeforeDrain registers fn to be called once the shutdown delay has passed,
+// right before in-flight requests are drained.
+func (s *Server) BeforeDrain(fn func()) {
+	s.beforeDrain = append(s.beforeDrain, fn)
+}
+
+// AddCloser registers a resource to be closed after the server has stopped.
+func (s *Server) AddCloser(name string, fn func() error) {
+	s.closers = append(s.closers, closer{name: name, close: fn})
+}
+
+// Run serves until ctx is done and then shuts the server down.
+func (s *Server) Run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		s.close()
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", slog.Duration("delay", s.delay), slog.Duration("drain_timeout", s.drainTimeout))
+	for _, fn := range s.onShutdown {
+		fn()
+	}
+	time.Sleep(s.delay)
+	for _, fn := range s.beforeDrain {
+		fn()
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+	defer cancel()
+	err := s.srv.Shutdown(drainCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("drain timeout exceeded, closing remaining connections")
+		err = s.srv.Close()
+	}
+
+	s.close()
+	return err
+}
+
+func (s *Server) close() {
+	s.cancel()
+	for _, c := range s.closers {
+		if err := c.close(); err != nil {
+			slog.Error("error closing resource", slog.String("resource", c.name), slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keepAliveSSE is transport.SSE that also writes a comment line every
+// Interval, so proxies don't close idle subscription streams.
+type keepAliveSSE struct {
+	transport.SSE
+	Interval time.Duration
+}
+
+func (t keepAliveSSE) Do(w http.ResponseWriter, r *http.Request, exec graphql.GraphExecutor) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || t.Interval <= 0 {
+		t.SSE.Do(w, r, exec)
+		return
+	}
+
+	sw := &sseWriter{ResponseWriter: w, flusher: flusher}
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(t.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				sw.keepAlive()
+			}
+		}
+	}()
+
+	t.SSE.Do(sw, r, exec)
+	sw.finish()
+}
+
+type sseWriter struct {
+	http.ResponseWriter
+	flusher  http.Flusher
+	mu       sync.Mutex
+	started  bool
+	finished bool
+}
+
+func (w *sseWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *sseWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flusher.Flush()
+}
+
+func (w *sseWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.finished = true
+}
+
+func (w *sseWriter) keepAlive() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// the stream headers are only known after the transport's first write
+	if !w.started || w.finished {
+		return
+	}
+	_, _ = w.ResponseWriter.Write([]byte(":\n\n"))
+	w.flusher.Flush()
+}
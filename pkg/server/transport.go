@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/gorilla/websocket"
+	"github.com/vektah/gqlparser/v2/ast"
+	"net/http"
+	"time"
+)
+
+const (
+	graphqlTransportWS = "graphql-transport-ws"
+	graphqlWS          = "graphql-ws"
+)
+
+type TransportConfig struct {
+	// AllowedOrigins lists the origins allowed to open a WebSocket, "*" allows any.
+	AllowedOrigins []string      `mapstructure:"allowed_origins"`
+	KeepAlive      time.Duration `mapstructure:"keepalive"`
+	MaxConnsPerIP  int           `mapstructure:"max_conns_per_ip"`
+}
+
+func (cfg TransportConfig) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// not a browser
+		return true
+	}
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// NewGraphQLServer mirrors handler.NewDefaultServer, but registers the
+// subscription transports with our own settings. Both WebSocket subprotocols
+// are negotiated, graphql-transport-ws being preferred, and subscriptions are
+// also served over Server-Sent Events.
+func NewGraphQLServer(es graphql.ExecutableSchema, cfg TransportConfig) *handler.Server {
+	srv := handler.New(es)
+
+	srv.AddTransport(transport.Websocket{
+		Upgrader: websocket.Upgrader{
+			CheckOrigin:  cfg.CheckOrigin,
+			Subprotocols: []string{graphqlTransportWS, graphqlWS},
+		},
+		InitFunc:              markWebsocket,
+		KeepAlivePingInterval: cfg.KeepAlive,
+		PingPongInterval:      cfg.KeepAlive,
+	})
+	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.GET{})
+	// SSE has to go before POST, which accepts any JSON POST request
+	srv.AddTransport(keepAliveSSE{Interval: cfg.KeepAlive})
+	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.MultipartForm{})
+
+	srv.SetQueryCache(lru.New(1000))
+
+	srv.Use(extension.Introspection{})
+	srv.Use(extension.AutomaticPersistedQuery{
+		Cache: lru.New(100),
+	})
+
+	return srv
+}
+
+type websocketKey struct{}
+
+// markWebsocket marks the context of a WebSocket connection, which its
+// subscriptions inherit.
+func markWebsocket(ctx context.Context, _ transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+	return context.WithValue(ctx, websocketKey{}, true), nil, nil
+}
+
+// CanAddSubscriptionError reports whether ctx is the context of a
+// subscription served over a WebSocket, the only transport keeping a place
+// for transport.AddSubscriptionError in the context.
+func CanAddSubscriptionError(ctx context.Context) bool {
+	if ws, _ := ctx.Value(websocketKey{}).(bool); !ws || !graphql.HasOperationContext(ctx) {
+		return false
+	}
+	op := graphql.GetOperationContext(ctx).Operation
+	return op != nil && op.Operation == ast.Subscription
+}
@@ -0,0 +1,80 @@
+package settings
+
+import (
+	"post-comments/pkg/moderation"
+	"post-comments/pkg/ranking"
+	"post-comments/pkg/spam"
+	"slices"
+	"sync/atomic"
+)
+
+// Limits are the runtime limits that can change while the server runs.
+// A zero value disables the corresponding limit.
+type Limits struct {
+	CommentMaxLen   int       `mapstructure:"comment_max_len"`
+	PostTitleMaxLen int       `mapstructure:"post_title_max_len"`
+	PostBodyMaxLen  int       `mapstructure:"post_body_max_len"`
+	MaxThreadDepth  int       `mapstructure:"max_thread_depth"`
+	MaxComplexity   int       `mapstructure:"max_complexity"`
+	RateLimit       RateLimit `mapstructure:"rate_limit"`
+	// MaxMentions is how many users a comment can notify by mentioning
+	// them, further mentions being left as plain text.
+	MaxMentions int `mapstructure:"max_mentions"`
+	// MaxTags is how many tags a post can have.
+	MaxTags int `mapstructure:"max_tags"`
+	// Reactions are the emojis posts and comments can be reacted with, in
+	// the order their counts are listed.
+	Reactions []string `mapstructure:"reactions"`
+	// Ranking sets the formulas of the comment sort orders.
+	Ranking ranking.Config `mapstructure:"ranking"`
+	// Filters are the moderation filter profiles new posts and comments
+	// go through.
+	Filters moderation.Config `mapstructure:"filters"`
+	// Spam configures the classifier flagging new comments.
+	Spam spam.Config `mapstructure:"spam"`
+	// Moderators are the users who can see hidden comments and resolve
+	// reports.
+	Moderators []string `mapstructure:"moderators"`
+}
+
+// IsModerator reports whether user is one of the moderators.
+func (l Limits) IsModerator(user string) bool {
+	return user != "" && slices.Contains(l.Moderators, user)
+}
+
+// RateLimit caps the mutations a single client can make.
+type RateLimit struct {
+	PerMinute int `mapstructure:"per_minute"`
+	Burst     int `mapstructure:"burst"`
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		CommentMaxLen: 2000,
+		MaxMentions:   10,
+		MaxTags:       5,
+		Reactions:     []string{"👍", "👎", "❤️", "😂", "😮", "😢"},
+		Ranking:       ranking.Config{HotDecay: ranking.DefaultHotDecay},
+		Spam:          spam.Config{MinTraining: 20},
+	}
+}
+
+// Settings holds the current Limits. Readers always see a consistent
+// snapshot, and Set replaces it without disturbing requests in flight.
+type Settings struct {
+	limits atomic.Pointer[Limits]
+}
+
+func New(limits Limits) *Settings {
+	s := &Settings{}
+	s.Set(limits)
+	return s
+}
+
+func (s *Settings) Get() Limits {
+	return *s.limits.Load()
+}
+
+func (s *Settings) Set(limits Limits) {
+	s.limits.Store(&limits)
+}
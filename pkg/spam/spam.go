@@ -0,0 +1,223 @@
+// Package spam scores comments with a naive Bayes classifier that learns
+// from the moderators' decisions.
+package spam
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"post-comments/pkg/model"
+	"post-comments/pkg/moderation"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTokens is how many distinct tokens of a body are looked at.
+	MaxTokens = 200
+	// tokens shorter or longer than these are ignored
+	minTokenLen = 2
+	maxTokenLen = 32
+
+	// reloadInterval is how often the model is loaded again, to pick up
+	// what the other server instances learned.
+	reloadInterval = time.Minute
+	// retryDelay is how long loading waits after a failure, doubling with
+	// every failure in a row up to reloadInterval.
+	retryDelay = time.Second
+)
+
+type Config struct {
+	// Threshold is the spam probability from which new comments are
+	// flagged for review; 0 disables the classifier, which still learns.
+	Threshold float64 `mapstructure:"threshold"`
+	// MinTraining is how many comments of each class the classifier must
+	// have learned from before flagging any.
+	MinTraining int `mapstructure:"min_training"`
+}
+
+// Tokenize returns the distinct lower-cased words of body, in order of
+// appearance and at most MaxTokens of them.
+func Tokenize(body string) []string {
+	var tokens []string
+	seen := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(body), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if n := utf8.RuneCountInString(word); n < minTokenLen || n > maxTokenLen || seen[word] {
+			continue
+		}
+		seen[word] = true
+		tokens = append(tokens, word)
+		if len(tokens) == MaxTokens {
+			break
+		}
+	}
+	return tokens
+}
+
+// Score returns the probability that a comment made of tokens is spam,
+// according to m. Tokens m never saw are left out, and every count is
+// smoothed by one so that no single token decides alone.
+func Score(m *model.SpamModel, tokens []string) float64 {
+	spam := math.Log(float64(m.Spam+1) / float64(m.Spam+m.Ham+2))
+	ham := math.Log(float64(m.Ham+1) / float64(m.Spam+m.Ham+2))
+	for _, token := range tokens {
+		counts, ok := m.Tokens[token]
+		if !ok {
+			continue
+		}
+		spam += math.Log(float64(counts.Spam+1) / float64(m.Spam+2))
+		ham += math.Log(float64(counts.Ham+1) / float64(m.Ham+2))
+	}
+	return 1 / (1 + math.Exp(ham-spam))
+}
+
+// Store keeps the model of a Classifier, storage.Storage being one.
+type Store interface {
+	TrainSpam(ctx context.Context, tokens []string, spam bool) error
+	GetSpamModel(ctx context.Context) (*model.SpamModel, error)
+}
+
+// Classifier keeps a copy of the stored model in memory, loaded on first
+// use and again every reloadInterval. Training updates both, so the copy
+// only misses what other server instances learned since it was loaded.
+type Classifier struct {
+	store Store
+	now   func() time.Time
+
+	mu    sync.Mutex
+	model *model.SpamModel
+	// reload is when the model is due to be loaded again.
+	reload time.Time
+	// failures counts the loads that failed in a row, err being the last
+	// error.
+	failures int
+	err      error
+}
+
+func New(store Store) *Classifier {
+	return &Classifier{store: store, now: time.Now}
+}
+
+// loaded returns the model, loading it when it is due; c.mu must be held.
+// When loading fails, the model loaded before is kept, and the store isn't
+// asked again before the retry delay.
+func (c *Classifier) loaded(ctx context.Context) (*model.SpamModel, error) {
+	now := c.now()
+	if now.Before(c.reload) {
+		if c.model == nil {
+			return nil, c.err
+		}
+		return c.model, nil
+	}
+
+	m, err := c.store.GetSpamModel(ctx)
+	if err != nil {
+		c.failures++
+		c.err = err
+		delay := retryDelay
+		for i := 1; i < c.failures && delay < reloadInterval; i++ {
+			delay *= 2
+		}
+		c.reload = now.Add(min(delay, reloadInterval))
+		if c.model == nil {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "reloading spam model failed, keeping the loaded one", slog.String("error", err.Error()))
+		return c.model, nil
+	}
+	if m.Tokens == nil {
+		m.Tokens = map[string]model.TokenCounts{}
+	}
+	c.model = m
+	c.reload = now.Add(reloadInterval)
+	c.failures, c.err = 0, nil
+	return m, nil
+}
+
+// Score returns the spam probability of body, and whether the model learned
+// from enough comments for cfg to trust it.
+func (c *Classifier) Score(ctx context.Context, cfg Config, body string) (float64, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, err := c.loaded(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	trained := m.Spam >= cfg.MinTraining && m.Ham >= cfg.MinTraining
+	return Score(m, Tokenize(body)), trained, nil
+}
+
+// Train learns that body is spam or not. Bodies without tokens teach
+// nothing and are skipped.
+func (c *Classifier) Train(ctx context.Context, body string, spam bool) error {
+	tokens := Tokenize(body)
+	if len(tokens) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.store.TrainSpam(ctx, tokens, spam); err != nil {
+		return err
+	}
+	// a model not loaded yet gets the training from the store
+	m := c.model
+	if m == nil {
+		return nil
+	}
+	if spam {
+		m.Spam++
+	} else {
+		m.Ham++
+	}
+	for _, token := range tokens {
+		counts := m.Tokens[token]
+		if spam {
+			counts.Spam++
+		} else {
+			counts.Ham++
+		}
+		m.Tokens[token] = counts
+	}
+	return nil
+}
+
+// Filter returns a moderation filter flagging the bodies scoring at least
+// cfg.Threshold once the classifier is trained. It lets bodies through when
+// the model can't be loaded.
+func (c *Classifier) Filter(cfg Config) moderation.Filter {
+	return &filter{classifier: c, cfg: cfg}
+}
+
+type filter struct {
+	classifier *Classifier
+	cfg        Config
+}
+
+func (f *filter) Name() string { return "spam" }
+
+func (f *filter) Check(ctx context.Context, content moderation.Content) (moderation.Verdict, error) {
+	if f.cfg.Threshold <= 0 {
+		return moderation.Verdict{}, nil
+	}
+	score, trained, err := f.classifier.Score(ctx, f.cfg, content.Body)
+	if err != nil {
+		// the classifier only flags for review, so it doesn't keep comments
+		// out while the model can't be loaded
+		slog.WarnContext(ctx, "scoring comment for spam failed", slog.String("error", err.Error()))
+		return moderation.Verdict{}, nil
+	}
+	if !trained || score < f.cfg.Threshold {
+		return moderation.Verdict{}, nil
+	}
+	return moderation.Verdict{
+		Action: moderation.Flag,
+		Reason: fmt.Sprintf("spam probability %.2f", score),
+	}, nil
+}
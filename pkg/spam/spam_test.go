@@ -0,0 +1,165 @@
+package spam
+
+import (
+	"context"
+	"errors"
+	"post-comments/pkg/model"
+	"post-comments/pkg/moderation"
+	"post-comments/pkg/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"buy", "cheap", "pills", "at", "https", "example", "com", "école"},
+		Tokenize("Buy CHEAP pills at https://example.com, buy! a École"))
+	assert.Empty(t, Tokenize("a b c !!"))
+	assert.Len(t, Tokenize(strings.Repeat("x", maxTokenLen+1)), 0)
+
+	var many []string
+	for i := 0; i < MaxTokens+10; i++ {
+		many = append(many, strings.Repeat("y", 2)+string(rune('a'+i%26))+string(rune('a'+i/26)))
+	}
+	assert.Len(t, Tokenize(strings.Join(many, " ")), MaxTokens)
+}
+
+func TestScore(t *testing.T) {
+	m := &model.SpamModel{Tokens: map[string]model.TokenCounts{}}
+	assert.InDelta(t, 0.5, Score(m, []string{"anything"}), 1e-9)
+
+	m = &model.SpamModel{
+		Spam: 10,
+		Ham:  10,
+		Tokens: map[string]model.TokenCounts{
+			"pills":  {Spam: 9},
+			"thanks": {Ham: 8, Spam: 1},
+			"the":    {Spam: 9, Ham: 9},
+		},
+	}
+	assert.Greater(t, Score(m, []string{"cheap", "pills"}), 0.9)
+	assert.Less(t, Score(m, []string{"thanks"}), 0.2)
+	assert.InDelta(t, 0.5, Score(m, []string{"the", "unseen"}), 1e-9)
+}
+
+func TestClassifierLearns(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewInMemoryStorage()
+	c := New(store)
+	cfg := Config{Threshold: 0.9, MinTraining: 2}
+	f := c.Filter(cfg)
+	check := func(body string) moderation.Verdict {
+		t.Helper()
+		verdict, err := f.Check(ctx, moderation.Content{Body: body})
+		require.NoError(t, err)
+		return verdict
+	}
+
+	require.NoError(t, c.Train(ctx, "buy cheap pills now", true))
+	require.NoError(t, c.Train(ctx, "thanks for the great post", false))
+	assert.Equal(t, moderation.Verdict{}, check("cheap pills"), "not trained enough yet")
+
+	require.NoError(t, c.Train(ctx, "cheap pills here", true))
+	require.NoError(t, c.Train(ctx, "great point, thanks", false))
+	assert.Equal(t, moderation.Flag, check("cheap pills, buy now").Action)
+	assert.Equal(t, moderation.Verdict{}, check("thanks, great post"))
+	require.NoError(t, c.Train(ctx, "!!", true), "bodies without tokens are skipped")
+
+	// the model went through the store
+	m, err := store.GetSpamModel(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.Spam)
+	assert.Equal(t, 2, m.Ham)
+	assert.Equal(t, model.TokenCounts{Spam: 2}, m.Tokens["pills"])
+
+	score, trained, err := New(store).Score(ctx, cfg, "cheap pills, buy now")
+	require.NoError(t, err)
+	assert.True(t, trained)
+	assert.GreaterOrEqual(t, score, cfg.Threshold)
+
+	disabled := c.Filter(Config{MinTraining: 2})
+	verdict, err := disabled.Check(ctx, moderation.Content{Body: "cheap pills"})
+	require.NoError(t, err)
+	assert.Equal(t, moderation.Verdict{}, verdict)
+}
+
+type failingStore struct{}
+
+func (failingStore) TrainSpam(ctx context.Context, tokens []string, spam bool) error {
+	return errors.New("database down")
+}
+
+func (failingStore) GetSpamModel(ctx context.Context) (*model.SpamModel, error) {
+	return nil, errors.New("database down")
+}
+
+func TestFilterFailsOpen(t *testing.T) {
+	f := New(failingStore{}).Filter(Config{Threshold: 0.5})
+
+	verdict, err := f.Check(context.Background(), moderation.Content{Body: "cheap pills"})
+	assert.NoError(t, err)
+	assert.Equal(t, moderation.Verdict{}, verdict)
+}
+
+func TestClassifierReloads(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewInMemoryStorage()
+	c := New(store)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	cfg := Config{MinTraining: 1}
+
+	_, trained, err := c.Score(ctx, cfg, "cheap pills")
+	require.NoError(t, err)
+	assert.False(t, trained)
+
+	// another instance trains through the store
+	other := New(store)
+	require.NoError(t, other.Train(ctx, "cheap pills", true))
+	require.NoError(t, other.Train(ctx, "great post", false))
+	_, trained, err = c.Score(ctx, cfg, "cheap pills")
+	require.NoError(t, err)
+	assert.False(t, trained, "the loaded model is kept until it is due")
+
+	now = now.Add(reloadInterval)
+	_, trained, err = c.Score(ctx, cfg, "cheap pills")
+	require.NoError(t, err)
+	assert.True(t, trained)
+}
+
+type countingStore struct {
+	failingStore
+	loads int
+}
+
+func (s *countingStore) GetSpamModel(ctx context.Context) (*model.SpamModel, error) {
+	s.loads++
+	return s.failingStore.GetSpamModel(ctx)
+}
+
+func TestClassifierBacksOff(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{}
+	c := New(store)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, _, err := c.Score(ctx, Config{}, "cheap pills")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 1, store.loads)
+
+	now = now.Add(retryDelay)
+	_, _, err := c.Score(ctx, Config{}, "cheap pills")
+	assert.Error(t, err)
+	assert.Equal(t, 2, store.loads)
+
+	now = now.Add(retryDelay)
+	_, _, err = c.Score(ctx, Config{}, "cheap pills")
+	assert.Error(t, err)
+	assert.Equal(t, 2, store.loads, "the delay doubles")
+}
@@ -0,0 +1,401 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"post-comments/pkg/model"
+	"post-comments/pkg/storage"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const postsKey = "posts"
+
+type Config struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Size is the maximum number of cached entries.
+	Size    int           `mapstructure:"size"`
+	PostTTL time.Duration `mapstructure:"post_ttl"`
+	ListTTL time.Duration `mapstructure:"list_ttl"`
+}
+
+// Bus carries invalidations between the instances sharing a database.
+type Bus interface {
+	// Publish tells the other instances that postID changed.
+	Publish(ctx context.Context, postID int) error
+	// Listen calls invalidate for every change published by other
+	// instances, and purge when changes may have been missed, until ctx is
+	// done.
+	Listen(ctx context.Context, invalidate func(postID int), purge func()) error
+}
+
+type Stats struct {
+	Entries   int    `json:"entries"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// Storage caches posts, comment pages and the post list of the wrapped
+// storage. Writes
+// invalidate the entries of the post they touch, here and, through the Bus,
+// on the other instances.
+type Storage struct {
+	next storage.Storage
+	cfg  Config
+	bus  Bus
+	now  func() time.Time
+
+	mu    sync.Mutex
+	lru   *lru
+	stats Stats
+	// pages holds the keys of the cached comment pages of each post.
+	pages map[int]map[string]struct{}
+	// generation changes on every invalidation, so that reads started
+	// before one don't store what they got.
+	generation uint64
+}
+
+// New wraps next. bus may be nil when a single instance uses the storage.
+func New(next storage.Storage, cfg Config, bus Bus) *Storage {
+	s := &Storage{
+		next:  next,
+		cfg:   cfg,
+		bus:   bus,
+		now:   time.Now,
+		pages: map[int]map[string]struct{}{},
+	}
+	s.lru = newLRU(max(cfg.Size, 1), s.removed)
+	return s
+}
+
+// Listen applies the invalidations of other instances until ctx is done.
+func (s *Storage) Listen(ctx context.Context) error {
+	if s.bus == nil {
+		return nil
+	}
+	return s.bus.Listen(ctx, s.invalidate, s.Purge)
+}
+
+func (s *Storage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Entries = s.lru.len()
+	return stats
+}
+
+// Purge drops every entry.
+func (s *Storage) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	s.lru.purge()
+	clear(s.pages)
+}
+
+func postKey(id int) string {
+	return "post:" + strconv.Itoa(id)
+}
+
+// page is a cached comment page.
+type page struct {
+	postID   int
+	comments []*model.Comment
+}
+
+func pageKey(q storage.CommentQuery) string {
+	after := "-"
+	if q.After != nil {
+		after = strconv.Itoa(*q.After)
+	}
+	key := fmt.Sprintf("comments:%d:%s:%s:%d", q.PostID, q.Order, after, q.Limit)
+	if q.Order == model.CommentOrderHot {
+		key += ":" + q.Ranking.Decay().String()
+	}
+	return key
+}
+
+// removed forgets the pages the LRU dropped; s.mu must be held.
+func (s *Storage) removed(key string, value any) {
+	p, ok := value.(page)
+	if !ok {
+		return
+	}
+	delete(s.pages[p.postID], key)
+	if len(s.pages[p.postID]) == 0 {
+		delete(s.pages, p.postID)
+	}
+}
+
+func (s *Storage) get(key string) (any, uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.lru.get(key, s.now())
+	if ok {
+		s.stats.Hits++
+	} else {
+		s.stats.Misses++
+	}
+	return value, s.generation, ok
+}
+
+func (s *Storage) add(key string, value any, ttl time.Duration, generation uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if generation != s.generation {
+		return
+	}
+	if s.lru.add(key, value, s.now().Add(ttl)) {
+		s.stats.Evictions++
+	}
+	if p, ok := value.(page); ok {
+		if s.pages[p.postID] == nil {
+			s.pages[p.postID] = map[string]struct{}{}
+		}
+		s.pages[p.postID][key] = struct{}{}
+	}
+}
+
+// invalidate drops the post, its comment pages and the lists that include
+// it.
+func (s *Storage) invalidate(postID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	s.lru.delete(postKey(postID))
+	s.lru.delete(postsKey)
+	for key := range s.pages[postID] {
+		s.lru.delete(key)
+	}
+}
+
+func (s *Storage) changed(ctx context.Context, postID int) {
+	s.invalidate(postID)
+	if s.bus == nil {
+		return
+	}
+	if err := s.bus.Publish(ctx, postID); err != nil {
+		// the other instances catch up when the TTL runs out
+		slog.WarnContext(ctx, "publishing cache invalidation failed",
+			slog.Int("post_id", postID), slog.String("error", err.Error()))
+	}
+}
+
+// copyPost and copyComments let callers modify what they get, down to the
+// comments and their mentions, without touching the cache.
+func copyPost(post *model.Post) *model.Post {
+	cp := *post
+	if post.Comments != nil {
+		cp.Comments = copyComments(post.Comments)
+	}
+	cp.Tags = append([]string(nil), post.Tags...)
+	return &cp
+}
+
+func copyComments(comments []*model.Comment) []*model.Comment {
+	cp := make([]*model.Comment, len(comments))
+	for i, comment := range comments {
+		c := *comment
+		if comment.ParentID != nil {
+			parentID := *comment.ParentID
+			c.ParentID = &parentID
+		}
+		c.Mentions = append([]string(nil), comment.Mentions...)
+		cp[i] = &c
+	}
+	return cp
+}
+
+func copyPosts(posts []*model.Post) []*model.Post {
+	cp := make([]*model.Post, len(posts))
+	for i, post := range posts {
+		cp[i] = copyPost(post)
+	}
+	return cp
+}
+
+func (s *Storage) CreatePost(ctx context.Context, post *model.Post) error {
+	if err := s.next.CreatePost(ctx, post); err != nil {
+		return err
+	}
+	s.changed(ctx, post.ID)
+	return nil
+}
+
+func (s *Storage) GetPosts(ctx context.Context) ([]*model.Post, error) {
+	cached, generation, ok := s.get(postsKey)
+	if ok {
+		return copyPosts(cached.([]*model.Post)), nil
+	}
+	posts, err := s.next.GetPosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.add(postsKey, copyPosts(posts), s.cfg.ListTTL, generation)
+	return posts, nil
+}
+
+func (s *Storage) GetPost(ctx context.Context, id int) (*model.Post, error) {
+	key := postKey(id)
+	cached, generation, ok := s.get(key)
+	if ok {
+		return copyPost(cached.(*model.Post)), nil
+	}
+	post, err := s.next.GetPost(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.add(key, copyPost(post), s.cfg.PostTTL, generation)
+	return post, nil
+}
+
+// GetPostHeader, GetComment and GetCommentDepth aren't cached, being
+// single rows.
+func (s *Storage) GetPostHeader(ctx context.Context, id int) (*model.Post, error) {
+	return s.next.GetPostHeader(ctx, id)
+}
+
+func (s *Storage) GetComment(ctx context.Context, id int) (*model.Comment, error) {
+	return s.next.GetComment(ctx, id)
+}
+
+func (s *Storage) GetCommentDepth(ctx context.Context, id int) (int, error) {
+	return s.next.GetCommentDepth(ctx, id)
+}
+
+func (s *Storage) CreateComment(ctx context.Context, comment *model.Comment) error {
+	if err := s.next.CreateComment(ctx, comment); err != nil {
+		return err
+	}
+	s.changed(ctx, comment.PostID)
+	return nil
+}
+
+func (s *Storage) UpdatePost(ctx context.Context, id int, update storage.PostUpdate) (*model.Post, error) {
+	post, err := s.next.UpdatePost(ctx, id, update)
+	if err != nil {
+		return nil, err
+	}
+	s.changed(ctx, id)
+	return post, nil
+}
+
+// GetPostsByTag and GetTags aren't cached: no invalidation reaches them.
+func (s *Storage) GetPostsByTag(ctx context.Context, tag string) ([]*model.Post, error) {
+	return s.next.GetPostsByTag(ctx, tag)
+}
+
+func (s *Storage) GetTags(ctx context.Context) ([]*model.TagCount, error) {
+	return s.next.GetTags(ctx)
+}
+
+func (s *Storage) DisableComments(ctx context.Context, postID int) (*model.Post, error) {
+	post, err := s.next.DisableComments(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	s.changed(ctx, postID)
+	return post, nil
+}
+
+func (s *Storage) EnableComments(ctx context.Context, postID int) (*model.Post, error) {
+	post, err := s.next.EnableComments(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	s.changed(ctx, postID)
+	return post, nil
+}
+
+// Reactions aren't part of the cached posts, so they go straight through.
+
+func (s *Storage) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	return s.next.AddReaction(ctx, reaction)
+}
+
+func (s *Storage) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	return s.next.RemoveReaction(ctx, reaction)
+}
+
+func (s *Storage) GetReactionCounts(ctx context.Context, postID int, commentID *int) ([]*model.ReactionCount, error) {
+	return s.next.GetReactionCounts(ctx, postID, commentID)
+}
+
+func (s *Storage) GetUserReactions(ctx context.Context, postID int, commentID *int, user string) ([]string, error) {
+	return s.next.GetUserReactions(ctx, postID, commentID, user)
+}
+
+func (s *Storage) Vote(ctx context.Context, vote *model.Vote) (*model.Comment, error) {
+	comment, err := s.next.Vote(ctx, vote)
+	if err != nil {
+		return nil, err
+	}
+	s.changed(ctx, comment.PostID)
+	return comment, nil
+}
+
+func (s *Storage) GetUserVote(ctx context.Context, commentID int, user string) (int, error) {
+	return s.next.GetUserVote(ctx, commentID, user)
+}
+
+func (s *Storage) GetComments(ctx context.Context, q storage.CommentQuery) ([]*model.Comment, error) {
+	key := pageKey(q)
+	cached, generation, ok := s.get(key)
+	if ok {
+		return copyComments(cached.(page).comments), nil
+	}
+	comments, err := s.next.GetComments(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	s.add(key, page{postID: q.PostID, comments: copyComments(comments)}, s.cfg.PostTTL, generation)
+	return comments, nil
+}
+
+func (s *Storage) ResolveUsers(ctx context.Context, names []string) ([]string, error) {
+	return s.next.ResolveUsers(ctx, names)
+}
+
+func (s *Storage) CreateNotification(ctx context.Context, notification *model.Notification) error {
+	return s.next.CreateNotification(ctx, notification)
+}
+
+func (s *Storage) GetNotifications(ctx context.Context, q storage.NotificationQuery) ([]*model.Notification, error) {
+	return s.next.GetNotifications(ctx, q)
+}
+
+func (s *Storage) MarkNotificationsRead(ctx context.Context, user string, ids []int) (int, error) {
+	return s.next.MarkNotificationsRead(ctx, user, ids)
+}
+
+func (s *Storage) ReportComment(ctx context.Context, report *model.Report) error {
+	return s.next.ReportComment(ctx, report)
+}
+
+func (s *Storage) GetModerationQueue(ctx context.Context, limit int) ([]*model.ReportedComment, error) {
+	return s.next.GetModerationQueue(ctx, limit)
+}
+
+func (s *Storage) ResolveReports(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, int, error) {
+	comment, closed, err := s.next.ResolveReports(ctx, commentID, action)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.changed(ctx, comment.PostID)
+	return comment, closed, nil
+}
+
+func (s *Storage) IsBanned(ctx context.Context, user string) (bool, error) {
+	return s.next.IsBanned(ctx, user)
+}
+
+func (s *Storage) TrainSpam(ctx context.Context, tokens []string, spam bool) error {
+	return s.next.TrainSpam(ctx, tokens, spam)
+}
+
+func (s *Storage) GetSpamModel(ctx context.Context) (*model.SpamModel, error) {
+	return s.next.GetSpamModel(ctx)
+}
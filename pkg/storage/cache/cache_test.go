@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"post-comments/pkg/model"
+	"post-comments/pkg/storage"
+	"post-comments/pkg/storage/storagetest"
+)
+
+type fakeBus struct {
+	published []int
+}
+
+func (b *fakeBus) Publish(ctx context.Context, postID int) error {
+	b.published = append(b.published, postID)
+	return nil
+}
+
+func (b *fakeBus) Listen(ctx context.Context, invalidate func(postID int), purge func()) error {
+	return nil
+}
+
+func newCached(t *testing.T, cfg Config) (*Storage, *fakeBus, *model.Post) {
+	t.Helper()
+	next := storage.NewInMemoryStorage()
+	post := &model.Post{Title: "title", Body: "body"}
+	require.NoError(t, next.CreatePost(context.Background(), post))
+
+	bus := &fakeBus{}
+	return New(next, cfg, bus), bus, post
+}
+
+func TestGetPostIsCached(t *testing.T) {
+	ctx := context.Background()
+	s, _, post := newCached(t, Config{Size: 10, PostTTL: time.Minute})
+
+	first, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	second, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, first.Title, second.Title)
+	assert.Equal(t, uint64(1), s.Stats().Hits)
+	assert.Equal(t, uint64(1), s.Stats().Misses)
+}
+
+func TestCachedPostsAreCopies(t *testing.T) {
+	ctx := context.Background()
+	s, _, post := newCached(t, Config{Size: 10, PostTTL: time.Minute})
+	require.NoError(t, s.next.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "hi @bob", Mentions: []string{"bob"}}))
+
+	_, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	first, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	first.Comments[0].Body = ""
+	first.Comments[0].Hidden = true
+	first.Comments[0].Mentions[0] = "eve"
+
+	second, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "hi @bob", second.Comments[0].Body)
+	assert.False(t, second.Comments[0].Hidden)
+	assert.Equal(t, []string{"bob"}, second.Comments[0].Mentions)
+}
+
+func TestCreateCommentInvalidates(t *testing.T) {
+	ctx := context.Background()
+	s, bus, post := newCached(t, Config{Size: 10, PostTTL: time.Minute, ListTTL: time.Minute})
+
+	_, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	_, err = s.GetPosts(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, s.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "hi"}))
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Len(t, got.Comments, 1)
+	posts, err := s.GetPosts(ctx)
+	require.NoError(t, err)
+	assert.Len(t, posts[0].Comments, 1)
+	assert.Equal(t, []int{post.ID}, bus.published)
+}
+
+func TestCommentPagesAreCached(t *testing.T) {
+	ctx := context.Background()
+	s, _, post := newCached(t, Config{Size: 10, PostTTL: time.Minute})
+	require.NoError(t, s.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "first"}))
+	q := storage.CommentQuery{PostID: post.ID, Order: model.CommentOrderNewest, Limit: 10}
+
+	first, err := s.GetComments(ctx, q)
+	require.NoError(t, err)
+	first[0].Body = "changed by the caller"
+	second, err := s.GetComments(ctx, q)
+	require.NoError(t, err)
+	assert.Equal(t, "first", second[0].Body)
+	assert.Equal(t, uint64(1), s.Stats().Hits)
+
+	require.NoError(t, s.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "second"}))
+	third, err := s.GetComments(ctx, q)
+	require.NoError(t, err)
+	assert.Len(t, third, 2)
+	assert.Len(t, s.pages[post.ID], 1)
+}
+
+func TestRemoteInvalidation(t *testing.T) {
+	ctx := context.Background()
+	s, _, post := newCached(t, Config{Size: 10, PostTTL: time.Minute})
+
+	_, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	s.invalidate(post.ID)
+	_, err = s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(2), s.Stats().Misses)
+}
+
+func TestTTLAndEviction(t *testing.T) {
+	ctx := context.Background()
+	s, _, post := newCached(t, Config{Size: 1, PostTTL: time.Minute, ListTTL: time.Minute})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	_, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	_, err = s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), s.Stats().Misses)
+
+	_, err = s.GetPosts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), s.Stats().Evictions)
+	assert.Equal(t, 1, s.Stats().Entries)
+}
+
+func TestStaleReadNotStored(t *testing.T) {
+	s, _, post := newCached(t, Config{Size: 10, PostTTL: time.Minute})
+
+	_, generation, _ := s.get(postKey(post.ID))
+	s.invalidate(post.ID)
+	s.add(postKey(post.ID), post, time.Minute, generation)
+
+	assert.Equal(t, 0, s.Stats().Entries)
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return New(storage.NewInMemoryStorage(), Config{Size: 10, PostTTL: time.Minute, ListTTL: time.Minute}, &fakeBus{})
+	})
+}
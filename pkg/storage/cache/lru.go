@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+type entry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// lru is a size bounded cache whose entries also expire. It is not safe for
+// concurrent use.
+type lru struct {
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	// removed, when set, is called for every entry that is evicted,
+	// expires or is deleted, but not for purged ones.
+	removed func(key string, value any)
+}
+
+func newLRU(size int, removed func(key string, value any)) *lru {
+	return &lru{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+		removed: removed,
+	}
+}
+
+func (c *lru) get(key string, now time.Time) (any, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !now.Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// add stores value and reports whether another entry had to be evicted.
+func (c *lru) add(key string, value any, expires time.Time) bool {
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return false
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, value: value, expires: expires})
+	if c.order.Len() <= c.size {
+		return false
+	}
+	c.remove(c.order.Back())
+	return true
+}
+
+func (c *lru) delete(key string) {
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *lru) purge() {
+	c.order.Init()
+	clear(c.entries)
+}
+
+func (c *lru) len() int {
+	return c.order.Len()
+}
+
+func (c *lru) remove(el *list.Element) {
+	c.order.Remove(el)
+	e := el.Value.(*entry)
+	delete(c.entries, e.key)
+	if c.removed != nil {
+		c.removed(e.key, e.value)
+	}
+}
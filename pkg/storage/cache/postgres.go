@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/jackc/pgx/v5"
+	"github.com/jmoiron/sqlx"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const channel = "post_comments_cache"
+
+// PostgresBus sends invalidations with NOTIFY and receives them on a
+// dedicated connection with LISTEN.
+type PostgresBus struct {
+	db       *sqlx.DB
+	dsn      string
+	instance string
+	// retry is the wait before reconnecting a lost listener.
+	retry time.Duration
+}
+
+// NewPostgresBus notifies through db and listens on its own connection to
+// dsn, since a pooled connection can't be kept waiting.
+func NewPostgresBus(db *sqlx.DB, dsn string) *PostgresBus {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return &PostgresBus{db: db, dsn: dsn, instance: hex.EncodeToString(id), retry: time.Second}
+}
+
+func (b *PostgresBus) Publish(ctx context.Context, postID int) error {
+	payload := b.instance + ":" + strconv.Itoa(postID)
+	_, err := b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// Listen reconnects until ctx is done. Notifications sent while it was
+// disconnected are lost, so purge is called after every reconnect.
+func (b *PostgresBus) Listen(ctx context.Context, invalidate func(postID int), purge func()) error {
+	for first := true; ; first = false {
+		if !first {
+			purge()
+		}
+		err := b.listen(ctx, invalidate)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Warn("cache invalidation listener disconnected, reconnecting",
+			slog.Duration("wait", b.retry), slog.String("error", err.Error()))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(b.retry):
+		}
+	}
+}
+
+func (b *PostgresBus) listen(ctx context.Context, invalidate func(postID int)) error {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return err
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		instance, id, ok := strings.Cut(notification.Payload, ":")
+		if instance == b.instance {
+			continue
+		}
+		postID, err := strconv.Atoi(id)
+		if !ok || err != nil {
+			slog.Warn("ignoring malformed cache invalidation", slog.String("payload", notification.Payload))
+			continue
+		}
+		invalidate(postID)
+	}
+}
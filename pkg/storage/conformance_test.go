@@ -0,0 +1,66 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"post-comments/pkg/database"
+	"post-comments/pkg/storage"
+	"post-comments/pkg/storage/storagetest"
+)
+
+// postgresDSNEnv names a database the Postgres suite may wipe; the suite is
+// skipped when it is unset.
+const postgresDSNEnv = "POSTCOMMENTS_TEST_DSN"
+
+func TestInMemoryConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewInMemoryStorage()
+	})
+}
+
+func TestDurableConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		d, err := storage.OpenDurableStorage(storage.DurableConfig{Dir: t.TempDir()})
+		require.NoError(t, err)
+		t.Cleanup(func() { d.Close() })
+		return d
+	})
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		ctx := context.Background()
+		db, err := database.NewSQLiteDB(ctx, database.SQLiteConfig{
+			Path:        filepath.Join(t.TempDir(), "test.db"),
+			BusyTimeout: 5 * time.Second,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		require.NoError(t, database.Migrate(ctx, db))
+		return storage.NewSQLiteStorage(db)
+	})
+}
+
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skip(postgresDSNEnv + " is not set")
+	}
+	ctx := context.Background()
+	db, err := sqlx.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.Migrate(ctx, db))
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		_, err := db.ExecContext(ctx, "TRUNCATE posts, comments, tags, banned_users, spam_classes, spam_tokens RESTART IDENTITY CASCADE")
+		require.NoError(t, err)
+		return storage.NewPostgresStorage(db)
+	})
+}
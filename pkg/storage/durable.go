@@ -0,0 +1,647 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"post-comments/pkg/model"
+	"sync"
+	"time"
+)
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot.dat"
+)
+
+type DurableConfig struct {
+	// Dir holds the snapshot and the write-ahead log. Persistence is off
+	// when it is empty.
+	Dir           string        `mapstructure:"dir"`
+	Fsync         FsyncPolicy   `mapstructure:"fsync"`
+	FsyncInterval time.Duration `mapstructure:"fsync_interval"`
+	// A snapshot is written every SnapshotInterval, or sooner once the log
+	// has SnapshotEvery records; zero disables either trigger.
+	SnapshotInterval time.Duration `mapstructure:"snapshot_interval"`
+	SnapshotEvery    int           `mapstructure:"snapshot_every"`
+}
+
+const (
+	opCreatePost          = "create_post"
+	opCreateComment       = "create_comment"
+	opUpdatePost          = "update_post"
+	opSetCommentsDisabled = "set_comments_disabled"
+	opAddReaction         = "add_reaction"
+	opRemoveReaction      = "remove_reaction"
+	opVote                = "vote"
+	opCreateNotification  = "create_notification"
+	opReadNotifications   = "read_notifications"
+	opReportComment       = "report_comment"
+	opResolveReports      = "resolve_reports"
+	opTrainSpam           = "train_spam"
+)
+
+type walRecord struct {
+	Seq          uint64                 `json:"seq"`
+	Op           string                 `json:"op"`
+	Post         *model.Post            `json:"post,omitempty"`
+	Comment      *model.Comment         `json:"comment,omitempty"`
+	Reaction     *model.Reaction        `json:"reaction,omitempty"`
+	Vote         *model.Vote            `json:"vote,omitempty"`
+	Notification *model.Notification    `json:"notification,omitempty"`
+	Report       *model.Report          `json:"report,omitempty"`
+	Update       *PostUpdate            `json:"update,omitempty"`
+	PostID       int                    `json:"post_id,omitempty"`
+	CommentID    int                    `json:"comment_id,omitempty"`
+	Action       model.ModerationAction `json:"action,omitempty"`
+	User         string                 `json:"user,omitempty"`
+	IDs          []int                  `json:"ids,omitempty"`
+	Tokens       []string               `json:"tokens,omitempty"`
+	Spam         bool                   `json:"spam,omitempty"`
+	Disabled     bool                   `json:"disabled,omitempty"`
+	At           time.Time              `json:"at,omitempty"`
+}
+
+type snapshot struct {
+	// Seq is the last log record included in the snapshot.
+	Seq           uint64                `json:"seq"`
+	Posts         []*model.Post         `json:"posts"`
+	Reactions     []*model.Reaction     `json:"reactions,omitempty"`
+	Votes         []*model.Vote         `json:"votes,omitempty"`
+	Notifications []*model.Notification `json:"notifications,omitempty"`
+	Reports       []*model.Report       `json:"reports,omitempty"`
+	Banned        []string              `json:"banned,omitempty"`
+	Spam          *model.SpamModel      `json:"spam,omitempty"`
+}
+
+// DurableStorage is an InMemoryStorage that survives restarts: every
+// mutation is appended to a write-ahead log, compacted into snapshots from
+// time to time, and both are replayed on startup.
+type DurableStorage struct {
+	*InMemoryStorage
+	cfg DurableConfig
+
+	// snapshotMu lets one snapshot be taken at a time.
+	snapshotMu sync.Mutex
+
+	// mu orders mutations so the log matches the in-memory state.
+	mu      sync.Mutex
+	wal     *os.File
+	seq     uint64
+	records int
+	dirty   bool
+	// failed is set when a write didn't reach the log; the log may then end
+	// in a partial record, so later writes are refused.
+	failed error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenDurableStorage restores the state saved in cfg.Dir and starts the
+// background snapshots and syncs. Close must be called to stop them.
+func OpenDurableStorage(cfg DurableConfig) (*DurableStorage, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &DurableStorage{
+		InMemoryStorage: NewInMemoryStorage(),
+		cfg:             cfg,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	if err := d.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := d.replay(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(d.path(walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	d.wal = wal
+	go d.background()
+	return d, nil
+}
+
+func (d *DurableStorage) path(name string) string {
+	return filepath.Join(d.cfg.Dir, name)
+}
+
+func (d *DurableStorage) loadSnapshot() error {
+	data, err := os.ReadFile(d.path(snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	payload, err := readFrame(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", errors.Join(ErrCorrupt, err))
+	}
+	var snap snapshot
+	if err := json.Unmarshal(payload, &snap); err != nil {
+		return fmt.Errorf("snapshot: %w", errors.Join(ErrCorrupt, err))
+	}
+	d.restore(&snap)
+	d.seq = snap.Seq
+	return nil
+}
+
+// replay applies the log records newer than the snapshot. A record cut
+// short by a crash ends the log and is truncated away; a record failing
+// its checksum stops the startup.
+func (d *DurableStorage) replay() error {
+	f, err := os.OpenFile(d.path(walFile), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := &countingReader{r: bufio.NewReader(f)}
+	for {
+		offset := r.n
+		payload, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			slog.Warn("truncating incomplete write-ahead log record", slog.Int64("offset", offset))
+			return f.Truncate(offset)
+		}
+		if err != nil {
+			return fmt.Errorf("write-ahead log record at offset %d: %w", offset, err)
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return fmt.Errorf("write-ahead log record at offset %d: %w", offset, errors.Join(ErrCorrupt, err))
+		}
+		// records already in the snapshot are left over from a crash
+		// between writing it and truncating the log
+		if rec.Seq <= d.seq {
+			continue
+		}
+		d.apply(rec)
+		d.seq = rec.Seq
+		d.records++
+	}
+}
+
+func (d *DurableStorage) apply(rec walRecord) {
+	switch rec.Op {
+	case opCreatePost:
+		d.restorePost(rec.Post)
+	case opCreateComment:
+		d.restoreComment(rec.Comment)
+	case opUpdatePost:
+		d.restorePostUpdate(rec.PostID, *rec.Update, rec.At)
+	case opSetCommentsDisabled:
+		d.restoreCommentsDisabled(rec.PostID, rec.Disabled, rec.At)
+	case opAddReaction:
+		d.restoreReaction(rec.Reaction, true)
+	case opRemoveReaction:
+		d.restoreReaction(rec.Reaction, false)
+	case opVote:
+		d.restoreVote(rec.Vote)
+	case opCreateNotification:
+		d.restoreNotification(rec.Notification)
+	case opReadNotifications:
+		d.restoreNotificationsRead(rec.User, rec.IDs)
+	case opReportComment:
+		d.restoreReport(rec.Report)
+	case opResolveReports:
+		d.restoreReportsResolved(rec.CommentID, rec.Action)
+	case opTrainSpam:
+		d.restoreSpam(rec.Tokens, rec.Spam)
+	}
+}
+
+// write appends rec to the log and only then applies it to the memory, the
+// same way replay does, so that a write the log refused is never seen;
+// d.mu must be held.
+func (d *DurableStorage) write(rec walRecord) error {
+	rec.Seq = d.seq + 1
+	payload, err := json.Marshal(rec)
+	if err == nil {
+		err = writeFrame(d.wal, payload)
+	}
+	if err == nil && d.cfg.Fsync == FsyncAlways {
+		err = d.wal.Sync()
+	}
+	if err != nil {
+		d.failed = fmt.Errorf("write-ahead log: %w", err)
+		slog.Error("write-ahead log write failed, refusing further writes", slog.String("error", err.Error()))
+		return d.failed
+	}
+	d.seq = rec.Seq
+	d.records++
+	d.dirty = true
+	d.apply(rec)
+	return nil
+}
+
+func (d *DurableStorage) CreatePost(ctx context.Context, post *model.Post) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return d.failed
+	}
+	d.preparePost(post)
+	logged := *post
+	logged.Comments = nil
+	return d.write(walRecord{Op: opCreatePost, Post: &logged})
+}
+
+func (d *DurableStorage) CreateComment(ctx context.Context, comment *model.Comment) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return d.failed
+	}
+	if err := d.prepareComment(comment); err != nil {
+		return err
+	}
+	logged := *comment
+	return d.write(walRecord{Op: opCreateComment, Comment: &logged})
+}
+
+func (d *DurableStorage) UpdatePost(ctx context.Context, id int, update PostUpdate) (*model.Post, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return nil, d.failed
+	}
+	if err := d.checkPost(id); err != nil {
+		return nil, err
+	}
+	rec := walRecord{Op: opUpdatePost, PostID: id, Update: &update, At: time.Now().UTC()}
+	if err := d.write(rec); err != nil {
+		return nil, err
+	}
+	return d.InMemoryStorage.GetPost(ctx, id)
+}
+
+func (d *DurableStorage) DisableComments(ctx context.Context, postID int) (*model.Post, error) {
+	return d.setCommentsDisabled(ctx, postID, true)
+}
+
+func (d *DurableStorage) EnableComments(ctx context.Context, postID int) (*model.Post, error) {
+	return d.setCommentsDisabled(ctx, postID, false)
+}
+
+func (d *DurableStorage) setCommentsDisabled(ctx context.Context, postID int, disabled bool) (*model.Post, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return nil, d.failed
+	}
+	if err := d.checkPost(postID); err != nil {
+		return nil, err
+	}
+	rec := walRecord{Op: opSetCommentsDisabled, PostID: postID, Disabled: disabled, At: time.Now().UTC()}
+	if err := d.write(rec); err != nil {
+		return nil, err
+	}
+	return d.InMemoryStorage.GetPost(ctx, postID)
+}
+
+func (d *DurableStorage) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	return d.setReaction(reaction, true)
+}
+
+func (d *DurableStorage) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	return d.setReaction(reaction, false)
+}
+
+func (d *DurableStorage) setReaction(reaction *model.Reaction, add bool) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return false, d.failed
+	}
+	exists, err := d.hasReaction(reaction)
+	if err != nil || exists == add {
+		return false, err
+	}
+	op := opAddReaction
+	if !add {
+		op = opRemoveReaction
+	}
+	logged := *reaction
+	if err := d.write(walRecord{Op: op, Reaction: &logged}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *DurableStorage) Vote(ctx context.Context, vote *model.Vote) (*model.Comment, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return nil, d.failed
+	}
+	if err := d.checkVote(vote); err != nil {
+		return nil, err
+	}
+	logged := *vote
+	if err := d.write(walRecord{Op: opVote, Vote: &logged}); err != nil {
+		return nil, err
+	}
+	return d.InMemoryStorage.GetComment(ctx, vote.CommentID)
+}
+
+func (d *DurableStorage) CreateNotification(ctx context.Context, notification *model.Notification) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return d.failed
+	}
+	if err := d.prepareNotification(notification); err != nil {
+		return err
+	}
+	logged := *notification
+	return d.write(walRecord{Op: opCreateNotification, Notification: &logged})
+}
+
+func (d *DurableStorage) MarkNotificationsRead(ctx context.Context, user string, ids []int) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return 0, d.failed
+	}
+	marked := d.countUnread(user, ids)
+	if marked == 0 {
+		return 0, nil
+	}
+	if err := d.write(walRecord{Op: opReadNotifications, User: user, IDs: ids}); err != nil {
+		return 0, err
+	}
+	return marked, nil
+}
+
+func (d *DurableStorage) ReportComment(ctx context.Context, report *model.Report) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return d.failed
+	}
+	if err := d.prepareReport(report); err != nil {
+		return err
+	}
+	logged := *report
+	if err := d.write(walRecord{Op: opReportComment, Report: &logged}); err != nil {
+		return err
+	}
+	// a second report by the same reporter keeps the time of the first
+	report.CreatedAt = logged.CreatedAt
+	return nil
+}
+
+func (d *DurableStorage) ResolveReports(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return nil, 0, d.failed
+	}
+	closed, err := d.openReports(commentID, action)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := d.write(walRecord{Op: opResolveReports, CommentID: commentID, Action: action}); err != nil {
+		return nil, 0, err
+	}
+	comment, err := d.InMemoryStorage.GetComment(ctx, commentID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return comment, closed, nil
+}
+
+func (d *DurableStorage) TrainSpam(ctx context.Context, tokens []string, spam bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed != nil {
+		return d.failed
+	}
+	return d.write(walRecord{Op: opTrainSpam, Tokens: tokens, Spam: spam})
+}
+
+// Snapshot writes the whole state to a new snapshot and drops the log
+// records it includes. The state is copied under d.mu but written out
+// without it, so that writes carry on meanwhile.
+func (d *DurableStorage) Snapshot() error {
+	d.snapshotMu.Lock()
+	defer d.snapshotMu.Unlock()
+
+	d.mu.Lock()
+	if d.failed != nil {
+		d.mu.Unlock()
+		return d.failed
+	}
+	snap := snapshot{
+		Seq:           d.seq,
+		Posts:         d.state(),
+		Reactions:     d.reactionState(),
+		Votes:         d.voteState(),
+		Notifications: d.notificationState(),
+		Reports:       d.reportState(),
+		Banned:        d.bannedState(),
+		Spam:          d.spamState(),
+	}
+	records := d.records
+	// the records after this offset aren't in the snapshot
+	info, err := d.wal.Stat()
+	d.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	payload, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(d.cfg.Dir, snapshotFile, func(f *os.File) error {
+		return writeFrame(f, payload)
+	}); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.rotate(info.Size()); err != nil {
+		return fmt.Errorf("rotating write-ahead log: %w", err)
+	}
+	d.records -= records
+	return nil
+}
+
+// rotate replaces the log with its records from offset on, the ones written
+// while a snapshot was taken; d.mu must be held. Until the new log is in
+// place the old one is kept, its records being skipped by replay once they
+// are in the snapshot.
+func (d *DurableStorage) rotate(offset int64) error {
+	if d.failed != nil {
+		return d.failed
+	}
+	old, err := os.Open(d.path(walFile))
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	if _, err := old.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(d.cfg.Dir, walFile, func(f *os.File) error {
+		_, err := io.Copy(f, old)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	wal, err := os.OpenFile(d.path(walFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		// the old handle writes to the replaced file, whose records
+		// would be lost
+		d.failed = fmt.Errorf("write-ahead log: %w", err)
+		return d.failed
+	}
+	d.wal.Close()
+	d.wal = wal
+	d.dirty = false
+	return nil
+}
+
+// writeFileAtomic replaces dir/name with what write writes, synced, so that
+// a crash leaves either the old or the new file.
+func writeFileAtomic(dir, name string, write func(f *os.File) error) error {
+	tmp := filepath.Join(dir, name+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(dir, name))
+	}
+	if err == nil {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func (d *DurableStorage) background() {
+	defer close(d.done)
+
+	var syncTick, snapshotTick <-chan time.Time
+	if d.cfg.Fsync == FsyncInterval && d.cfg.FsyncInterval > 0 {
+		t := time.NewTicker(d.cfg.FsyncInterval)
+		defer t.Stop()
+		syncTick = t.C
+	}
+	if d.cfg.SnapshotInterval > 0 {
+		t := time.NewTicker(d.cfg.SnapshotInterval)
+		defer t.Stop()
+		snapshotTick = t.C
+	}
+	// checks SnapshotEvery without slowing down writes
+	check := time.NewTicker(time.Second)
+	defer check.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-syncTick:
+			d.mu.Lock()
+			if d.dirty && d.failed == nil {
+				if err := d.wal.Sync(); err != nil {
+					slog.Error("syncing write-ahead log failed", slog.String("error", err.Error()))
+				}
+				d.dirty = false
+			}
+			d.mu.Unlock()
+		case <-snapshotTick:
+			d.snapshotIf(func() bool { return d.records > 0 })
+		case <-check.C:
+			d.snapshotIf(func() bool { return d.cfg.SnapshotEvery > 0 && d.records >= d.cfg.SnapshotEvery })
+		}
+	}
+}
+
+func (d *DurableStorage) snapshotIf(due func() bool) {
+	d.mu.Lock()
+	ok := due()
+	d.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := d.Snapshot(); err != nil {
+		slog.Error("writing snapshot failed", slog.String("error", err.Error()))
+	}
+}
+
+// Close writes a final snapshot and closes the log.
+func (d *DurableStorage) Close() error {
+	close(d.stop)
+	<-d.done
+
+	d.mu.Lock()
+	pending := d.records > 0
+	d.mu.Unlock()
+	var err error
+	if pending {
+		err = d.Snapshot()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if syncErr := d.wal.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := d.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"post-comments/pkg/model"
+)
+
+func fillDurable(t *testing.T, d *DurableStorage) *model.Post {
+	t.Helper()
+	ctx := context.Background()
+	post := &model.Post{Title: "title", Body: "body"}
+	require.NoError(t, d.CreatePost(ctx, post))
+	require.NoError(t, d.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "first"}))
+	post, err := d.DisableComments(ctx, post.ID)
+	require.NoError(t, err)
+	return post
+}
+
+func assertRestored(t *testing.T, dir string, want *model.Post) {
+	t.Helper()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	defer d.Close()
+
+	got, err := d.GetPost(context.Background(), want.ID)
+	require.NoError(t, err)
+	assert.Equal(t, want.Title, got.Title)
+	assert.True(t, got.CommentsDisabled)
+	assert.True(t, want.UpdatedAt.Equal(got.UpdatedAt))
+	require.Len(t, got.Comments, 1)
+	assert.Equal(t, "first", got.Comments[0].Body)
+}
+
+func TestDurableReplaysLog(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	post := fillDurable(t, d)
+	// simulate a crash: no Close, so no snapshot
+	require.NoError(t, d.wal.Close())
+
+	_, err = os.Stat(filepath.Join(dir, snapshotFile))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assertRestored(t, dir, post)
+}
+
+func TestDurableFailedWriteIsNotApplied(t *testing.T) {
+	ctx := context.Background()
+	d, err := OpenDurableStorage(DurableConfig{Dir: t.TempDir()})
+	require.NoError(t, err)
+	post := &model.Post{Title: "title", Body: "body"}
+	require.NoError(t, d.CreatePost(ctx, post))
+	// the log can't be written anymore
+	require.NoError(t, d.wal.Close())
+
+	assert.Error(t, d.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "lost"}))
+
+	got, err := d.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Empty(t, got.Comments)
+	posts, err := d.GetPosts(ctx)
+	require.NoError(t, err)
+	assert.Len(t, posts, 1)
+}
+
+func TestDurableSnapshotAndLog(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	post := fillDurable(t, d)
+	require.NoError(t, d.Snapshot())
+	_, err = d.EnableComments(context.Background(), post.ID)
+	require.NoError(t, err)
+	require.NoError(t, d.CreateComment(context.Background(), &model.Comment{PostID: post.ID, Body: "late"}))
+	require.NoError(t, d.Close())
+
+	d, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	defer d.Close()
+	got, err := d.GetPost(context.Background(), post.ID)
+	require.NoError(t, err)
+	assert.False(t, got.CommentsDisabled)
+	assert.Len(t, got.Comments, 2)
+}
+
+func TestDurableKeepsWritesDuringSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir, Fsync: FsyncNever})
+	require.NoError(t, err)
+
+	const posts = 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < posts; i++ {
+			assert.NoError(t, d.CreatePost(ctx, &model.Post{Title: "title", Body: "body"}))
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			require.NoError(t, d.Snapshot())
+		}
+	}
+	// simulate a crash: the posts written after the last snapshot are
+	// only in the log
+	require.NoError(t, d.wal.Close())
+
+	d, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	defer d.Close()
+	got, err := d.GetPosts(ctx)
+	require.NoError(t, err)
+	assert.Len(t, got, posts)
+}
+
+func TestDurableTruncatesTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	post := fillDurable(t, d)
+	require.NoError(t, d.wal.Close())
+
+	wal := filepath.Join(dir, walFile)
+	f, err := os.OpenFile(wal, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{200, 0, 0, 0, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assertRestored(t, dir, post)
+}
+
+func TestDurableDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	fillDurable(t, d)
+	require.NoError(t, d.wal.Close())
+
+	wal := filepath.Join(dir, walFile)
+	data, err := os.ReadFile(wal)
+	require.NoError(t, err)
+	data[frameHeader+2] ^= 0xff
+	require.NoError(t, os.WriteFile(wal, data, 0o644))
+
+	_, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestDurableDetectsCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	fillDurable(t, d)
+	require.NoError(t, d.wal.Close())
+
+	// a length pointing past the end of the log must not pass for a torn
+	// record, which would drop the records after it
+	wal := filepath.Join(dir, walFile)
+	data, err := os.ReadFile(wal)
+	require.NoError(t, err)
+	data[1] ^= 0x10
+	require.NoError(t, os.WriteFile(wal, data, 0o644))
+
+	_, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestReadFrameRejectsOversizedLength(t *testing.T) {
+	header := make([]byte, frameHeader)
+	binary.LittleEndian.PutUint32(header[0:4], maxFrameSize+1)
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(header[0:4], crcTable))
+
+	_, err := readFrame(bytes.NewReader(header))
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.Error(t, writeFrame(io.Discard, make([]byte, maxFrameSize+1)))
+}
+
+func TestDurableRestoresReactions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	post := &model.Post{Title: "title"}
+	require.NoError(t, d.CreatePost(ctx, post))
+	_, err = d.AddReaction(ctx, &model.Reaction{PostID: post.ID, User: "alice", Emoji: "👍"})
+	require.NoError(t, err)
+	require.NoError(t, d.Snapshot())
+	// replayed on top of the snapshot
+	_, err = d.AddReaction(ctx, &model.Reaction{PostID: post.ID, User: "bob", Emoji: "👍"})
+	require.NoError(t, err)
+	_, err = d.RemoveReaction(ctx, &model.Reaction{PostID: post.ID, User: "alice", Emoji: "👍"})
+	require.NoError(t, err)
+	require.NoError(t, d.wal.Close())
+
+	d, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	defer d.Close()
+	emojis, err := d.GetUserReactions(ctx, post.ID, nil, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"👍"}, emojis)
+	counts, err := d.GetReactionCounts(ctx, post.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*model.ReactionCount{{Emoji: "👍", Count: 1}}, counts)
+}
+
+func TestDurableRestoresVotes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	post := &model.Post{Title: "title"}
+	require.NoError(t, d.CreatePost(ctx, post))
+	comment := &model.Comment{PostID: post.ID, Body: "first"}
+	require.NoError(t, d.CreateComment(ctx, comment))
+	_, err = d.Vote(ctx, &model.Vote{CommentID: comment.ID, User: "alice", Value: 1})
+	require.NoError(t, err)
+	_, err = d.Vote(ctx, &model.Vote{CommentID: comment.ID, User: "bob", Value: 1})
+	require.NoError(t, err)
+	require.NoError(t, d.Snapshot())
+	// replayed on top of the snapshot
+	_, err = d.Vote(ctx, &model.Vote{CommentID: comment.ID, User: "alice", Value: -1})
+	require.NoError(t, err)
+	require.NoError(t, d.wal.Close())
+
+	d, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	defer d.Close()
+	value, err := d.GetUserVote(ctx, comment.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, -1, value)
+	got, err := d.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Comments, 1)
+	assert.Equal(t, 1, got.Comments[0].Upvotes)
+	assert.Equal(t, 1, got.Comments[0].Downvotes)
+}
+
+func TestDurableRestoresNotifications(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	post := &model.Post{Title: "title", Author: "alice"}
+	require.NoError(t, d.CreatePost(ctx, post))
+	comment := &model.Comment{PostID: post.ID, Body: "@alice", Author: "bob", Mentions: []string{"alice"}}
+	require.NoError(t, d.CreateComment(ctx, comment))
+	first := &model.Notification{User: "alice", Kind: model.NotificationKindMention, PostID: post.ID, CommentID: &comment.ID, Actor: "bob"}
+	require.NoError(t, d.CreateNotification(ctx, first))
+	require.NoError(t, d.Snapshot())
+	// replayed on top of the snapshot
+	second := &model.Notification{User: "alice", Kind: model.NotificationKindMention, PostID: post.ID, Actor: "bob"}
+	require.NoError(t, d.CreateNotification(ctx, second))
+	_, err = d.MarkNotificationsRead(ctx, "alice", []int{first.ID})
+	require.NoError(t, err)
+	require.NoError(t, d.wal.Close())
+
+	d, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	defer d.Close()
+	notifications, err := d.GetNotifications(ctx, NotificationQuery{User: "alice"})
+	require.NoError(t, err)
+	require.Len(t, notifications, 2)
+	assert.Equal(t, second.ID, notifications[0].ID)
+	assert.False(t, notifications[0].Read)
+	assert.Equal(t, first.CommentID, notifications[1].CommentID)
+	assert.True(t, notifications[1].Read)
+	users, err := d.ResolveUsers(ctx, []string{"ALICE", "Bob"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, users)
+
+	third := &model.Notification{User: "alice", Kind: model.NotificationKindMention, PostID: post.ID}
+	require.NoError(t, d.CreateNotification(ctx, third))
+	assert.Greater(t, third.ID, second.ID)
+	got, err := d.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, got.Comments[0].Mentions)
+}
+
+func TestDurableRestoresModeration(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	post := &model.Post{Title: "title"}
+	require.NoError(t, d.CreatePost(ctx, post))
+	spam := &model.Comment{PostID: post.ID, Body: "spam", Author: "bob"}
+	require.NoError(t, d.CreateComment(ctx, spam))
+	reported := &model.Comment{PostID: post.ID, Body: "rude", Author: "carol"}
+	require.NoError(t, d.CreateComment(ctx, reported))
+	require.NoError(t, d.ReportComment(ctx, &model.Report{CommentID: spam.ID, Reporter: "alice", Reason: "spam"}))
+	require.NoError(t, d.ReportComment(ctx, &model.Report{CommentID: reported.ID, Reporter: "alice", Reason: "rude"}))
+	require.NoError(t, d.Snapshot())
+	// replayed on top of the snapshot
+	require.NoError(t, d.ReportComment(ctx, &model.Report{CommentID: reported.ID, Reporter: "dave", Reason: "rude"}))
+	_, _, err = d.ResolveReports(ctx, spam.ID, model.ModerationActionBan)
+	require.NoError(t, err)
+	require.NoError(t, d.wal.Close())
+
+	d, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	queue, err := d.GetModerationQueue(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, reported.ID, queue[0].Comment.ID)
+	assert.Len(t, queue[0].Reports, 2)
+	banned, err := d.IsBanned(ctx, "bob")
+	require.NoError(t, err)
+	assert.True(t, banned)
+	got, err := d.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.True(t, got.Comments[0].Hidden)
+
+	// and the snapshot written on close holds the same state
+	require.NoError(t, d.Close())
+	d, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	defer d.Close()
+	queue, err = d.GetModerationQueue(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Len(t, queue[0].Reports, 2)
+	banned, err = d.IsBanned(ctx, "bob")
+	require.NoError(t, err)
+	assert.True(t, banned)
+}
+
+func TestDurableRestoresSpamModel(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	require.NoError(t, d.TrainSpam(ctx, []string{"buy", "now"}, true))
+	require.NoError(t, d.Snapshot())
+	// replayed on top of the snapshot
+	require.NoError(t, d.TrainSpam(ctx, []string{"hello", "now"}, false))
+	require.NoError(t, d.wal.Close())
+
+	d, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	defer d.Close()
+	m, err := d.GetSpamModel(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.Spam)
+	assert.Equal(t, 1, m.Ham)
+	assert.Equal(t, model.TokenCounts{Spam: 1, Ham: 1}, m.Tokens["now"])
+	assert.Equal(t, model.TokenCounts{Ham: 1}, m.Tokens["hello"])
+}
+
+func TestDurableRestoresTags(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d, err := OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	post := &model.Post{Title: "title", Body: "body", Tags: []string{"go", "news"}}
+	require.NoError(t, d.CreatePost(ctx, post))
+	require.NoError(t, d.Snapshot())
+	// replayed on top of the snapshot
+	title, tags := "renamed", []string{"rust"}
+	updated, err := d.UpdatePost(ctx, post.ID, PostUpdate{Title: &title, Tags: &tags})
+	require.NoError(t, err)
+	require.NoError(t, d.wal.Close())
+
+	d, err = OpenDurableStorage(DurableConfig{Dir: dir})
+	require.NoError(t, err)
+	defer d.Close()
+	got, err := d.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", got.Title)
+	assert.Equal(t, "body", got.Body)
+	assert.Equal(t, []string{"rust"}, got.Tags)
+	assert.True(t, updated.UpdatedAt.Equal(got.UpdatedAt))
+	byTag, err := d.GetPostsByTag(ctx, "go")
+	require.NoError(t, err)
+	assert.Empty(t, byTag)
+}
@@ -3,89 +3,1131 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
 	"post-comments/pkg/model"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// InMemoryStorage keeps posts and comments in maps indexed by ID, post and
+// parent. Stored values are never handed out: writes keep a copy of their
+// argument and reads return copies, so callers can't change the state
+// without holding the lock.
 type InMemoryStorage struct {
-	posts    []*model.Post
-	comments []*model.Comment
-	mu       sync.RWMutex
+	mu sync.RWMutex
+
+	posts map[int]*model.Post
+	// order lists post IDs in creation order, for GetPosts.
+	order []int
+	// tags lists the IDs of the posts of each tag in creation order.
+	tags     map[string][]int
+	comments map[int]*model.Comment
+	// byPost and byParent list comment IDs in creation order.
+	byPost   map[int][]int
+	byParent map[int][]int
+	// reactions holds, per post or comment, the users who reacted with each
+	// emoji.
+	reactions map[reactionTarget]map[string]map[string]struct{}
+	// votes holds, per comment, the value of each user's vote.
+	votes map[int]map[string]int
+	// users holds the authors of posts and comments by lower-cased name.
+	users map[string][]string
+	// notifications lists each user's notifications in creation order.
+	notifications map[string][]*model.Notification
+	// reports lists the open reports of each comment in filing order.
+	reports map[int][]*model.Report
+	banned  map[string]bool
+	spam    model.SpamModel
+
+	lastPostID         int
+	lastCommentID      int
+	lastNotificationID int
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
 	return &InMemoryStorage{
-		posts:    []*model.Post{},
-		comments: []*model.Comment{},
+		posts:     map[int]*model.Post{},
+		tags:      map[string][]int{},
+		comments:  map[int]*model.Comment{},
+		byPost:    map[int][]int{},
+		byParent:  map[int][]int{},
+		reactions: map[reactionTarget]map[string]map[string]struct{}{},
+		votes:     map[int]map[string]int{},
+		users:     map[string][]string{},
+
+		notifications: map[string][]*model.Notification{},
+		reports:       map[int][]*model.Report{},
+		banned:        map[string]bool{},
+		spam:          model.SpamModel{Tokens: map[string]model.TokenCounts{}},
 	}
 }
 
+func copyComment(comment *model.Comment) *model.Comment {
+	cp := *comment
+	if comment.ParentID != nil {
+		parentID := *comment.ParentID
+		cp.ParentID = &parentID
+	}
+	cp.Mentions = slices.Clone(comment.Mentions)
+	return &cp
+}
+
+// post returns a copy of the post with copies of its comments; s.mu must be
+// held.
+func (s *InMemoryStorage) post(id int) *model.Post {
+	cp := *s.posts[id]
+	cp.Tags = slices.Clone(cp.Tags)
+	ids := s.byPost[id]
+	cp.Comments = make([]*model.Comment, len(ids))
+	for i, commentID := range ids {
+		cp.Comments[i] = copyComment(s.comments[commentID])
+	}
+	return &cp
+}
+
 func (s *InMemoryStorage) CreatePost(ctx context.Context, post *model.Post) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	post.ID = len(s.posts) + 1
+	s.lastPostID++
+	post.ID = s.lastPostID
 	post.CreatedAt = time.Now().UTC()
-	post.UpdatedAt = time.Now().UTC()
-	s.posts = append(s.posts, post)
+	post.UpdatedAt = post.CreatedAt
+	s.addPost(post)
 	return nil
 }
 
+// addPost stores a copy of post without its comments; s.mu must be held.
+func (s *InMemoryStorage) addPost(post *model.Post) {
+	stored := *post
+	stored.Comments = nil
+	stored.Tags = slices.Clone(post.Tags)
+	s.posts[stored.ID] = &stored
+	s.order = append(s.order, stored.ID)
+	s.tagPost(stored.ID, stored.Tags)
+	s.addUser(stored.Author)
+}
+
+// tagPost and untagPost add and remove a post from the index of tags; s.mu
+// must be held.
+func (s *InMemoryStorage) tagPost(id int, tags []string) {
+	for _, tag := range tags {
+		ids := s.tags[tag]
+		if i, found := slices.BinarySearch(ids, id); !found {
+			s.tags[tag] = slices.Insert(ids, i, id)
+		}
+	}
+}
+
+func (s *InMemoryStorage) untagPost(id int, tags []string) {
+	for _, tag := range tags {
+		ids := s.tags[tag]
+		if i, found := slices.BinarySearch(ids, id); found {
+			ids = slices.Delete(ids, i, i+1)
+		}
+		if len(ids) == 0 {
+			delete(s.tags, tag)
+		} else {
+			s.tags[tag] = ids
+		}
+	}
+}
+
 func (s *InMemoryStorage) GetPosts(ctx context.Context) ([]*model.Post, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.posts, nil
+	posts := make([]*model.Post, len(s.order))
+	for i, id := range s.order {
+		posts[i] = s.post(id)
+	}
+	return posts, nil
 }
 
 func (s *InMemoryStorage) GetPost(ctx context.Context, id int) (*model.Post, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, post := range s.posts {
-		if post.ID == id {
-			return post, nil
+	if _, ok := s.posts[id]; !ok {
+		return nil, errors.New("post not found")
+	}
+	return s.post(id), nil
+}
+
+func (s *InMemoryStorage) GetPostHeader(ctx context.Context, id int) (*model.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	post, ok := s.posts[id]
+	if !ok {
+		return nil, errors.New("post not found")
+	}
+	cp := *post
+	cp.Tags = nil
+	return &cp, nil
+}
+
+func (s *InMemoryStorage) UpdatePost(ctx context.Context, id int, update PostUpdate) (*model.Post, error) {
+	return s.updatePost(id, update, time.Now().UTC())
+}
+
+func (s *InMemoryStorage) updatePost(id int, update PostUpdate, at time.Time) (*model.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	post, ok := s.posts[id]
+	if !ok {
+		return nil, errors.New("post not found")
+	}
+	if update.Title != nil {
+		post.Title = *update.Title
+	}
+	if update.Body != nil {
+		post.Body = *update.Body
+	}
+	if update.Tags != nil {
+		s.untagPost(id, post.Tags)
+		post.Tags = slices.Clone(*update.Tags)
+		s.tagPost(id, post.Tags)
+	}
+	post.UpdatedAt = at
+	return s.post(id), nil
+}
+
+func (s *InMemoryStorage) GetPostsByTag(ctx context.Context, tag string) ([]*model.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := s.tags[tag]
+	posts := make([]*model.Post, len(ids))
+	for i, id := range ids {
+		posts[i] = s.post(id)
+	}
+	return posts, nil
+}
+
+func (s *InMemoryStorage) GetTags(ctx context.Context) ([]*model.TagCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tags := make([]*model.TagCount, 0, len(s.tags))
+	for tag, ids := range s.tags {
+		tags = append(tags, &model.TagCount{Tag: tag, Count: len(ids)})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
 		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, nil
+}
+
+func (s *InMemoryStorage) GetComment(ctx context.Context, id int) (*model.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	comment, ok := s.comments[id]
+	if !ok {
+		return nil, errors.New("comment not found")
+	}
+	return copyComment(comment), nil
+}
+
+func (s *InMemoryStorage) GetCommentDepth(ctx context.Context, id int) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	comment, ok := s.comments[id]
+	if !ok {
+		return 0, errors.New("comment not found")
+	}
+	depth := 1
+	for ; comment.ParentID != nil; depth++ {
+		comment = s.comments[*comment.ParentID]
+	}
+	return depth, nil
+}
+
+// Replies returns the direct replies to a comment, oldest first.
+func (s *InMemoryStorage) Replies(ctx context.Context, commentID int) ([]*model.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.comments[commentID]; !ok {
+		return nil, errors.New("comment not found")
+	}
+	ids := s.byParent[commentID]
+	replies := make([]*model.Comment, len(ids))
+	for i, id := range ids {
+		replies[i] = copyComment(s.comments[id])
 	}
-	return nil, errors.New("post not found")
+	return replies, nil
 }
 
 func (s *InMemoryStorage) CreateComment(ctx context.Context, comment *model.Comment) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for _, post := range s.posts {
-		if post.ID == comment.PostID && !post.CommentsDisabled {
-			comment.ID = len(s.comments) + 1
-			comment.CreatedAt = time.Now().UTC()
-			comment.UpdatedAt = time.Now().UTC()
-			post.Comments = append(post.Comments, comment)
-			s.comments = append(s.comments, comment)
-			return nil
+	if err := s.checkComment(comment); err != nil {
+		return err
+	}
+	s.lastCommentID++
+	comment.ID = s.lastCommentID
+	comment.CreatedAt = time.Now().UTC()
+	comment.UpdatedAt = comment.CreatedAt
+	s.addComment(comment)
+	return nil
+}
+
+// checkComment returns an error unless comment can be added to its post;
+// s.mu must be held.
+func (s *InMemoryStorage) checkComment(comment *model.Comment) error {
+	post, ok := s.posts[comment.PostID]
+	if !ok || post.CommentsDisabled {
+		return errors.New("post not found or comments disabled")
+	}
+	if comment.ParentID != nil {
+		parent, ok := s.comments[*comment.ParentID]
+		if !ok || parent.PostID != comment.PostID {
+			return errors.New("parent comment not found")
 		}
 	}
-	return errors.New("post not found or comments disabled")
+	return nil
+}
+
+// addComment stores a copy of comment; s.mu must be held.
+func (s *InMemoryStorage) addComment(comment *model.Comment) {
+	stored := copyComment(comment)
+	s.comments[stored.ID] = stored
+	s.byPost[stored.PostID] = append(s.byPost[stored.PostID], stored.ID)
+	if stored.ParentID != nil {
+		s.byParent[*stored.ParentID] = append(s.byParent[*stored.ParentID], stored.ID)
+	}
+	s.addUser(stored.Author)
+}
+
+// addUser records an author, "" being anonymous; s.mu must be held.
+func (s *InMemoryStorage) addUser(user string) {
+	if user == "" {
+		return
+	}
+	key := strings.ToLower(user)
+	spellings := s.users[key]
+	if i, found := slices.BinarySearch(spellings, user); !found {
+		s.users[key] = slices.Insert(spellings, i, user)
+	}
 }
 
 func (s *InMemoryStorage) DisableComments(ctx context.Context, postID int) (*model.Post, error) {
+	return s.setCommentsDisabled(postID, true, time.Now().UTC())
+}
+
+func (s *InMemoryStorage) EnableComments(ctx context.Context, postID int) (*model.Post, error) {
+	return s.setCommentsDisabled(postID, false, time.Now().UTC())
+}
+
+func (s *InMemoryStorage) setCommentsDisabled(postID int, disabled bool, at time.Time) (*model.Post, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for _, post := range s.posts {
-		if post.ID == postID {
-			post.CommentsDisabled = true
-			post.UpdatedAt = time.Now().UTC()
-			return post, nil
+	post, ok := s.posts[postID]
+	if !ok {
+		return nil, errors.New("post not found")
+	}
+	post.CommentsDisabled = disabled
+	post.UpdatedAt = at
+	return s.post(postID), nil
+}
+
+// reactionTarget is a post, or one of its comments when commentID isn't 0.
+type reactionTarget struct {
+	postID    int
+	commentID int
+}
+
+func targetOf(postID int, commentID *int) reactionTarget {
+	target := reactionTarget{postID: postID}
+	if commentID != nil {
+		target.commentID = *commentID
+	}
+	return target
+}
+
+// checkTarget returns an error unless the post and comment exist; s.mu must
+// be held.
+func (s *InMemoryStorage) checkTarget(postID int, commentID *int) error {
+	if _, ok := s.posts[postID]; !ok {
+		return errors.New("post not found")
+	}
+	if commentID != nil {
+		comment, ok := s.comments[*commentID]
+		if !ok || comment.PostID != postID {
+			return errors.New("comment not found")
 		}
 	}
-	return nil, errors.New("post not found")
+	return nil
 }
 
-func (s *InMemoryStorage) EnableComments(ctx context.Context, postID int) (*model.Post, error) {
+func (s *InMemoryStorage) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkTarget(reaction.PostID, reaction.CommentID); err != nil {
+		return false, err
+	}
+	return s.addReaction(reaction), nil
+}
+
+// addReaction reports whether the reaction is new; s.mu must be held.
+func (s *InMemoryStorage) addReaction(reaction *model.Reaction) bool {
+	target := targetOf(reaction.PostID, reaction.CommentID)
+	emojis, ok := s.reactions[target]
+	if !ok {
+		emojis = map[string]map[string]struct{}{}
+		s.reactions[target] = emojis
+	}
+	users, ok := emojis[reaction.Emoji]
+	if !ok {
+		users = map[string]struct{}{}
+		emojis[reaction.Emoji] = users
+	}
+	if _, ok := users[reaction.User]; ok {
+		return false
+	}
+	users[reaction.User] = struct{}{}
+	return true
+}
+
+func (s *InMemoryStorage) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkTarget(reaction.PostID, reaction.CommentID); err != nil {
+		return false, err
+	}
+	return s.removeReaction(reaction), nil
+}
+
+// removeReaction reports whether the reaction existed; s.mu must be held.
+func (s *InMemoryStorage) removeReaction(reaction *model.Reaction) bool {
+	target := targetOf(reaction.PostID, reaction.CommentID)
+	users := s.reactions[target][reaction.Emoji]
+	if _, ok := users[reaction.User]; !ok {
+		return false
+	}
+	delete(users, reaction.User)
+	if len(users) == 0 {
+		delete(s.reactions[target], reaction.Emoji)
+	}
+	if len(s.reactions[target]) == 0 {
+		delete(s.reactions, target)
+	}
+	return true
+}
+
+func (s *InMemoryStorage) GetReactionCounts(ctx context.Context, postID int, commentID *int) ([]*model.ReactionCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	emojis := s.reactions[targetOf(postID, commentID)]
+	counts := make([]*model.ReactionCount, 0, len(emojis))
+	for emoji, users := range emojis {
+		counts = append(counts, &model.ReactionCount{Emoji: emoji, Count: len(users)})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Emoji < counts[j].Emoji
+	})
+	return counts, nil
+}
+
+func (s *InMemoryStorage) GetUserReactions(ctx context.Context, postID int, commentID *int, user string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reacted := []string{}
+	for emoji, users := range s.reactions[targetOf(postID, commentID)] {
+		if _, ok := users[user]; ok {
+			reacted = append(reacted, emoji)
+		}
+	}
+	sort.Strings(reacted)
+	return reacted, nil
+}
+
+func (s *InMemoryStorage) Vote(ctx context.Context, vote *model.Vote) (*model.Comment, error) {
+	if err := checkVote(vote); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	comment, ok := s.comments[vote.CommentID]
+	if !ok {
+		return nil, errors.New("comment not found")
+	}
+	s.applyVote(vote)
+	return copyComment(comment), nil
+}
+
+func checkVote(vote *model.Vote) error {
+	if vote.Value < -1 || vote.Value > 1 {
+		return fmt.Errorf("vote must be -1, 0 or 1, got %d", vote.Value)
+	}
+	return nil
+}
+
+// voteDelta returns how the counts of a comment change when a user's vote
+// goes from old to value.
+func voteDelta(old, value int) (up, down int) {
+	count := func(v, want int) int {
+		if v == want {
+			return 1
+		}
+		return 0
+	}
+	return count(value, 1) - count(old, 1), count(value, -1) - count(old, -1)
+}
+
+// applyVote replaces the user's vote on an existing comment and updates its
+// counts; s.mu must be held.
+func (s *InMemoryStorage) applyVote(vote *model.Vote) {
+	comment := s.comments[vote.CommentID]
+	up, down := voteDelta(s.votes[vote.CommentID][vote.User], vote.Value)
+	comment.Upvotes += up
+	comment.Downvotes += down
+	s.setVote(vote)
+}
+
+// setVote records the user's vote without touching the counts; s.mu must be
+// held.
+func (s *InMemoryStorage) setVote(vote *model.Vote) {
+	users, ok := s.votes[vote.CommentID]
+	if !ok {
+		users = map[string]int{}
+		s.votes[vote.CommentID] = users
+	}
+	if vote.Value == 0 {
+		delete(users, vote.User)
+		if len(users) == 0 {
+			delete(s.votes, vote.CommentID)
+		}
+		return
+	}
+	users[vote.User] = vote.Value
+}
+
+func (s *InMemoryStorage) GetUserVote(ctx context.Context, commentID int, user string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.comments[commentID]; !ok {
+		return 0, errors.New("comment not found")
+	}
+	return s.votes[commentID][user], nil
+}
+
+// GetComments ranks the stored comments and copies only the page.
+func (s *InMemoryStorage) GetComments(ctx context.Context, q CommentQuery) ([]*model.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.posts[q.PostID]; !ok {
+		return nil, errors.New("post not found")
+	}
+	var roots []*model.Comment
+	for _, id := range s.byPost[q.PostID] {
+		if comment := s.comments[id]; comment.ParentID == nil {
+			roots = append(roots, comment)
+		}
+	}
+	roots, err := q.Ranking.Page(roots, q.Order, q.After, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var replies []*model.Comment
+	parents := roots
+	for len(parents) > 0 {
+		var children []*model.Comment
+		for _, parent := range parents {
+			for _, id := range s.byParent[parent.ID] {
+				children = append(children, s.comments[id])
+			}
+		}
+		replies = append(replies, children...)
+		parents = children
+	}
+
+	page := q.Ranking.Threads(roots, replies, q.Order)
+	for i, comment := range page {
+		page[i] = copyComment(comment)
+	}
+	return page, nil
+}
+
+func (s *InMemoryStorage) ResolveUsers(ctx context.Context, names []string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var known []string
+	for _, name := range names {
+		known = append(known, s.users[strings.ToLower(name)]...)
+	}
+	return resolveUsers(names, known), nil
+}
+
+// resolveUsers matches names with known, a superset of the users whose
+// names are equal to them regardless of case. When several users match a
+// name, the one spelled the same wins, then the first in byte order.
+func resolveUsers(names, known []string) []string {
+	known = slices.Clone(known)
+	slices.Sort(known)
+	users := []string{}
+	for _, name := range names {
+		match := ""
+		for _, user := range known {
+			if user == name {
+				match = user
+				break
+			}
+			if match == "" && strings.EqualFold(user, name) {
+				match = user
+			}
+		}
+		if match != "" && !slices.Contains(users, match) {
+			users = append(users, match)
+		}
+	}
+	return users
+}
+
+func (s *InMemoryStorage) CreateNotification(ctx context.Context, notification *model.Notification) error {
+	if !notification.Kind.IsValid() {
+		return fmt.Errorf("invalid notification kind %q", notification.Kind)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkTarget(notification.PostID, notification.CommentID); err != nil {
+		return err
+	}
+	s.lastNotificationID++
+	notification.ID = s.lastNotificationID
+	notification.CreatedAt = time.Now().UTC()
+	s.addNotification(notification)
+	return nil
+}
+
+func copyNotification(notification *model.Notification) *model.Notification {
+	cp := *notification
+	if notification.CommentID != nil {
+		commentID := *notification.CommentID
+		cp.CommentID = &commentID
+	}
+	return &cp
+}
+
+// addNotification stores a copy of notification; s.mu must be held.
+func (s *InMemoryStorage) addNotification(notification *model.Notification) {
+	s.notifications[notification.User] = append(s.notifications[notification.User], copyNotification(notification))
+}
+
+func (s *InMemoryStorage) GetNotifications(ctx context.Context, q NotificationQuery) ([]*model.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := s.notifications[q.User]
+	end := len(all)
+	if q.After != nil {
+		end, _ = slices.BinarySearchFunc(all, *q.After, func(n *model.Notification, id int) int {
+			return n.ID - id
+		})
+	}
+	notifications := []*model.Notification{}
+	for i := end - 1; i >= 0 && (q.Limit <= 0 || len(notifications) < q.Limit); i-- {
+		if !q.UnreadOnly || !all[i].Read {
+			notifications = append(notifications, copyNotification(all[i]))
+		}
+	}
+	return notifications, nil
+}
+
+func (s *InMemoryStorage) MarkNotificationsRead(ctx context.Context, user string, ids []int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.markNotificationsRead(user, ids), nil
+}
+
+// markNotificationsRead is MarkNotificationsRead; s.mu must be held.
+func (s *InMemoryStorage) markNotificationsRead(user string, ids []int) int {
+	unread := s.unreadNotifications(user, ids)
+	for _, notification := range unread {
+		notification.Read = true
+	}
+	return len(unread)
+}
+
+// unreadNotifications returns the user's unread notifications among ids,
+// or all of them when ids is nil; s.mu must be held.
+func (s *InMemoryStorage) unreadNotifications(user string, ids []int) []*model.Notification {
+	var wanted map[int]bool
+	if ids != nil {
+		wanted = make(map[int]bool, len(ids))
+		for _, id := range ids {
+			wanted[id] = true
+		}
+	}
+	var unread []*model.Notification
+	for _, notification := range s.notifications[user] {
+		if !notification.Read && (wanted == nil || wanted[notification.ID]) {
+			unread = append(unread, notification)
+		}
+	}
+	return unread
+}
+
+func (s *InMemoryStorage) ReportComment(ctx context.Context, report *model.Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkReport(report); err != nil {
+		return err
+	}
+	report.CreatedAt = time.Now().UTC()
+	s.addReport(report)
+	return nil
+}
+
+// checkReport returns an error unless the reported comment can be reported;
+// s.mu must be held.
+func (s *InMemoryStorage) checkReport(report *model.Report) error {
+	comment, ok := s.comments[report.CommentID]
+	if !ok {
+		return errors.New("comment not found")
+	}
+	if comment.Hidden {
+		return errors.New("comment is hidden")
+	}
+	return nil
+}
+
+// addReport stores a copy of report, or updates the reason of the
+// reporter's earlier report and sets report.CreatedAt to its time; s.mu
+// must be held.
+func (s *InMemoryStorage) addReport(report *model.Report) {
+	for _, filed := range s.reports[report.CommentID] {
+		if filed.Reporter == report.Reporter {
+			filed.Reason = report.Reason
+			report.CreatedAt = filed.CreatedAt
+			return
+		}
+	}
+	cp := *report
+	s.reports[report.CommentID] = append(s.reports[report.CommentID], &cp)
+}
+
+func (s *InMemoryStorage) GetModerationQueue(ctx context.Context, limit int) ([]*model.ReportedComment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]int, 0, len(s.reports))
+	for id := range s.reports {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := s.reports[ids[i]], s.reports[ids[j]]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		if !a[0].CreatedAt.Equal(b[0].CreatedAt) {
+			return a[0].CreatedAt.Before(b[0].CreatedAt)
+		}
+		return ids[i] < ids[j]
+	})
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	queue := make([]*model.ReportedComment, 0, len(ids))
+	for _, id := range ids {
+		item := &model.ReportedComment{Comment: copyComment(s.comments[id])}
+		for _, report := range s.reports[id] {
+			cp := *report
+			item.Reports = append(item.Reports, &cp)
+		}
+		queue = append(queue, item)
+	}
+	return queue, nil
+}
+
+func (s *InMemoryStorage) ResolveReports(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, int, error) {
+	if !action.IsValid() {
+		return nil, 0, fmt.Errorf("invalid moderation action %q", action)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for _, post := range s.posts {
-		if post.ID == postID {
-			post.CommentsDisabled = false
-			post.UpdatedAt = time.Now().UTC()
-			return post, nil
+	if _, ok := s.comments[commentID]; !ok {
+		return nil, 0, errors.New("comment not found")
+	}
+	closed := s.resolveReports(commentID, action)
+	return copyComment(s.comments[commentID]), closed, nil
+}
+
+// resolveReports is ResolveReports for an existing comment, returning how
+// many reports it closed; s.mu must be held.
+func (s *InMemoryStorage) resolveReports(commentID int, action model.ModerationAction) int {
+	closed := len(s.reports[commentID])
+	delete(s.reports, commentID)
+	if action == model.ModerationActionDismiss {
+		return closed
+	}
+	comment := s.comments[commentID]
+	comment.Hidden = true
+	if action == model.ModerationActionBan && comment.Author != "" {
+		s.banned[comment.Author] = true
+	}
+	return closed
+}
+
+func (s *InMemoryStorage) IsBanned(ctx context.Context, user string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.banned[user], nil
+}
+
+func (s *InMemoryStorage) TrainSpam(ctx context.Context, tokens []string, spam bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trainSpam(tokens, spam)
+	return nil
+}
+
+// trainSpam is TrainSpam; s.mu must be held.
+func (s *InMemoryStorage) trainSpam(tokens []string, spam bool) {
+	if spam {
+		s.spam.Spam++
+	} else {
+		s.spam.Ham++
+	}
+	for _, token := range tokens {
+		counts := s.spam.Tokens[token]
+		if spam {
+			counts.Spam++
+		} else {
+			counts.Ham++
 		}
+		s.spam.Tokens[token] = counts
+	}
+}
+
+func (s *InMemoryStorage) GetSpamModel(ctx context.Context) (*model.SpamModel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := s.spam
+	m.Tokens = maps.Clone(s.spam.Tokens)
+	return &m, nil
+}
+
+// The functions below let DurableStorage check a write and fill in its IDs
+// and times before logging it. Nothing changes until the logged record is
+// applied; DurableStorage serializes its writes, so the state can't change
+// in between either.
+
+func (s *InMemoryStorage) preparePost(post *model.Post) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	post.ID = s.lastPostID + 1
+	post.CreatedAt = time.Now().UTC()
+	post.UpdatedAt = post.CreatedAt
+}
+
+func (s *InMemoryStorage) prepareComment(comment *model.Comment) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.checkComment(comment); err != nil {
+		return err
+	}
+	comment.ID = s.lastCommentID + 1
+	comment.CreatedAt = time.Now().UTC()
+	comment.UpdatedAt = comment.CreatedAt
+	return nil
+}
+
+func (s *InMemoryStorage) checkPost(id int) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.posts[id]; !ok {
+		return errors.New("post not found")
+	}
+	return nil
+}
+
+// hasReaction checks the target of reaction and reports whether the user
+// already reacted with its emoji.
+func (s *InMemoryStorage) hasReaction(reaction *model.Reaction) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.checkTarget(reaction.PostID, reaction.CommentID); err != nil {
+		return false, err
+	}
+	_, ok := s.reactions[targetOf(reaction.PostID, reaction.CommentID)][reaction.Emoji][reaction.User]
+	return ok, nil
+}
+
+func (s *InMemoryStorage) checkVote(vote *model.Vote) error {
+	if err := checkVote(vote); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.comments[vote.CommentID]; !ok {
+		return errors.New("comment not found")
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) prepareNotification(notification *model.Notification) error {
+	if !notification.Kind.IsValid() {
+		return fmt.Errorf("invalid notification kind %q", notification.Kind)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.checkTarget(notification.PostID, notification.CommentID); err != nil {
+		return err
+	}
+	notification.ID = s.lastNotificationID + 1
+	notification.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+// countUnread returns how many notifications MarkNotificationsRead would
+// mark.
+func (s *InMemoryStorage) countUnread(user string, ids []int) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.unreadNotifications(user, ids))
+}
+
+func (s *InMemoryStorage) prepareReport(report *model.Report) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.checkReport(report); err != nil {
+		return err
+	}
+	report.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+// openReports checks the action and comment of ResolveReports and returns
+// how many reports it would close.
+func (s *InMemoryStorage) openReports(commentID int, action model.ModerationAction) (int, error) {
+	if !action.IsValid() {
+		return 0, fmt.Errorf("invalid moderation action %q", action)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.comments[commentID]; !ok {
+		return 0, errors.New("comment not found")
+	}
+	return len(s.reports[commentID]), nil
+}
+
+// The functions below let DurableStorage save and rebuild the state, keeping
+// the IDs and times that were handed out.
+
+// state returns a copy of every post with its comments.
+func (s *InMemoryStorage) state() []*model.Post {
+	posts, _ := s.GetPosts(context.Background())
+	return posts
+}
+
+// reactionState returns every reaction, ordered by target, emoji and user.
+func (s *InMemoryStorage) reactionState() []*model.Reaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var reactions []*model.Reaction
+	for target, emojis := range s.reactions {
+		for emoji, users := range emojis {
+			for user := range users {
+				reaction := &model.Reaction{PostID: target.postID, User: user, Emoji: emoji}
+				if target.commentID != 0 {
+					commentID := target.commentID
+					reaction.CommentID = &commentID
+				}
+				reactions = append(reactions, reaction)
+			}
+		}
+	}
+	sort.Slice(reactions, func(i, j int) bool {
+		a, b := reactions[i], reactions[j]
+		ta, tb := targetOf(a.PostID, a.CommentID), targetOf(b.PostID, b.CommentID)
+		if ta != tb {
+			return ta.postID < tb.postID || ta.postID == tb.postID && ta.commentID < tb.commentID
+		}
+		if a.Emoji != b.Emoji {
+			return a.Emoji < b.Emoji
+		}
+		return a.User < b.User
+	})
+	return reactions
+}
+
+// voteState returns every vote, ordered by comment and user.
+func (s *InMemoryStorage) voteState() []*model.Vote {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var votes []*model.Vote
+	for commentID, users := range s.votes {
+		for user, value := range users {
+			votes = append(votes, &model.Vote{CommentID: commentID, User: user, Value: value})
+		}
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		if votes[i].CommentID != votes[j].CommentID {
+			return votes[i].CommentID < votes[j].CommentID
+		}
+		return votes[i].User < votes[j].User
+	})
+	return votes
+}
+
+// notificationState returns every notification, ordered by ID.
+func (s *InMemoryStorage) notificationState() []*model.Notification {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var notifications []*model.Notification
+	for _, all := range s.notifications {
+		for _, notification := range all {
+			notifications = append(notifications, copyNotification(notification))
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].ID < notifications[j].ID
+	})
+	return notifications
+}
+
+// reportState returns every open report, ordered by comment and filing.
+func (s *InMemoryStorage) reportState() []*model.Report {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var reports []*model.Report
+	for _, filed := range s.reports {
+		for _, report := range filed {
+			cp := *report
+			reports = append(reports, &cp)
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].CommentID < reports[j].CommentID
+	})
+	return reports
+}
+
+// bannedState returns the banned users in order.
+func (s *InMemoryStorage) bannedState() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	banned := make([]string, 0, len(s.banned))
+	for user := range s.banned {
+		banned = append(banned, user)
+	}
+	sort.Strings(banned)
+	return banned
+}
+
+// spamState returns the spam model.
+func (s *InMemoryStorage) spamState() *model.SpamModel {
+	m, _ := s.GetSpamModel(context.Background())
+	return m
+}
+
+// restore rebuilds the state saved in snap by the functions above. The
+// comments already have the counts of the votes.
+func (s *InMemoryStorage) restore(snap *snapshot) {
+	for _, post := range snap.Posts {
+		s.restorePost(post)
+		for _, comment := range post.Comments {
+			s.restoreComment(comment)
+		}
+	}
+	for _, reaction := range snap.Reactions {
+		s.restoreReaction(reaction, true)
+	}
+	for _, notification := range snap.Notifications {
+		s.restoreNotification(notification)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, vote := range snap.Votes {
+		s.setVote(vote)
+	}
+	for _, report := range snap.Reports {
+		s.addReport(report)
+	}
+	for _, user := range snap.Banned {
+		s.banned[user] = true
+	}
+	if snap.Spam != nil {
+		s.spam = *snap.Spam
+		s.spam.Tokens = maps.Clone(snap.Spam.Tokens)
+		if s.spam.Tokens == nil {
+			s.spam.Tokens = map[string]model.TokenCounts{}
+		}
+	}
+}
+
+func (s *InMemoryStorage) restorePost(post *model.Post) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addPost(post)
+	s.lastPostID = max(s.lastPostID, post.ID)
+}
+
+func (s *InMemoryStorage) restoreComment(comment *model.Comment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addComment(comment)
+	s.lastCommentID = max(s.lastCommentID, comment.ID)
+}
+
+func (s *InMemoryStorage) restorePostUpdate(id int, update PostUpdate, at time.Time) {
+	_, _ = s.updatePost(id, update, at)
+}
+
+func (s *InMemoryStorage) restoreCommentsDisabled(postID int, disabled bool, at time.Time) {
+	_, _ = s.setCommentsDisabled(postID, disabled, at)
+}
+
+func (s *InMemoryStorage) restoreReaction(reaction *model.Reaction, added bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if added {
+		s.addReaction(reaction)
+	} else {
+		s.removeReaction(reaction)
+	}
+}
+
+func (s *InMemoryStorage) restoreNotification(notification *model.Notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addNotification(notification)
+	s.lastNotificationID = max(s.lastNotificationID, notification.ID)
+}
+
+func (s *InMemoryStorage) restoreNotificationsRead(user string, ids []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markNotificationsRead(user, ids)
+}
+
+func (s *InMemoryStorage) restoreReport(report *model.Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.comments[report.CommentID]; ok {
+		s.addReport(report)
+	}
+}
+
+func (s *InMemoryStorage) restoreReportsResolved(commentID int, action model.ModerationAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.comments[commentID]; ok {
+		s.resolveReports(commentID, action)
+	}
+}
+
+func (s *InMemoryStorage) restoreSpam(tokens []string, spam bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trainSpam(tokens, spam)
+}
+
+func (s *InMemoryStorage) restoreVote(vote *model.Vote) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.comments[vote.CommentID]; ok {
+		s.applyVote(vote)
 	}
-	return nil, errors.New("post not found")
 }
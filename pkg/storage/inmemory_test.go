@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"post-comments/pkg/model"
+)
+
+func TestInMemoryReadsAreCopies(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryStorage()
+	post := &model.Post{Title: "title", Body: "body"}
+	require.NoError(t, s.CreatePost(ctx, post))
+	require.NoError(t, s.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "first"}))
+
+	post.Title = "changed by the caller"
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	got.Comments[0].Body = "changed"
+	got.Comments = nil
+	posts, err := s.GetPosts(ctx)
+	require.NoError(t, err)
+	posts[0].CommentsDisabled = true
+	page, err := s.GetComments(ctx, CommentQuery{PostID: post.ID, Order: model.CommentOrderNewest})
+	require.NoError(t, err)
+	page[0].Upvotes = 10
+
+	got, err = s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "title", got.Title)
+	assert.False(t, got.CommentsDisabled)
+	require.Len(t, got.Comments, 1)
+	assert.Equal(t, "first", got.Comments[0].Body)
+	assert.Zero(t, got.Comments[0].Upvotes)
+}
+
+func TestInMemoryIndices(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryStorage()
+	first := &model.Post{Title: "first"}
+	second := &model.Post{Title: "second"}
+	require.NoError(t, s.CreatePost(ctx, first))
+	require.NoError(t, s.CreatePost(ctx, second))
+	assert.Equal(t, []int{1, 2}, []int{first.ID, second.ID})
+
+	root := &model.Comment{PostID: second.ID, Body: "root"}
+	require.NoError(t, s.CreateComment(ctx, root))
+	reply := &model.Comment{PostID: second.ID, ParentID: &root.ID, Body: "reply"}
+	require.NoError(t, s.CreateComment(ctx, reply))
+	assert.Greater(t, reply.ID, root.ID)
+
+	replies, err := s.Replies(ctx, root.ID)
+	require.NoError(t, err)
+	require.Len(t, replies, 1)
+	assert.Equal(t, reply.ID, replies[0].ID)
+
+	// a parent must belong to the same post
+	assert.Error(t, s.CreateComment(ctx, &model.Comment{PostID: first.ID, ParentID: &root.ID}))
+	missing := 42
+	assert.Error(t, s.CreateComment(ctx, &model.Comment{PostID: second.ID, ParentID: &missing}))
+	_, err = s.GetPost(ctx, 42)
+	assert.Error(t, err)
+}
+
+func BenchmarkInMemoryGetPost(b *testing.B) {
+	ctx := context.Background()
+	s := NewInMemoryStorage()
+	for i := 0; i < 1000; i++ {
+		post := &model.Post{Title: "title"}
+		_ = s.CreatePost(ctx, post)
+		for j := 0; j < 10; j++ {
+			_ = s.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "body"})
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = s.GetPost(ctx, i%1000+1)
+	}
+}
@@ -2,127 +2,436 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/jmoiron/sqlx"
 	"post-comments/pkg/model"
+	"strings"
 	"time"
 )
 
+const postgresNotificationColumns = `
+   id,
+   user_name AS "user",
+   kind,
+   post_id AS PostID,
+   comment_id AS CommentID,
+   actor,
+   created_at AS createdAt,
+   read`
+
+const postgresReportColumns = `
+   comment_id AS CommentID,
+   reporter,
+   reason,
+   created_at AS createdAt`
+
+const postgresPostColumns = `
+   id,
+   title,
+   body,
+   author,
+   filter_profile AS filterProfile,
+   comments_disabled AS commentsDisabled,
+   created_at AS createdAt,
+   updated_at AS updatedAt`
+
+const postgresCommentColumns = `
+   id,
+   post_id AS PostID,
+   parent_id AS ParentID,
+   body,
+   author,
+   hidden,
+   upvotes,
+   downvotes,
+   created_at AS createdAt,
+   updated_at AS updatedAt`
+
 type PostgresStorage struct {
-	db *sqlx.DB
+	db     *sqlx.DB
+	router *router
 }
 
 func NewPostgresStorage(db *sqlx.DB) *PostgresStorage {
-	return &PostgresStorage{db: db}
+	return NewReplicatedPostgresStorage(db, nil, ReplicaConfig{})
+}
+
+// NewReplicatedPostgresStorage writes to primary and reads from replicas,
+// see ReplicaConfig for read-your-writes.
+func NewReplicatedPostgresStorage(primary *sqlx.DB, replicas []*sqlx.DB, cfg ReplicaConfig) *PostgresStorage {
+	return &PostgresStorage{db: primary, router: newRouter(primary, replicas, cfg)}
 }
 
 func (s *PostgresStorage) CreatePost(ctx context.Context, post *model.Post) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	post.CreatedAt = time.Now().UTC()
-	post.UpdatedAt = time.Now().UTC()
-	err := s.db.QueryRowContext(ctx, "INSERT INTO posts (title, body, created_at, updated_at) VALUES ($1, $2, $3, $4) RETURNING id", post.Title, post.Body, post.CreatedAt, post.UpdatedAt).Scan(&post.ID)
+	post.UpdatedAt = post.CreatedAt
+	err = tx.QueryRowContext(ctx, "INSERT INTO posts (title, body, author, filter_profile, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id", post.Title, post.Body, post.Author, post.FilterProfile, post.CreatedAt, post.UpdatedAt).Scan(&post.ID)
+	if err != nil {
+		return err
+	}
+	if len(post.Tags) > 0 {
+		if err := setPostgresPostTags(ctx, tx, post.ID, post.Tags); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.router.wrote(ctx)
+	return nil
+}
+
+// setPostgresPostTags replaces the tags of a post, adding the new ones to
+// the tags table.
+func setPostgresPostTags(ctx context.Context, tx *sqlx.Tx, postID int, tags []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM post_tags WHERE post_id = $1", postID); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING", tags); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO post_tags (post_id, tag_id) SELECT $1, id FROM tags WHERE name = ANY($2)", postID, tags)
 	return err
 }
 
 func (s *PostgresStorage) GetPosts(ctx context.Context) ([]*model.Post, error) {
 	var posts []*model.Post
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		var err error
+		posts, err = s.getPosts(ctx, db, "")
+		return err
+	})
+	return posts, err
+}
+
+func (s *PostgresStorage) GetPostsByTag(ctx context.Context, tag string) ([]*model.Post, error) {
+	var posts []*model.Post
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		var err error
+		posts, err = s.getPosts(ctx, db, `
+  WHERE id IN (
+    SELECT pt.post_id
+    FROM post_tags pt
+    JOIN tags t ON t.id = pt.tag_id
+    WHERE t.name = $1
+  )`, tag)
+		return err
+	})
+	return posts, err
+}
+
+// getPosts returns the posts matching where, which may be empty, in
+// creation order.
+func (s *PostgresStorage) getPosts(ctx context.Context, db *sqlx.DB, where string, args ...any) ([]*model.Post, error) {
+	var posts []*model.Post
 
 	query := `
-  SELECT 
-   id, 
-   title, 
-   body, 
-   comments_disabled AS commentsDisabled, 
-   created_at AS createdAt, 
-   updated_at AS updatedAt 
-  FROM posts`
-	err := s.db.SelectContext(ctx, &posts, query)
+  SELECT` + postgresPostColumns + `
+  FROM posts` + where + `
+  ORDER BY id`
+	err := db.SelectContext(ctx, &posts, query, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, post := range posts {
-		comments, err := s.getCommentsForPost(ctx, post.ID)
+		comments, err := s.getCommentsForPost(ctx, db, post.ID)
 		if err != nil {
 			return nil, err
 		}
 		post.Comments = comments
 	}
+	if err := s.loadTags(ctx, db, posts); err != nil {
+		return nil, err
+	}
 
 	return posts, nil
 }
 
 func (s *PostgresStorage) GetPost(ctx context.Context, id int) (*model.Post, error) {
+	var post *model.Post
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		var err error
+		post, err = s.getPost(ctx, db, id)
+		return err
+	})
+	return post, err
+}
+
+func (s *PostgresStorage) getPost(ctx context.Context, db *sqlx.DB, id int) (*model.Post, error) {
 	post := &model.Post{}
 
 	query := `
-  SELECT 
-   id, 
-   title, 
-   body, 
-   comments_disabled AS commentsDisabled, 
-   created_at AS createdAt, 
-   updated_at AS updatedAt 
+  SELECT` + postgresPostColumns + `
   FROM posts 
   WHERE id=$1`
-	err := s.db.GetContext(ctx, post, query, id)
-	if err != nil {
+	err := db.GetContext(ctx, post, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, errors.New("post not found")
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	comments, err := s.getCommentsForPost(ctx, post.ID)
+	comments, err := s.getCommentsForPost(ctx, db, post.ID)
 	if err != nil {
 		return nil, err
 	}
 	post.Comments = comments
+	if err := s.loadTags(ctx, db, []*model.Post{post}); err != nil {
+		return nil, err
+	}
 
 	return post, nil
 }
 
-func (s *PostgresStorage) getCommentsForPost(ctx context.Context, postID int) ([]*model.Comment, error) {
+func (s *PostgresStorage) GetPostHeader(ctx context.Context, id int) (*model.Post, error) {
+	post := &model.Post{}
+	query := `
+  SELECT` + postgresPostColumns + `
+  FROM posts
+  WHERE id=$1`
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		return db.GetContext(ctx, post, query, id)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("post not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+type tagRow struct {
+	PostID int    `db:"post_id"`
+	Tag    string `db:"name"`
+}
+
+// setTags sets the Tags of posts from rows ordered by name.
+func setTags(posts []*model.Post, rows []tagRow) {
+	byPost := make(map[int][]string)
+	for _, row := range rows {
+		byPost[row.PostID] = append(byPost[row.PostID], row.Tag)
+	}
+	for _, post := range posts {
+		post.Tags = byPost[post.ID]
+	}
+}
+
+// loadTags sets the Tags of posts.
+func (s *PostgresStorage) loadTags(ctx context.Context, db sqlx.QueryerContext, posts []*model.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	var tags []tagRow
+	query := `
+  SELECT pt.post_id, t.name
+  FROM post_tags pt
+  JOIN tags t ON t.id = pt.tag_id
+  WHERE pt.post_id = ANY($1)
+  ORDER BY pt.post_id, t.name`
+	if err := sqlx.SelectContext(ctx, db, &tags, query, ids); err != nil {
+		return err
+	}
+	setTags(posts, tags)
+	return nil
+}
+
+// UpdatePost reads the post back from the primary once updated, so that
+// it comes with its comments and tags.
+func (s *PostgresStorage) UpdatePost(ctx context.Context, id int, update PostUpdate) (*model.Post, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+  UPDATE posts
+  SET title = COALESCE($2, title), body = COALESCE($3, body), updated_at = $4
+  WHERE id = $1`
+	res, err := tx.ExecContext(ctx, query, id, update.Title, update.Body, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("post not found")
+	}
+	if update.Tags != nil {
+		if err := setPostgresPostTags(ctx, tx, id, *update.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.router.wrote(ctx)
+	return s.getPost(ctx, s.db, id)
+}
+
+func (s *PostgresStorage) GetTags(ctx context.Context) ([]*model.TagCount, error) {
+	var tags []*model.TagCount
+	query := `
+  SELECT t.name AS tag, count(*) AS count
+  FROM tags t
+  JOIN post_tags pt ON pt.tag_id = t.id
+  GROUP BY t.name
+  ORDER BY count DESC, t.name`
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		return db.SelectContext(ctx, &tags, query)
+	})
+	return tags, err
+}
+
+func (s *PostgresStorage) getCommentsForPost(ctx context.Context, db *sqlx.DB, postID int) ([]*model.Comment, error) {
 	var comments []*model.Comment
 
 	query := `
-  SELECT 
-   id, 
-   post_id AS PostID,
-   parent_id AS ParentID,
-   body, 
-   created_at AS createdAt, 
-   updated_at AS updatedAt 
+  SELECT` + postgresCommentColumns + `
   FROM comments 
-  WHERE post_id=$1`
-	err := s.db.SelectContext(ctx, &comments, query, postID)
+  WHERE post_id=$1
+  ORDER BY id`
+	err := db.SelectContext(ctx, &comments, query, postID)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.loadMentions(ctx, db, comments); err != nil {
+		return nil, err
+	}
 
 	return comments, nil
 }
 
+type mentionRow struct {
+	CommentID int    `db:"comment_id"`
+	User      string `db:"user_name"`
+}
+
+// setMentions sets the Mentions of comments from rows ordered by position.
+func setMentions(comments []*model.Comment, rows []mentionRow) {
+	byComment := make(map[int][]string)
+	for _, row := range rows {
+		byComment[row.CommentID] = append(byComment[row.CommentID], row.User)
+	}
+	for _, comment := range comments {
+		comment.Mentions = byComment[comment.ID]
+	}
+}
+
+// loadMentions sets the Mentions of comments.
+func (s *PostgresStorage) loadMentions(ctx context.Context, db sqlx.QueryerContext, comments []*model.Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	ids := make([]int, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.ID
+	}
+	var mentions []mentionRow
+	query := `
+  SELECT comment_id, user_name
+  FROM comment_mentions
+  WHERE comment_id = ANY($1)
+  ORDER BY comment_id, position`
+	if err := sqlx.SelectContext(ctx, db, &mentions, query, ids); err != nil {
+		return err
+	}
+	setMentions(comments, mentions)
+	return nil
+}
+
+// CreateComment locks the post row while inserting, so a concurrent
+// DisableComments waits for the comment instead of slipping in between the
+// check and the insert.
 func (s *PostgresStorage) CreateComment(ctx context.Context, comment *model.Comment) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var disabled bool
+	err = tx.GetContext(ctx, &disabled, "SELECT comments_disabled FROM posts WHERE id=$1 FOR SHARE", comment.PostID)
+	if errors.Is(err, sql.ErrNoRows) || disabled {
+		return errors.New("post not found or comments disabled")
+	}
+	if err != nil {
+		return err
+	}
+	if comment.ParentID != nil {
+		var parentPostID int
+		err = tx.GetContext(ctx, &parentPostID, "SELECT post_id FROM comments WHERE id=$1", *comment.ParentID)
+		if errors.Is(err, sql.ErrNoRows) || parentPostID != comment.PostID {
+			return errors.New("parent comment not found")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
 	comment.CreatedAt = time.Now().UTC()
-	comment.UpdatedAt = time.Now().UTC()
-	err := s.db.QueryRowContext(ctx, "INSERT INTO comments (post_id, parent_id, body, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id", comment.PostID, comment.ParentID, comment.Body, comment.CreatedAt, comment.UpdatedAt).Scan(&comment.ID)
-	return err
+	comment.UpdatedAt = comment.CreatedAt
+	err = tx.QueryRowContext(ctx, "INSERT INTO comments (post_id, parent_id, body, author, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id", comment.PostID, comment.ParentID, comment.Body, comment.Author, comment.CreatedAt, comment.UpdatedAt).Scan(&comment.ID)
+	if err != nil {
+		return err
+	}
+	for i, user := range comment.Mentions {
+		_, err = tx.ExecContext(ctx, "INSERT INTO comment_mentions (comment_id, position, user_name) VALUES ($1, $2, $3)", comment.ID, i, user)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.router.wrote(ctx)
+	return nil
 }
+
 func (s *PostgresStorage) DisableComments(ctx context.Context, postID int) (*model.Post, error) {
 	post := &model.Post{}
 	query := `
   UPDATE posts 
   SET comments_disabled = true, updated_at = now() 
   WHERE id = $1 
-  RETURNING id, title, body, comments_disabled AS commentsDisabled, created_at AS createdAt, updated_at AS updatedAt`
+  RETURNING id, title, body, author, filter_profile AS filterProfile, comments_disabled AS commentsDisabled, created_at AS createdAt, updated_at AS updatedAt`
 	err := s.db.QueryRowContext(ctx, query, postID).Scan(
 		&post.ID,
 		&post.Title,
 		&post.Body,
+		&post.Author,
+		&post.FilterProfile,
 		&post.CommentsDisabled,
 		&post.CreatedAt,
 		&post.UpdatedAt,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("post not found")
+	}
 	if err != nil {
 		return nil, err
 	}
+	s.router.wrote(ctx)
 	return post, nil
 }
 
@@ -132,17 +441,680 @@ func (s *PostgresStorage) EnableComments(ctx context.Context, postID int) (*mode
   UPDATE posts 
   SET comments_disabled = false, updated_at = now() 
   WHERE id = $1 
-  RETURNING id, title, body, comments_disabled AS commentsDisabled, created_at AS createdAt, updated_at AS updatedAt`
+  RETURNING id, title, body, author, filter_profile AS filterProfile, comments_disabled AS commentsDisabled, created_at AS createdAt, updated_at AS updatedAt`
 	err := s.db.QueryRowContext(ctx, query, postID).Scan(
 		&post.ID,
 		&post.Title,
 		&post.Body,
+		&post.Author,
+		&post.FilterProfile,
 		&post.CommentsDisabled,
 		&post.CreatedAt,
 		&post.UpdatedAt,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("post not found")
+	}
 	if err != nil {
 		return nil, err
 	}
+	s.router.wrote(ctx)
 	return post, nil
 }
+
+func (s *PostgresStorage) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	query := `
+  INSERT INTO reactions (post_id, comment_id, user_name, emoji)
+  VALUES ($1, $2, $3, $4)
+  ON CONFLICT DO NOTHING`
+	return s.setReaction(ctx, reaction, query)
+}
+
+func (s *PostgresStorage) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	query := `
+  DELETE FROM reactions
+  WHERE post_id=$1 AND COALESCE(comment_id, 0)=COALESCE($2::int, 0) AND user_name=$3 AND emoji=$4`
+	return s.setReaction(ctx, reaction, query)
+}
+
+// setReaction runs query, which adds or removes reaction, and reports
+// whether it changed a row.
+func (s *PostgresStorage) setReaction(ctx context.Context, reaction *model.Reaction, query string) (bool, error) {
+	if err := s.checkTarget(ctx, reaction.PostID, reaction.CommentID); err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, query, reaction.PostID, reaction.CommentID, reaction.User, reaction.Emoji)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		s.router.wrote(ctx)
+	}
+	return n > 0, nil
+}
+
+// checkTarget returns an error unless the post and comment exist.
+func (s *PostgresStorage) checkTarget(ctx context.Context, postID int, commentID *int) error {
+	if commentID == nil {
+		var exists bool
+		err := s.db.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM posts WHERE id=$1)", postID)
+		if err == nil && !exists {
+			return errors.New("post not found")
+		}
+		return err
+	}
+	var commentPostID int
+	err := s.db.GetContext(ctx, &commentPostID, "SELECT post_id FROM comments WHERE id=$1", *commentID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && commentPostID != postID {
+		return errors.New("comment not found")
+	}
+	return err
+}
+
+// Emojis are sorted by their bytes, whatever the database collation.
+func (s *PostgresStorage) GetReactionCounts(ctx context.Context, postID int, commentID *int) ([]*model.ReactionCount, error) {
+	query := `
+  SELECT emoji, COUNT(*) AS count
+  FROM reactions
+  WHERE post_id=$1 AND COALESCE(comment_id, 0)=COALESCE($2::int, 0)
+  GROUP BY emoji
+  ORDER BY emoji COLLATE "C"`
+	var counts []*model.ReactionCount
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		counts = []*model.ReactionCount{}
+		return db.SelectContext(ctx, &counts, query, postID, commentID)
+	})
+	return counts, err
+}
+
+func (s *PostgresStorage) GetUserReactions(ctx context.Context, postID int, commentID *int, user string) ([]string, error) {
+	query := `
+  SELECT emoji
+  FROM reactions
+  WHERE post_id=$1 AND COALESCE(comment_id, 0)=COALESCE($2::int, 0) AND user_name=$3
+  ORDER BY emoji COLLATE "C"`
+	var emojis []string
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		emojis = []string{}
+		return db.SelectContext(ctx, &emojis, query, postID, commentID, user)
+	})
+	return emojis, err
+}
+
+func (s *PostgresStorage) Vote(ctx context.Context, vote *model.Vote) (*model.Comment, error) {
+	if err := checkVote(vote); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// locking the comment serializes the votes on it
+	var id int
+	err = tx.GetContext(ctx, &id, "SELECT id FROM comments WHERE id=$1 FOR UPDATE", vote.CommentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("comment not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var old int
+	err = tx.GetContext(ctx, &old, "SELECT value FROM comment_votes WHERE comment_id=$1 AND user_name=$2", vote.CommentID, vote.User)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if vote.Value == 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM comment_votes WHERE comment_id=$1 AND user_name=$2", vote.CommentID, vote.User)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+  INSERT INTO comment_votes (comment_id, user_name, value)
+  VALUES ($1, $2, $3)
+  ON CONFLICT (comment_id, user_name) DO UPDATE SET value = EXCLUDED.value`, vote.CommentID, vote.User, vote.Value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	up, down := voteDelta(old, vote.Value)
+	comment := &model.Comment{}
+	query := "UPDATE comments SET upvotes = upvotes + $2, downvotes = downvotes + $3 WHERE id=$1 RETURNING" + postgresCommentColumns
+	if err := tx.GetContext(ctx, comment, query, vote.CommentID, up, down); err != nil {
+		return nil, err
+	}
+	if err := s.loadMentions(ctx, tx, []*model.Comment{comment}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.router.wrote(ctx)
+	return comment, nil
+}
+
+func (s *PostgresStorage) GetUserVote(ctx context.Context, commentID int, user string) (int, error) {
+	query := `
+  SELECT COALESCE(v.value, 0)
+  FROM comments c
+  LEFT JOIN comment_votes v ON v.comment_id = c.id AND v.user_name=$2
+  WHERE c.id=$1`
+	var value int
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		return db.GetContext(ctx, &value, query, commentID, user)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("comment not found")
+	}
+	return value, err
+}
+
+func (s *PostgresStorage) GetComment(ctx context.Context, id int) (*model.Comment, error) {
+	comment := &model.Comment{}
+	query := `
+  SELECT` + postgresCommentColumns + `
+  FROM comments
+  WHERE id=$1`
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		if err := db.GetContext(ctx, comment, query, id); err != nil {
+			return err
+		}
+		return s.loadMentions(ctx, db, []*model.Comment{comment})
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("comment not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// GetCommentDepth counts the comment and its ancestors, walking up the
+// parents in the database.
+func (s *PostgresStorage) GetCommentDepth(ctx context.Context, id int) (int, error) {
+	query := `
+  WITH RECURSIVE ancestors AS (
+    SELECT id, parent_id FROM comments WHERE id=$1
+    UNION ALL
+    SELECT c.id, c.parent_id
+    FROM comments c
+    JOIN ancestors a ON c.id = a.parent_id
+  )
+  SELECT count(*) FROM ancestors`
+	var depth int
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		return db.GetContext(ctx, &depth, query, id)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if depth == 0 {
+		return 0, errors.New("comment not found")
+	}
+	return depth, nil
+}
+
+// postgresCommentKey is the SQL form of ranking.Config.Key, decay being the
+// placeholder of the HOT decay in seconds. The expressions match the ones
+// indexed for keyset pagination.
+func postgresCommentKey(order model.CommentOrder, decay string) string {
+	switch order {
+	case model.CommentOrderTop:
+		return "(upvotes - downvotes)"
+	case model.CommentOrderHot:
+		return `(sign(upvotes - downvotes)::float8 * log(greatest(abs(upvotes - downvotes), 1)::float8)
+    + extract(epoch FROM created_at)::float8 / ` + decay + `)`
+	case model.CommentOrderControversial:
+		return `(CASE WHEN upvotes > 0 AND downvotes > 0
+    THEN power((upvotes + downvotes)::float8, least(upvotes, downvotes)::float8 / greatest(upvotes, downvotes))
+    ELSE 0 END)`
+	case model.CommentOrderNewest:
+		return "id"
+	}
+	return "-id"
+}
+
+// postgresIntegerKey reports whether the key of order is an integer
+// expression, which must be compared as one to match its index.
+func postgresIntegerKey(order model.CommentOrder) bool {
+	switch order {
+	case model.CommentOrderHot, model.CommentOrderControversial:
+		return false
+	}
+	return true
+}
+
+// commentKey returns the sort key of q.After, an int64 or a float64 as told
+// by postgresIntegerKey.
+func commentKey(ctx context.Context, db *sqlx.DB, q CommentQuery) (any, error) {
+	args := []any{q.PostID, *q.After}
+	decay := ""
+	if q.Order == model.CommentOrderHot {
+		args = append(args, q.Ranking.Decay().Seconds())
+		decay = "$3"
+	}
+	query := "SELECT " + postgresCommentKey(q.Order, decay) + " FROM comments WHERE post_id=$1 AND parent_id IS NULL AND id=$2"
+
+	var key any
+	var err error
+	if postgresIntegerKey(q.Order) {
+		var k int64
+		err = db.GetContext(ctx, &k, query, args...)
+		key = k
+	} else {
+		var k float64
+		err = db.GetContext(ctx, &k, query, args...)
+		key = k
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("comment not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// postgresRootsQuery selects a page of the top-level comments of q.PostID
+// following afterKey, the key of q.After. The page is read in index order:
+// "key <= k" bounds the scan of the index of the order and only the ties at
+// k are filtered on the id, see TestPostgresCommentPagesUseIndexes.
+func postgresRootsQuery(q CommentQuery, afterKey any) (string, []any) {
+	args := []any{q.PostID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	decay := ""
+	if q.Order == model.CommentOrderHot {
+		decay = arg(q.Ranking.Decay().Seconds())
+	}
+	key := postgresCommentKey(q.Order, decay)
+
+	query := "SELECT" + postgresCommentColumns + "\n  FROM comments\n  WHERE post_id=$1 AND parent_id IS NULL"
+	if q.After != nil {
+		after := arg(*q.After)
+		if q.Order == model.CommentOrderOldest {
+			query += " AND id > " + after
+		} else {
+			k := arg(afterKey)
+			query += fmt.Sprintf(" AND %s <= %s AND (%s < %s OR id > %s)", key, k, key, k, after)
+		}
+	}
+	if q.Order == model.CommentOrderOldest {
+		query += "\n  ORDER BY id"
+	} else {
+		query += "\n  ORDER BY " + key + " DESC, id"
+	}
+	if q.Limit > 0 {
+		query += "\n  LIMIT " + arg(q.Limit)
+	}
+	return query, args
+}
+
+func (s *PostgresStorage) GetComments(ctx context.Context, q CommentQuery) ([]*model.Comment, error) {
+	var comments []*model.Comment
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		var err error
+		comments, err = s.getComments(ctx, db, q)
+		return err
+	})
+	return comments, err
+}
+
+// getComments pages through the top-level comments with a keyset query,
+// then loads their replies and lays them out with q.Ranking.
+func (s *PostgresStorage) getComments(ctx context.Context, db *sqlx.DB, q CommentQuery) ([]*model.Comment, error) {
+	var exists bool
+	if err := db.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM posts WHERE id=$1)", q.PostID); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("post not found")
+	}
+
+	var afterKey any
+	if q.After != nil {
+		var err error
+		afterKey, err = commentKey(ctx, db, q)
+		if err != nil {
+			return nil, err
+		}
+	}
+	query, args := postgresRootsQuery(q, afterKey)
+
+	var roots []*model.Comment
+	if err := db.SelectContext(ctx, &roots, query, args...); err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return []*model.Comment{}, nil
+	}
+
+	ids := make([]int, len(roots))
+	for i, root := range roots {
+		ids[i] = root.ID
+	}
+	var replies []*model.Comment
+	repliesQuery := `
+  WITH RECURSIVE thread AS (
+    SELECT` + postgresCommentColumns + `
+    FROM comments
+    WHERE parent_id = ANY($1)
+    UNION ALL
+    SELECT` + strings.ReplaceAll(postgresCommentColumns, "\n   ", "\n   c.") + `
+    FROM comments c
+    JOIN thread t ON c.parent_id = t.id
+  )
+  SELECT * FROM thread`
+	if err := db.SelectContext(ctx, &replies, repliesQuery, ids); err != nil {
+		return nil, err
+	}
+	comments := q.Ranking.Threads(roots, replies, q.Order)
+	if err := s.loadMentions(ctx, db, comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (s *PostgresStorage) ResolveUsers(ctx context.Context, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return []string{}, nil
+	}
+	lower := make([]string, len(names))
+	for i, name := range names {
+		lower[i] = strings.ToLower(name)
+	}
+	query := `
+  SELECT author FROM posts WHERE lower(author) = ANY($1)
+  UNION
+  SELECT author FROM comments WHERE lower(author) = ANY($1)`
+	var known []string
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		return db.SelectContext(ctx, &known, query, lower)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resolveUsers(names, known), nil
+}
+
+func (s *PostgresStorage) CreateNotification(ctx context.Context, notification *model.Notification) error {
+	if !notification.Kind.IsValid() {
+		return fmt.Errorf("invalid notification kind %q", notification.Kind)
+	}
+	if err := s.checkTarget(ctx, notification.PostID, notification.CommentID); err != nil {
+		return err
+	}
+	notification.CreatedAt = time.Now().UTC()
+	query := `
+  INSERT INTO notifications (user_name, kind, post_id, comment_id, actor, created_at, read)
+  VALUES ($1, $2, $3, $4, $5, $6, $7)
+  RETURNING id`
+	err := s.db.QueryRowContext(ctx, query, notification.User, notification.Kind, notification.PostID, notification.CommentID, notification.Actor, notification.CreatedAt, notification.Read).Scan(&notification.ID)
+	if err == nil {
+		s.router.wrote(ctx)
+	}
+	return err
+}
+
+func (s *PostgresStorage) GetNotifications(ctx context.Context, q NotificationQuery) ([]*model.Notification, error) {
+	args := []any{q.User}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	query := "SELECT" + postgresNotificationColumns + "\n  FROM notifications\n  WHERE user_name=$1"
+	if q.After != nil {
+		query += " AND id < " + arg(*q.After)
+	}
+	if q.UnreadOnly {
+		query += " AND NOT read"
+	}
+	query += "\n  ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += "\n  LIMIT " + arg(q.Limit)
+	}
+	var notifications []*model.Notification
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		notifications = []*model.Notification{}
+		return db.SelectContext(ctx, &notifications, query, args...)
+	})
+	return notifications, err
+}
+
+func (s *PostgresStorage) MarkNotificationsRead(ctx context.Context, user string, ids []int) (int, error) {
+	query := "UPDATE notifications SET read = true WHERE user_name=$1 AND NOT read"
+	args := []any{user}
+	if ids != nil {
+		query += " AND id = ANY($2)"
+		args = append(args, ids)
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		s.router.wrote(ctx)
+	}
+	return int(n), nil
+}
+
+func (s *PostgresStorage) ReportComment(ctx context.Context, report *model.Report) error {
+	var hidden bool
+	err := s.db.GetContext(ctx, &hidden, "SELECT hidden FROM comments WHERE id=$1", report.CommentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("comment not found")
+	}
+	if err != nil {
+		return err
+	}
+	if hidden {
+		return errors.New("comment is hidden")
+	}
+	query := `
+  INSERT INTO comment_reports (comment_id, reporter, reason, created_at)
+  VALUES ($1, $2, $3, $4)
+  ON CONFLICT (comment_id, reporter) DO UPDATE SET reason = EXCLUDED.reason
+  RETURNING created_at`
+	err = s.db.QueryRowContext(ctx, query, report.CommentID, report.Reporter, report.Reason, time.Now().UTC()).Scan(&report.CreatedAt)
+	if err != nil {
+		return err
+	}
+	report.CreatedAt = report.CreatedAt.UTC()
+	s.router.wrote(ctx)
+	return nil
+}
+
+// moderationQueue pairs the comments with IDs ids, in that order, with
+// their reports, skipping the comments missing from comments.
+func moderationQueue(ids []int, comments []*model.Comment, reports []*model.Report) []*model.ReportedComment {
+	byID := make(map[int]*model.ReportedComment, len(comments))
+	for _, comment := range comments {
+		byID[comment.ID] = &model.ReportedComment{Comment: comment}
+	}
+	for _, report := range reports {
+		if item, ok := byID[report.CommentID]; ok {
+			item.Reports = append(item.Reports, report)
+		}
+	}
+	queue := make([]*model.ReportedComment, 0, len(ids))
+	for _, id := range ids {
+		if item, ok := byID[id]; ok {
+			queue = append(queue, item)
+		}
+	}
+	return queue
+}
+
+func (s *PostgresStorage) GetModerationQueue(ctx context.Context, limit int) ([]*model.ReportedComment, error) {
+	query := `
+  SELECT comment_id
+  FROM comment_reports
+  GROUP BY comment_id
+  ORDER BY count(*) DESC, min(created_at), comment_id`
+	var args []any
+	if limit > 0 {
+		query += "\n  LIMIT $1"
+		args = append(args, limit)
+	}
+	var queue []*model.ReportedComment
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		var ids []int
+		if err := db.SelectContext(ctx, &ids, query, args...); err != nil {
+			return err
+		}
+		var comments []*model.Comment
+		err := db.SelectContext(ctx, &comments, "SELECT"+postgresCommentColumns+"\n  FROM comments\n  WHERE id = ANY($1)", ids)
+		if err != nil {
+			return err
+		}
+		if err := s.loadMentions(ctx, db, comments); err != nil {
+			return err
+		}
+		var reports []*model.Report
+		err = db.SelectContext(ctx, &reports, "SELECT"+postgresReportColumns+"\n  FROM comment_reports\n  WHERE comment_id = ANY($1)\n  ORDER BY created_at, reporter", ids)
+		if err != nil {
+			return err
+		}
+		queue = moderationQueue(ids, comments, reports)
+		return nil
+	})
+	return queue, err
+}
+
+func (s *PostgresStorage) ResolveReports(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, int, error) {
+	if !action.IsValid() {
+		return nil, 0, fmt.Errorf("invalid moderation action %q", action)
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	comment := &model.Comment{}
+	query := "UPDATE comments SET hidden = hidden OR $2 WHERE id=$1 RETURNING" + postgresCommentColumns
+	err = tx.GetContext(ctx, comment, query, commentID, action != model.ModerationActionDismiss)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, errors.New("comment not found")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM comment_reports WHERE comment_id=$1", commentID)
+	if err != nil {
+		return nil, 0, err
+	}
+	closed, err := res.RowsAffected()
+	if err != nil {
+		return nil, 0, err
+	}
+	if action == model.ModerationActionBan && comment.Author != "" {
+		_, err = tx.ExecContext(ctx, "INSERT INTO banned_users (user_name, created_at) VALUES ($1, $2) ON CONFLICT DO NOTHING", comment.Author, time.Now().UTC())
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := s.loadMentions(ctx, tx, []*model.Comment{comment}); err != nil {
+		return nil, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	s.router.wrote(ctx)
+	return comment, int(closed), nil
+}
+
+func (s *PostgresStorage) IsBanned(ctx context.Context, user string) (bool, error) {
+	var banned bool
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		return db.GetContext(ctx, &banned, "SELECT EXISTS (SELECT 1 FROM banned_users WHERE user_name=$1)", user)
+	})
+	return banned, err
+}
+
+func (s *PostgresStorage) TrainSpam(ctx context.Context, tokens []string, spam bool) error {
+	ham := 1
+	if spam {
+		ham = 0
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+  INSERT INTO spam_classes (spam, documents) VALUES ($1, 1)
+  ON CONFLICT (spam) DO UPDATE SET documents = spam_classes.documents + 1`, spam)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+  INSERT INTO spam_tokens (token, spam, ham)
+  SELECT token, $2, $3 FROM unnest($1::text[]) AS token
+  ON CONFLICT (token) DO UPDATE SET spam = spam_tokens.spam + EXCLUDED.spam, ham = spam_tokens.ham + EXCLUDED.ham`, tokens, 1-ham, ham)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.router.wrote(ctx)
+	return nil
+}
+
+type spamClassRow struct {
+	Spam      bool `db:"spam"`
+	Documents int  `db:"documents"`
+}
+
+type spamTokenRow struct {
+	Token string `db:"token"`
+	Spam  int    `db:"spam"`
+	Ham   int    `db:"ham"`
+}
+
+// spamModel builds a model.SpamModel from its rows.
+func spamModel(classes []spamClassRow, tokens []spamTokenRow) *model.SpamModel {
+	m := &model.SpamModel{Tokens: make(map[string]model.TokenCounts, len(tokens))}
+	for _, class := range classes {
+		if class.Spam {
+			m.Spam = class.Documents
+		} else {
+			m.Ham = class.Documents
+		}
+	}
+	for _, token := range tokens {
+		m.Tokens[token.Token] = model.TokenCounts{Spam: token.Spam, Ham: token.Ham}
+	}
+	return m
+}
+
+func (s *PostgresStorage) GetSpamModel(ctx context.Context) (*model.SpamModel, error) {
+	var m *model.SpamModel
+	err := s.router.read(ctx, func(db *sqlx.DB) error {
+		var classes []spamClassRow
+		if err := db.SelectContext(ctx, &classes, "SELECT spam, documents FROM spam_classes"); err != nil {
+			return err
+		}
+		var tokens []spamTokenRow
+		if err := db.SelectContext(ctx, &tokens, "SELECT token, spam, ham FROM spam_tokens"); err != nil {
+			return err
+		}
+		m = spamModel(classes, tokens)
+		return nil
+	})
+	return m, err
+}
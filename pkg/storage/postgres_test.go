@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"post-comments/pkg/database"
+	"post-comments/pkg/model"
+)
+
+// TestPostgresCommentPagesUseIndexes checks that the keyset queries of the
+// indexed orders are answered by an index range scan rather than by
+// filtering every top-level comment of the post.
+func TestPostgresCommentPagesUseIndexes(t *testing.T) {
+	dsn := os.Getenv("POSTCOMMENTS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTCOMMENTS_TEST_DSN is not set")
+	}
+	ctx := context.Background()
+	db, err := sqlx.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.Migrate(ctx, db))
+
+	conn, err := db.Connx(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+	// the test tables are small enough for a sequential scan to win
+	_, err = conn.ExecContext(ctx, "SET enable_seqscan = off")
+	require.NoError(t, err)
+
+	tests := []struct {
+		order model.CommentOrder
+		key   any
+		index string
+	}{
+		{model.CommentOrderTop, int64(3), "comments_roots_top"},
+		{model.CommentOrderControversial, 1.5, "comments_roots_controversial"},
+		{model.CommentOrderNewest, int64(7), "comments_roots_by_id"},
+		{model.CommentOrderOldest, int64(-7), "comments_roots_by_id"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.order), func(t *testing.T) {
+			after := 7
+			query, args := postgresRootsQuery(CommentQuery{PostID: 1, Order: tt.order, After: &after, Limit: 20}, tt.key)
+
+			var plan []string
+			require.NoError(t, conn.SelectContext(ctx, &plan, "EXPLAIN "+query, args...))
+
+			explained := strings.Join(plan, "\n")
+			assert.Contains(t, explained, tt.index)
+			assert.Contains(t, explained, "Index Cond")
+			assert.NotContains(t, explained, "Sort")
+		})
+	}
+}
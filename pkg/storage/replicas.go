@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ReplicaConfig struct {
+	// Pin is how long a session reads from the primary after a write.
+	Pin time.Duration
+	// Session identifies who made a request, e.g. the signed-in user. All
+	// requests share one session when it is nil.
+	Session func(ctx context.Context) string
+}
+
+// router sends reads to the replicas in turn, except for sessions that wrote
+// recently, which keep reading from the primary so they see their writes.
+type router struct {
+	primary  *sqlx.DB
+	replicas []*sqlx.DB
+	cfg      ReplicaConfig
+	next     atomic.Uint64
+
+	mu     sync.Mutex
+	writes map[string]time.Time
+	now    func() time.Time
+}
+
+func newRouter(primary *sqlx.DB, replicas []*sqlx.DB, cfg ReplicaConfig) *router {
+	return &router{
+		primary:  primary,
+		replicas: replicas,
+		cfg:      cfg,
+		writes:   map[string]time.Time{},
+		now:      time.Now,
+	}
+}
+
+func (r *router) session(ctx context.Context) string {
+	if r.cfg.Session == nil {
+		return ""
+	}
+	return r.cfg.Session(ctx)
+}
+
+// wrote records a write by the session in ctx.
+func (r *router) wrote(ctx context.Context) {
+	if len(r.replicas) == 0 || r.cfg.Pin <= 0 {
+		return
+	}
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for session, at := range r.writes {
+		if now.Sub(at) >= r.cfg.Pin {
+			delete(r.writes, session)
+		}
+	}
+	r.writes[r.session(ctx)] = now
+}
+
+// reader picks the database to read from.
+func (r *router) reader(ctx context.Context) *sqlx.DB {
+	if len(r.replicas) == 0 {
+		return r.primary
+	}
+	if r.cfg.Pin > 0 {
+		r.mu.Lock()
+		at, ok := r.writes[r.session(ctx)]
+		r.mu.Unlock()
+		if ok && r.now().Sub(at) < r.cfg.Pin {
+			return r.primary
+		}
+	}
+	return r.replicas[r.next.Add(1)%uint64(len(r.replicas))]
+}
+
+// read runs fn against a replica, falling back to the primary when the
+// replica fails. Not found errors are retried too, as the replica may lag.
+func (r *router) read(ctx context.Context, fn func(db *sqlx.DB) error) error {
+	db := r.reader(ctx)
+	err := fn(db)
+	if err != nil && db != r.primary && ctx.Err() == nil {
+		slog.WarnContext(ctx, "replica read failed, retrying on primary", slog.String("error", err.Error()))
+		err = fn(r.primary)
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+type sessionKey struct{}
+
+func withSession(session string) context.Context {
+	return context.WithValue(context.Background(), sessionKey{}, session)
+}
+
+func TestRouterPinsWritingSession(t *testing.T) {
+	primary, replica := &sqlx.DB{}, &sqlx.DB{}
+	r := newRouter(primary, []*sqlx.DB{replica}, ReplicaConfig{
+		Pin: 5 * time.Second,
+		Session: func(ctx context.Context) string {
+			session, _ := ctx.Value(sessionKey{}).(string)
+			return session
+		},
+	})
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	writer, other := withSession("a"), withSession("b")
+	assert.Same(t, replica, r.reader(writer))
+
+	r.wrote(writer)
+	assert.Same(t, primary, r.reader(writer))
+	assert.Same(t, replica, r.reader(other))
+
+	now = now.Add(5 * time.Second)
+	assert.Same(t, replica, r.reader(writer))
+}
+
+func TestRouterFallsBackToPrimary(t *testing.T) {
+	primary, replica := &sqlx.DB{}, &sqlx.DB{}
+	r := newRouter(primary, []*sqlx.DB{replica}, ReplicaConfig{})
+
+	var used []*sqlx.DB
+	err := r.read(context.Background(), func(db *sqlx.DB) error {
+		used = append(used, db)
+		if db == replica {
+			return assert.AnError
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*sqlx.DB{replica, primary}, used)
+}
+
+func TestRouterWithoutReplicas(t *testing.T) {
+	primary := &sqlx.DB{}
+	r := newRouter(primary, nil, ReplicaConfig{Pin: time.Second})
+
+	r.wrote(context.Background())
+	assert.Same(t, primary, r.reader(context.Background()))
+	assert.Empty(t, r.writes)
+}
@@ -0,0 +1,741 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"post-comments/pkg/model"
+	"strings"
+	"time"
+)
+
+// Unlike Postgres, SQLite keeps the case of aliases, so they are written
+// the way sqlx maps struct fields.
+const sqlitePostColumns = `
+   id,
+   title,
+   body,
+   author,
+   filter_profile AS filterprofile,
+   comments_disabled AS commentsdisabled,
+   created_at AS createdat,
+   updated_at AS updatedat`
+
+const sqliteCommentColumns = `
+   id,
+   post_id AS postid,
+   parent_id AS parentid,
+   body,
+   author,
+   hidden,
+   upvotes,
+   downvotes,
+   created_at AS createdat,
+   updated_at AS updatedat`
+
+const sqliteNotificationColumns = `
+   id,
+   user_name AS user,
+   kind,
+   post_id AS postid,
+   comment_id AS commentid,
+   actor,
+   created_at AS createdat,
+   read`
+
+const sqliteReportColumns = `
+   comment_id AS commentid,
+   reporter,
+   reason,
+   created_at AS createdat`
+
+type SQLiteStorage struct {
+	db *sqlx.DB
+}
+
+func NewSQLiteStorage(db *sqlx.DB) *SQLiteStorage {
+	return &SQLiteStorage{db: db}
+}
+
+func (s *SQLiteStorage) CreatePost(ctx context.Context, post *model.Post) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	post.CreatedAt = time.Now().UTC()
+	post.UpdatedAt = post.CreatedAt
+	err = tx.QueryRowContext(ctx, "INSERT INTO posts (title, body, author, filter_profile, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id", post.Title, post.Body, post.Author, post.FilterProfile, post.CreatedAt, post.UpdatedAt).Scan(&post.ID)
+	if err != nil {
+		return err
+	}
+	if len(post.Tags) > 0 {
+		if err := setSQLitePostTags(ctx, tx, post.ID, post.Tags); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// setSQLitePostTags replaces the tags of a post, adding the new ones to the
+// tags table.
+func setSQLitePostTags(ctx context.Context, tx *sqlx.Tx, postID int, tags []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM post_tags WHERE post_id = ?", postID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO tags (name) VALUES (?) ON CONFLICT (name) DO NOTHING", tag); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO post_tags (post_id, tag_id) SELECT ?, id FROM tags WHERE name = ?", postID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetPosts(ctx context.Context) ([]*model.Post, error) {
+	return s.getPosts(ctx, "")
+}
+
+func (s *SQLiteStorage) GetPostsByTag(ctx context.Context, tag string) ([]*model.Post, error) {
+	return s.getPosts(ctx, `
+  WHERE id IN (
+    SELECT pt.post_id
+    FROM post_tags pt
+    JOIN tags t ON t.id = pt.tag_id
+    WHERE t.name = ?
+  )`, tag)
+}
+
+// getPosts returns the posts matching where, which may be empty, in
+// creation order.
+func (s *SQLiteStorage) getPosts(ctx context.Context, where string, args ...any) ([]*model.Post, error) {
+	var posts []*model.Post
+	err := s.db.SelectContext(ctx, &posts, "SELECT "+sqlitePostColumns+" FROM posts"+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, post := range posts {
+		comments, err := s.getCommentsForPost(ctx, post.ID)
+		if err != nil {
+			return nil, err
+		}
+		post.Comments = comments
+	}
+	if err := s.loadTags(ctx, posts); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+func (s *SQLiteStorage) GetPost(ctx context.Context, id int) (*model.Post, error) {
+	post := &model.Post{}
+	err := s.db.GetContext(ctx, post, "SELECT "+sqlitePostColumns+" FROM posts WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("post not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := s.getCommentsForPost(ctx, post.ID)
+	if err != nil {
+		return nil, err
+	}
+	post.Comments = comments
+	if err := s.loadTags(ctx, []*model.Post{post}); err != nil {
+		return nil, err
+	}
+
+	return post, nil
+}
+
+func (s *SQLiteStorage) GetPostHeader(ctx context.Context, id int) (*model.Post, error) {
+	post := &model.Post{}
+	err := s.db.GetContext(ctx, post, "SELECT "+sqlitePostColumns+" FROM posts WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("post not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// loadTags sets the Tags of posts.
+func (s *SQLiteStorage) loadTags(ctx context.Context, posts []*model.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]any, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	in := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	var tags []tagRow
+	query := `
+  SELECT pt.post_id, t.name
+  FROM post_tags pt
+  JOIN tags t ON t.id = pt.tag_id
+  WHERE pt.post_id IN ` + in + `
+  ORDER BY pt.post_id, t.name`
+	if err := s.db.SelectContext(ctx, &tags, query, ids...); err != nil {
+		return err
+	}
+	setTags(posts, tags)
+	return nil
+}
+
+func (s *SQLiteStorage) UpdatePost(ctx context.Context, id int, update PostUpdate) (*model.Post, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+  UPDATE posts
+  SET title = COALESCE(?, title), body = COALESCE(?, body), updated_at = ?
+  WHERE id = ?`
+	res, err := tx.ExecContext(ctx, query, update.Title, update.Body, time.Now().UTC(), id)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("post not found")
+	}
+	if update.Tags != nil {
+		if err := setSQLitePostTags(ctx, tx, id, *update.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetPost(ctx, id)
+}
+
+func (s *SQLiteStorage) GetTags(ctx context.Context) ([]*model.TagCount, error) {
+	var tags []*model.TagCount
+	query := `
+  SELECT t.name AS tag, count(*) AS count
+  FROM tags t
+  JOIN post_tags pt ON pt.tag_id = t.id
+  GROUP BY t.name
+  ORDER BY count DESC, t.name`
+	err := s.db.SelectContext(ctx, &tags, query)
+	return tags, err
+}
+
+func (s *SQLiteStorage) getCommentsForPost(ctx context.Context, postID int) ([]*model.Comment, error) {
+	var comments []*model.Comment
+
+	query := `
+  SELECT` + sqliteCommentColumns + `
+  FROM comments
+  WHERE post_id = ?
+  ORDER BY id`
+	err := s.db.SelectContext(ctx, &comments, query, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	var mentions []mentionRow
+	query = `
+  SELECT m.comment_id, m.user_name
+  FROM comment_mentions m
+  JOIN comments c ON c.id = m.comment_id
+  WHERE c.post_id = ?
+  ORDER BY m.comment_id, m.position`
+	if err := s.db.SelectContext(ctx, &mentions, query, postID); err != nil {
+		return nil, err
+	}
+	setMentions(comments, mentions)
+
+	return comments, nil
+}
+
+// CreateComment checks that the post accepts comments in the same
+// transaction as the insert, so a concurrent DisableComments can't slip in
+// between.
+func (s *SQLiteStorage) CreateComment(ctx context.Context, comment *model.Comment) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var disabled bool
+	err = tx.GetContext(ctx, &disabled, "SELECT comments_disabled FROM posts WHERE id = ?", comment.PostID)
+	if errors.Is(err, sql.ErrNoRows) || disabled {
+		return errors.New("post not found or comments disabled")
+	}
+	if err != nil {
+		return err
+	}
+	if comment.ParentID != nil {
+		var parentPostID int
+		err = tx.GetContext(ctx, &parentPostID, "SELECT post_id FROM comments WHERE id = ?", *comment.ParentID)
+		if errors.Is(err, sql.ErrNoRows) || parentPostID != comment.PostID {
+			return errors.New("parent comment not found")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	comment.CreatedAt = time.Now().UTC()
+	comment.UpdatedAt = comment.CreatedAt
+	err = tx.QueryRowContext(ctx, "INSERT INTO comments (post_id, parent_id, body, author, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id", comment.PostID, comment.ParentID, comment.Body, comment.Author, comment.CreatedAt, comment.UpdatedAt).Scan(&comment.ID)
+	if err != nil {
+		return err
+	}
+	for i, user := range comment.Mentions {
+		_, err = tx.ExecContext(ctx, "INSERT INTO comment_mentions (comment_id, position, user_name) VALUES (?, ?, ?)", comment.ID, i, user)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) DisableComments(ctx context.Context, postID int) (*model.Post, error) {
+	return s.setCommentsDisabled(ctx, postID, true)
+}
+
+func (s *SQLiteStorage) EnableComments(ctx context.Context, postID int) (*model.Post, error) {
+	return s.setCommentsDisabled(ctx, postID, false)
+}
+
+func (s *SQLiteStorage) setCommentsDisabled(ctx context.Context, postID int, disabled bool) (*model.Post, error) {
+	post := &model.Post{}
+	query := "UPDATE posts SET comments_disabled = ?, updated_at = ? WHERE id = ? RETURNING" + sqlitePostColumns
+	err := s.db.GetContext(ctx, post, query, disabled, time.Now().UTC(), postID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("post not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func (s *SQLiteStorage) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	query := `
+  INSERT INTO reactions (post_id, comment_id, user_name, emoji)
+  VALUES (?, ?, ?, ?)
+  ON CONFLICT DO NOTHING`
+	return s.setReaction(ctx, reaction, query)
+}
+
+func (s *SQLiteStorage) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	query := `
+  DELETE FROM reactions
+  WHERE post_id = ? AND COALESCE(comment_id, 0) = COALESCE(?, 0) AND user_name = ? AND emoji = ?`
+	return s.setReaction(ctx, reaction, query)
+}
+
+// setReaction runs query, which adds or removes reaction, and reports
+// whether it changed a row.
+func (s *SQLiteStorage) setReaction(ctx context.Context, reaction *model.Reaction, query string) (bool, error) {
+	if err := s.checkTarget(ctx, reaction.PostID, reaction.CommentID); err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, query, reaction.PostID, reaction.CommentID, reaction.User, reaction.Emoji)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// checkTarget returns an error unless the post and comment exist.
+func (s *SQLiteStorage) checkTarget(ctx context.Context, postID int, commentID *int) error {
+	if commentID == nil {
+		var exists bool
+		err := s.db.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM posts WHERE id = ?)", postID)
+		if err == nil && !exists {
+			return errors.New("post not found")
+		}
+		return err
+	}
+	var commentPostID int
+	err := s.db.GetContext(ctx, &commentPostID, "SELECT post_id FROM comments WHERE id = ?", *commentID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && commentPostID != postID {
+		return errors.New("comment not found")
+	}
+	return err
+}
+
+func (s *SQLiteStorage) GetReactionCounts(ctx context.Context, postID int, commentID *int) ([]*model.ReactionCount, error) {
+	query := `
+  SELECT emoji, COUNT(*) AS count
+  FROM reactions
+  WHERE post_id = ? AND COALESCE(comment_id, 0) = COALESCE(?, 0)
+  GROUP BY emoji
+  ORDER BY emoji`
+	counts := []*model.ReactionCount{}
+	err := s.db.SelectContext(ctx, &counts, query, postID, commentID)
+	return counts, err
+}
+
+func (s *SQLiteStorage) GetUserReactions(ctx context.Context, postID int, commentID *int, user string) ([]string, error) {
+	query := `
+  SELECT emoji
+  FROM reactions
+  WHERE post_id = ? AND COALESCE(comment_id, 0) = COALESCE(?, 0) AND user_name = ?
+  ORDER BY emoji`
+	emojis := []string{}
+	err := s.db.SelectContext(ctx, &emojis, query, postID, commentID, user)
+	return emojis, err
+}
+
+func (s *SQLiteStorage) Vote(ctx context.Context, vote *model.Vote) (*model.Comment, error) {
+	if err := checkVote(vote); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var old int
+	err = tx.GetContext(ctx, &old, "SELECT value FROM comment_votes WHERE comment_id = ? AND user_name = ?", vote.CommentID, vote.User)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	up, down := voteDelta(old, vote.Value)
+	comment := &model.Comment{}
+	query := `
+  UPDATE comments SET upvotes = upvotes + ?, downvotes = downvotes + ?
+  WHERE id = ?
+  RETURNING` + sqliteCommentColumns
+	err = tx.GetContext(ctx, comment, query, up, down, vote.CommentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("comment not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mentions []mentionRow
+	err = tx.SelectContext(ctx, &mentions, "SELECT comment_id, user_name FROM comment_mentions WHERE comment_id = ? ORDER BY position", comment.ID)
+	if err != nil {
+		return nil, err
+	}
+	setMentions([]*model.Comment{comment}, mentions)
+
+	if vote.Value == 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM comment_votes WHERE comment_id = ? AND user_name = ?", vote.CommentID, vote.User)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+  INSERT INTO comment_votes (comment_id, user_name, value)
+  VALUES (?, ?, ?)
+  ON CONFLICT (comment_id, user_name) DO UPDATE SET value = excluded.value`, vote.CommentID, vote.User, vote.Value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return comment, tx.Commit()
+}
+
+func (s *SQLiteStorage) GetUserVote(ctx context.Context, commentID int, user string) (int, error) {
+	query := `
+  SELECT COALESCE(v.value, 0)
+  FROM comments c
+  LEFT JOIN comment_votes v ON v.comment_id = c.id AND v.user_name = ?
+  WHERE c.id = ?`
+	var value int
+	err := s.db.GetContext(ctx, &value, query, user, commentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("comment not found")
+	}
+	return value, err
+}
+
+// GetComments sorts the comments of the post in memory, they are all loaded
+// with the post anyway.
+func (s *SQLiteStorage) GetComment(ctx context.Context, id int) (*model.Comment, error) {
+	comment := &model.Comment{}
+	err := s.db.GetContext(ctx, comment, "SELECT "+sqliteCommentColumns+" FROM comments WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("comment not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mentions []mentionRow
+	err = s.db.SelectContext(ctx, &mentions, "SELECT comment_id, user_name FROM comment_mentions WHERE comment_id = ? ORDER BY position", id)
+	if err != nil {
+		return nil, err
+	}
+	setMentions([]*model.Comment{comment}, mentions)
+	return comment, nil
+}
+
+// GetCommentDepth counts the comment and its ancestors, walking up the
+// parents in the database.
+func (s *SQLiteStorage) GetCommentDepth(ctx context.Context, id int) (int, error) {
+	query := `
+  WITH RECURSIVE ancestors AS (
+    SELECT id, parent_id FROM comments WHERE id = ?
+    UNION ALL
+    SELECT c.id, c.parent_id
+    FROM comments c
+    JOIN ancestors a ON c.id = a.parent_id
+  )
+  SELECT count(*) FROM ancestors`
+	var depth int
+	if err := s.db.GetContext(ctx, &depth, query, id); err != nil {
+		return 0, err
+	}
+	if depth == 0 {
+		return 0, errors.New("comment not found")
+	}
+	return depth, nil
+}
+
+func (s *SQLiteStorage) GetComments(ctx context.Context, q CommentQuery) ([]*model.Comment, error) {
+	post, err := s.GetPost(ctx, q.PostID)
+	if err != nil {
+		return nil, err
+	}
+	return q.Ranking.Page(post.Comments, q.Order, q.After, q.Limit)
+}
+
+func (s *SQLiteStorage) ResolveUsers(ctx context.Context, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return []string{}, nil
+	}
+	lower := make([]any, len(names))
+	for i, name := range names {
+		lower[i] = strings.ToLower(name)
+	}
+	in := "(?" + strings.Repeat(", ?", len(names)-1) + ")"
+	query := `
+  SELECT author FROM posts WHERE lower(author) IN ` + in + `
+  UNION
+  SELECT author FROM comments WHERE lower(author) IN ` + in
+	var known []string
+	if err := s.db.SelectContext(ctx, &known, query, append(lower, lower...)...); err != nil {
+		return nil, err
+	}
+	return resolveUsers(names, known), nil
+}
+
+func (s *SQLiteStorage) CreateNotification(ctx context.Context, notification *model.Notification) error {
+	if !notification.Kind.IsValid() {
+		return fmt.Errorf("invalid notification kind %q", notification.Kind)
+	}
+	if err := s.checkTarget(ctx, notification.PostID, notification.CommentID); err != nil {
+		return err
+	}
+	notification.CreatedAt = time.Now().UTC()
+	query := `
+  INSERT INTO notifications (user_name, kind, post_id, comment_id, actor, created_at, read)
+  VALUES (?, ?, ?, ?, ?, ?, ?)
+  RETURNING id`
+	return s.db.QueryRowContext(ctx, query, notification.User, notification.Kind, notification.PostID, notification.CommentID, notification.Actor, notification.CreatedAt, notification.Read).Scan(&notification.ID)
+}
+
+func (s *SQLiteStorage) GetNotifications(ctx context.Context, q NotificationQuery) ([]*model.Notification, error) {
+	query := "SELECT" + sqliteNotificationColumns + "\n  FROM notifications\n  WHERE user_name = ?"
+	args := []any{q.User}
+	if q.After != nil {
+		query += " AND id < ?"
+		args = append(args, *q.After)
+	}
+	if q.UnreadOnly {
+		query += " AND NOT read"
+	}
+	query += "\n  ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += "\n  LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	notifications := []*model.Notification{}
+	err := s.db.SelectContext(ctx, &notifications, query, args...)
+	return notifications, err
+}
+
+func (s *SQLiteStorage) MarkNotificationsRead(ctx context.Context, user string, ids []int) (int, error) {
+	query := "UPDATE notifications SET read = TRUE WHERE user_name = ? AND NOT read"
+	args := []any{user}
+	if ids != nil {
+		if len(ids) == 0 {
+			return 0, nil
+		}
+		query += " AND id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLiteStorage) ReportComment(ctx context.Context, report *model.Report) error {
+	var hidden bool
+	err := s.db.GetContext(ctx, &hidden, "SELECT hidden FROM comments WHERE id = ?", report.CommentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("comment not found")
+	}
+	if err != nil {
+		return err
+	}
+	if hidden {
+		return errors.New("comment is hidden")
+	}
+	query := `
+  INSERT INTO comment_reports (comment_id, reporter, reason, created_at)
+  VALUES (?, ?, ?, ?)
+  ON CONFLICT (comment_id, reporter) DO UPDATE SET reason = excluded.reason
+  RETURNING created_at`
+	return s.db.QueryRowContext(ctx, query, report.CommentID, report.Reporter, report.Reason, time.Now().UTC()).Scan(&report.CreatedAt)
+}
+
+func (s *SQLiteStorage) GetModerationQueue(ctx context.Context, limit int) ([]*model.ReportedComment, error) {
+	query := `
+  SELECT comment_id
+  FROM comment_reports
+  GROUP BY comment_id
+  ORDER BY count(*) DESC, min(created_at), comment_id`
+	var args []any
+	if limit > 0 {
+		query += "\n  LIMIT ?"
+		args = append(args, limit)
+	}
+	var ids []int
+	if err := s.db.SelectContext(ctx, &ids, query, args...); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*model.ReportedComment{}, nil
+	}
+	in := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	args = make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	var comments []*model.Comment
+	if err := s.db.SelectContext(ctx, &comments, "SELECT"+sqliteCommentColumns+"\n  FROM comments\n  WHERE id IN "+in, args...); err != nil {
+		return nil, err
+	}
+	var mentions []mentionRow
+	query = "SELECT comment_id, user_name FROM comment_mentions WHERE comment_id IN " + in + " ORDER BY comment_id, position"
+	if err := s.db.SelectContext(ctx, &mentions, query, args...); err != nil {
+		return nil, err
+	}
+	setMentions(comments, mentions)
+	var reports []*model.Report
+	query = "SELECT" + sqliteReportColumns + "\n  FROM comment_reports\n  WHERE comment_id IN " + in + "\n  ORDER BY created_at, reporter"
+	if err := s.db.SelectContext(ctx, &reports, query, args...); err != nil {
+		return nil, err
+	}
+	return moderationQueue(ids, comments, reports), nil
+}
+
+func (s *SQLiteStorage) ResolveReports(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, int, error) {
+	if !action.IsValid() {
+		return nil, 0, fmt.Errorf("invalid moderation action %q", action)
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	comment := &model.Comment{}
+	query := "UPDATE comments SET hidden = hidden OR ? WHERE id = ? RETURNING" + sqliteCommentColumns
+	err = tx.GetContext(ctx, comment, query, action != model.ModerationActionDismiss, commentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, errors.New("comment not found")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var mentions []mentionRow
+	err = tx.SelectContext(ctx, &mentions, "SELECT comment_id, user_name FROM comment_mentions WHERE comment_id = ? ORDER BY position", commentID)
+	if err != nil {
+		return nil, 0, err
+	}
+	setMentions([]*model.Comment{comment}, mentions)
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM comment_reports WHERE comment_id = ?", commentID)
+	if err != nil {
+		return nil, 0, err
+	}
+	closed, err := res.RowsAffected()
+	if err != nil {
+		return nil, 0, err
+	}
+	if action == model.ModerationActionBan && comment.Author != "" {
+		_, err = tx.ExecContext(ctx, "INSERT INTO banned_users (user_name, created_at) VALUES (?, ?) ON CONFLICT DO NOTHING", comment.Author, time.Now().UTC())
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return comment, int(closed), tx.Commit()
+}
+
+func (s *SQLiteStorage) IsBanned(ctx context.Context, user string) (bool, error) {
+	var banned bool
+	err := s.db.GetContext(ctx, &banned, "SELECT EXISTS (SELECT 1 FROM banned_users WHERE user_name = ?)", user)
+	return banned, err
+}
+
+func (s *SQLiteStorage) TrainSpam(ctx context.Context, tokens []string, spam bool) error {
+	ham := 1
+	if spam {
+		ham = 0
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+  INSERT INTO spam_classes (spam, documents) VALUES (?, 1)
+  ON CONFLICT (spam) DO UPDATE SET documents = documents + 1`, spam)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		_, err = tx.ExecContext(ctx, `
+  INSERT INTO spam_tokens (token, spam, ham) VALUES (?, ?, ?)
+  ON CONFLICT (token) DO UPDATE SET spam = spam + excluded.spam, ham = ham + excluded.ham`, token, 1-ham, ham)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) GetSpamModel(ctx context.Context) (*model.SpamModel, error) {
+	var classes []spamClassRow
+	if err := s.db.SelectContext(ctx, &classes, "SELECT spam, documents FROM spam_classes"); err != nil {
+		return nil, err
+	}
+	var tokens []spamTokenRow
+	if err := s.db.SelectContext(ctx, &tokens, "SELECT token, spam, ham FROM spam_tokens"); err != nil {
+		return nil, err
+	}
+	return spamModel(classes, tokens), nil
+}
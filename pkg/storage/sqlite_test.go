@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"post-comments/pkg/database"
+	"post-comments/pkg/model"
+)
+
+func newSQLiteStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	ctx := context.Background()
+	db, err := database.NewSQLiteDB(ctx, database.SQLiteConfig{
+		Path:        filepath.Join(t.TempDir(), "test.db"),
+		BusyTimeout: time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.Migrate(ctx, db))
+	require.NoError(t, database.CheckMigrations(ctx, db))
+	return NewSQLiteStorage(db)
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStorage(t)
+
+	post := &model.Post{Title: "title", Body: "body"}
+	require.NoError(t, s.CreatePost(ctx, post))
+	assert.Equal(t, 1, post.ID)
+
+	comment := &model.Comment{PostID: post.ID, Body: "first"}
+	require.NoError(t, s.CreateComment(ctx, comment))
+	reply := &model.Comment{PostID: post.ID, ParentID: &comment.ID, Body: "reply"}
+	require.NoError(t, s.CreateComment(ctx, reply))
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "title", got.Title)
+	require.Len(t, got.Comments, 2)
+	assert.Equal(t, comment.ID, *got.Comments[1].ParentID)
+	assert.WithinDuration(t, post.CreatedAt, got.CreatedAt, time.Second)
+
+	posts, err := s.GetPosts(ctx)
+	require.NoError(t, err)
+	assert.Len(t, posts, 1)
+
+	_, err = s.GetPost(ctx, 42)
+	assert.EqualError(t, err, "post not found")
+}
+
+func TestSQLiteStorageCommentsDisabled(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStorage(t)
+
+	post := &model.Post{Title: "title", Body: "body"}
+	require.NoError(t, s.CreatePost(ctx, post))
+
+	disabled, err := s.DisableComments(ctx, post.ID)
+	require.NoError(t, err)
+	assert.True(t, disabled.CommentsDisabled)
+	assert.Error(t, s.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "no"}))
+
+	enabled, err := s.EnableComments(ctx, post.ID)
+	require.NoError(t, err)
+	assert.False(t, enabled.CommentsDisabled)
+	assert.NoError(t, s.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "yes"}))
+
+	assert.Error(t, s.CreateComment(ctx, &model.Comment{PostID: 42, Body: "missing"}))
+	_, err = s.DisableComments(ctx, 42)
+	assert.Error(t, err)
+}
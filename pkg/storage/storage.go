@@ -3,13 +3,99 @@ package storage
 import (
 	"context"
 	"post-comments/pkg/model"
+	"post-comments/pkg/ranking"
 )
 
+// CommentQuery selects a page of a post's comment threads: the top-level
+// comments sorted by Order, each followed by its replies sorted the same way.
+type CommentQuery struct {
+	PostID int
+	Order  model.CommentOrder
+	// After is the last top-level comment of the previous page.
+	After *int
+	// Limit caps the number of top-level comments, 0 meaning no limit.
+	Limit   int
+	Ranking ranking.Config
+}
+
+// NotificationQuery selects a page of a user's notifications, newest first.
+type NotificationQuery struct {
+	User string
+	// After is the last notification of the previous page.
+	After *int
+	// Limit caps the number of notifications, 0 meaning no limit.
+	Limit      int
+	UnreadOnly bool
+}
+
+// PostUpdate changes the fields of a post that are set, Tags replacing
+// all the tags of the post.
+type PostUpdate struct {
+	Title *string   `json:"title,omitempty"`
+	Body  *string   `json:"body,omitempty"`
+	Tags  *[]string `json:"tags,omitempty"`
+}
+
 type Storage interface {
 	CreatePost(ctx context.Context, post *model.Post) error
 	GetPosts(ctx context.Context) ([]*model.Post, error)
 	GetPost(ctx context.Context, id int) (*model.Post, error)
+	// GetPostHeader returns a post without its comments and tags, for the
+	// callers needing only the post's own fields.
+	GetPostHeader(ctx context.Context, id int) (*model.Post, error)
+	// UpdatePost applies update to a post and returns it as it is afterwards.
+	UpdatePost(ctx context.Context, id int, update PostUpdate) (*model.Post, error)
+	// GetPostsByTag returns the posts tagged with tag, oldest first.
+	GetPostsByTag(ctx context.Context, tag string) ([]*model.Post, error)
+	// GetTags returns the tags of at least one post with how many posts
+	// have them, the most used first, then by name.
+	GetTags(ctx context.Context) ([]*model.TagCount, error)
 	CreateComment(ctx context.Context, comment *model.Comment) error
 	DisableComments(ctx context.Context, postID int) (*model.Post, error)
 	EnableComments(ctx context.Context, postID int) (*model.Post, error)
+	// AddReaction and RemoveReaction report whether the reaction was added
+	// or removed, a user having at most one reaction of each emoji.
+	AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error)
+	RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error)
+	// GetReactionCounts returns the reactions to a post, or to one of its
+	// comments when commentID is set, counted by emoji in emoji order.
+	GetReactionCounts(ctx context.Context, postID int, commentID *int) ([]*model.ReactionCount, error)
+	// GetUserReactions returns the emojis user reacted with, in emoji order.
+	GetUserReactions(ctx context.Context, postID int, commentID *int, user string) ([]string, error)
+	// Vote replaces the user's vote on a comment, 0 taking it back, and
+	// returns the comment with its new counts.
+	Vote(ctx context.Context, vote *model.Vote) (*model.Comment, error)
+	// GetUserVote returns the user's vote on a comment, 0 if there is none.
+	GetUserVote(ctx context.Context, commentID int, user string) (int, error)
+	GetComments(ctx context.Context, q CommentQuery) ([]*model.Comment, error)
+	GetComment(ctx context.Context, id int) (*model.Comment, error)
+	// GetCommentDepth returns how deep a comment is nested, top-level
+	// comments being at depth 1.
+	GetCommentDepth(ctx context.Context, id int) (int, error)
+	// ResolveUsers returns the known users, those who wrote a post or a
+	// comment, whose names match names regardless of case, in the order of
+	// names. Names of unknown users are left out.
+	ResolveUsers(ctx context.Context, names []string) ([]string, error)
+	CreateNotification(ctx context.Context, notification *model.Notification) error
+	GetNotifications(ctx context.Context, q NotificationQuery) ([]*model.Notification, error)
+	// MarkNotificationsRead marks the user's notifications with the given
+	// IDs read, or all of them when ids is nil, and returns how many were
+	// unread. IDs of other users' notifications are ignored.
+	MarkNotificationsRead(ctx context.Context, user string, ids []int) (int, error)
+	// ReportComment files the user's report of a comment, replacing the
+	// reason of an earlier one. Hidden comments can't be reported.
+	ReportComment(ctx context.Context, report *model.Report) error
+	// GetModerationQueue returns up to limit reported comments, 0 meaning
+	// no limit, the most reported first, then the first reported.
+	GetModerationQueue(ctx context.Context, limit int) ([]*model.ReportedComment, error)
+	// ResolveReports closes the reports of a comment, hiding it unless
+	// action is DISMISS and banning its author, if not anonymous, when it is
+	// BAN. It returns the comment as it is afterwards and how many reports
+	// were closed.
+	ResolveReports(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, int, error)
+	IsBanned(ctx context.Context, user string) (bool, error)
+	// TrainSpam adds a comment made of tokens, each appearing once, to the
+	// spam model as spam or not.
+	TrainSpam(ctx context.Context, tokens []string, spam bool) error
+	GetSpamModel(ctx context.Context) (*model.SpamModel, error)
 }
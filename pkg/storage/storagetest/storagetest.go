@@ -0,0 +1,695 @@
+// Package storagetest holds the behavior every storage.Storage must share,
+// so that the server works the same whichever backend it is configured with.
+package storagetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"post-comments/pkg/model"
+	"post-comments/pkg/storage"
+)
+
+// Run runs the suite, calling newStorage for an empty storage in each
+// subtest.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{"Ordering", testOrdering},
+		{"NotFound", testNotFound},
+		{"CommentsDisabled", testCommentsDisabled},
+		{"Parents", testParents},
+		{"Reactions", testReactions},
+		{"Votes", testVotes},
+		{"CommentOrder", testCommentOrder},
+		{"Mentions", testMentions},
+		{"Notifications", testNotifications},
+		{"Moderation", testModeration},
+		{"SpamModel", testSpamModel},
+		{"Tags", testTags},
+		{"Concurrency", testConcurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func createPost(t *testing.T, s storage.Storage, title string) *model.Post {
+	t.Helper()
+	post := &model.Post{Title: title, Body: "body"}
+	require.NoError(t, s.CreatePost(context.Background(), post))
+	require.NotZero(t, post.ID)
+	return post
+}
+
+func createComment(t *testing.T, s storage.Storage, postID int, parentID *int, body string) *model.Comment {
+	t.Helper()
+	comment := &model.Comment{PostID: postID, ParentID: parentID, Body: body}
+	require.NoError(t, s.CreateComment(context.Background(), comment))
+	require.NotZero(t, comment.ID)
+	return comment
+}
+
+func testOrdering(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	first := createPost(t, s, "first")
+	second := createPost(t, s, "second")
+	assert.Greater(t, second.ID, first.ID)
+	assert.False(t, first.CreatedAt.IsZero())
+	assert.Equal(t, first.CreatedAt, first.UpdatedAt)
+
+	a := createComment(t, s, second.ID, nil, "a")
+	b := createComment(t, s, second.ID, nil, "b")
+	c := createComment(t, s, second.ID, nil, "c")
+
+	posts, err := s.GetPosts(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	assert.Equal(t, []string{"first", "second"}, []string{posts[0].Title, posts[1].Title})
+	assert.Empty(t, posts[0].Comments)
+
+	got, err := s.GetPost(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "second", got.Title)
+	assert.Equal(t, "body", got.Body)
+	require.Len(t, got.Comments, 3)
+	for i, want := range []*model.Comment{a, b, c} {
+		assert.Equal(t, want.ID, got.Comments[i].ID)
+		assert.Equal(t, want.Body, got.Comments[i].Body)
+		assert.Equal(t, second.ID, got.Comments[i].PostID)
+		assert.Nil(t, got.Comments[i].ParentID)
+	}
+}
+
+func testNotFound(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	const missing = 4242
+
+	_, err := s.GetPost(ctx, missing)
+	assert.Error(t, err)
+	_, err = s.GetPostHeader(ctx, missing)
+	assert.Error(t, err)
+	_, err = s.GetComment(ctx, missing)
+	assert.Error(t, err)
+	_, err = s.GetCommentDepth(ctx, missing)
+	assert.Error(t, err)
+	assert.Error(t, s.CreateComment(ctx, &model.Comment{PostID: missing, Body: "body"}))
+	_, err = s.DisableComments(ctx, missing)
+	assert.Error(t, err)
+	_, err = s.EnableComments(ctx, missing)
+	assert.Error(t, err)
+
+	posts, err := s.GetPosts(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, posts)
+}
+
+func testCommentsDisabled(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	post := &model.Post{Title: "title", Body: "body", FilterProfile: "strict"}
+	require.NoError(t, s.CreatePost(ctx, post))
+
+	disabled, err := s.DisableComments(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, post.ID, disabled.ID)
+	assert.Equal(t, "strict", disabled.FilterProfile)
+	assert.True(t, disabled.CommentsDisabled)
+	assert.False(t, disabled.UpdatedAt.Before(post.CreatedAt))
+	assert.Error(t, s.CreateComment(ctx, &model.Comment{PostID: post.ID, Body: "rejected"}))
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.True(t, got.CommentsDisabled)
+	assert.Empty(t, got.Comments)
+	assert.Equal(t, "strict", got.FilterProfile)
+
+	header, err := s.GetPostHeader(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "title", header.Title)
+	assert.True(t, header.CommentsDisabled)
+	assert.Equal(t, "strict", header.FilterProfile)
+
+	enabled, err := s.EnableComments(ctx, post.ID)
+	require.NoError(t, err)
+	assert.False(t, enabled.CommentsDisabled)
+	createComment(t, s, post.ID, nil, "accepted")
+
+	got, err = s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.False(t, got.CommentsDisabled)
+	assert.Len(t, got.Comments, 1)
+}
+
+func testParents(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	post := createPost(t, s, "title")
+	other := createPost(t, s, "other")
+
+	root := createComment(t, s, post.ID, nil, "root")
+	reply := createComment(t, s, post.ID, &root.ID, "reply")
+	nested := createComment(t, s, post.ID, &reply.ID, "nested")
+
+	for want, comment := range []*model.Comment{root, reply, nested} {
+		depth, err := s.GetCommentDepth(ctx, comment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, want+1, depth, comment.Body)
+	}
+
+	missing := 4242
+	assert.Error(t, s.CreateComment(ctx, &model.Comment{PostID: post.ID, ParentID: &missing, Body: "orphan"}))
+	assert.Error(t, s.CreateComment(ctx, &model.Comment{PostID: other.ID, ParentID: &root.ID, Body: "elsewhere"}))
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Comments, 3)
+	assert.Nil(t, got.Comments[0].ParentID)
+	require.NotNil(t, got.Comments[1].ParentID)
+	assert.Equal(t, root.ID, *got.Comments[1].ParentID)
+	require.NotNil(t, got.Comments[2].ParentID)
+	assert.Equal(t, reply.ID, *got.Comments[2].ParentID)
+
+	got, err = s.GetPost(ctx, other.ID)
+	require.NoError(t, err)
+	assert.Empty(t, got.Comments)
+
+	comment, err := s.GetComment(ctx, reply.ID)
+	require.NoError(t, err)
+	assert.Equal(t, post.ID, comment.PostID)
+	assert.Equal(t, "reply", comment.Body)
+	require.NotNil(t, comment.ParentID)
+	assert.Equal(t, root.ID, *comment.ParentID)
+}
+
+func testReactions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	post := createPost(t, s, "title")
+	other := createPost(t, s, "other")
+	comment := createComment(t, s, post.ID, nil, "comment")
+
+	react := func(add bool, commentID *int, user, emoji string) bool {
+		t.Helper()
+		reaction := &model.Reaction{PostID: post.ID, CommentID: commentID, User: user, Emoji: emoji}
+		var changed bool
+		var err error
+		if add {
+			changed, err = s.AddReaction(ctx, reaction)
+		} else {
+			changed, err = s.RemoveReaction(ctx, reaction)
+		}
+		require.NoError(t, err)
+		return changed
+	}
+	assert.True(t, react(true, nil, "alice", "👍"))
+	assert.False(t, react(true, nil, "alice", "👍"), "one reaction of each emoji per user")
+	assert.True(t, react(true, nil, "alice", "❤️"))
+	assert.True(t, react(true, nil, "bob", "👍"))
+	assert.True(t, react(true, &comment.ID, "alice", "👎"))
+
+	counts, err := s.GetReactionCounts(ctx, post.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*model.ReactionCount{{Emoji: "❤️", Count: 1}, {Emoji: "👍", Count: 2}}, counts)
+	counts, err = s.GetReactionCounts(ctx, post.ID, &comment.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []*model.ReactionCount{{Emoji: "👎", Count: 1}}, counts)
+	emojis, err := s.GetUserReactions(ctx, post.ID, nil, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"❤️", "👍"}, emojis)
+
+	assert.True(t, react(false, nil, "alice", "👍"))
+	assert.False(t, react(false, nil, "alice", "👍"))
+	counts, err = s.GetReactionCounts(ctx, post.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*model.ReactionCount{{Emoji: "❤️", Count: 1}, {Emoji: "👍", Count: 1}}, counts)
+	emojis, err = s.GetUserReactions(ctx, post.ID, &comment.ID, "bob")
+	require.NoError(t, err)
+	assert.Empty(t, emojis)
+	counts, err = s.GetReactionCounts(ctx, other.ID, nil)
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+
+	_, err = s.AddReaction(ctx, &model.Reaction{PostID: 4242, User: "alice", Emoji: "👍"})
+	assert.Error(t, err)
+	_, err = s.AddReaction(ctx, &model.Reaction{PostID: other.ID, CommentID: &comment.ID, User: "alice", Emoji: "👍"})
+	assert.Error(t, err, "the comment belongs to another post")
+}
+
+func testVotes(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	post := createPost(t, s, "title")
+	comment := createComment(t, s, post.ID, nil, "comment")
+
+	vote := func(user string, value int) *model.Comment {
+		t.Helper()
+		voted, err := s.Vote(ctx, &model.Vote{CommentID: comment.ID, User: user, Value: value})
+		require.NoError(t, err)
+		assert.Equal(t, comment.ID, voted.ID)
+		return voted
+	}
+	vote("alice", 1)
+	vote("alice", 1)
+	voted := vote("bob", 1)
+	assert.Equal(t, 2, voted.Upvotes)
+	voted = vote("alice", -1)
+	assert.Equal(t, []int{1, 1}, []int{voted.Upvotes, voted.Downvotes})
+	assert.Equal(t, 0, voted.Score())
+
+	value, err := s.GetUserVote(ctx, comment.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, -1, value)
+	voted = vote("alice", 0)
+	assert.Equal(t, []int{1, 0}, []int{voted.Upvotes, voted.Downvotes})
+	value, err = s.GetUserVote(ctx, comment.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 0, value)
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Comments, 1)
+	assert.Equal(t, 1, got.Comments[0].Upvotes)
+
+	_, err = s.Vote(ctx, &model.Vote{CommentID: 4242, User: "alice", Value: 1})
+	assert.Error(t, err)
+	_, err = s.Vote(ctx, &model.Vote{CommentID: comment.ID, User: "alice", Value: 2})
+	assert.Error(t, err)
+	_, err = s.GetUserVote(ctx, 4242, "alice")
+	assert.Error(t, err)
+}
+
+func testCommentOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	post := createPost(t, s, "title")
+	a := createComment(t, s, post.ID, nil, "a")
+	b := createComment(t, s, post.ID, nil, "b")
+	c := createComment(t, s, post.ID, nil, "c")
+	a1 := createComment(t, s, post.ID, &a.ID, "a1")
+	a2 := createComment(t, s, post.ID, &a.ID, "a2")
+	createComment(t, s, post.ID, &a2.ID, "a21")
+
+	votes := map[*model.Comment][]int{
+		a:  {1, -1},
+		b:  {1, 1, 1},
+		c:  {1, 1, -1, -1},
+		a2: {1},
+	}
+	for comment, values := range votes {
+		for i, value := range values {
+			_, err := s.Vote(ctx, &model.Vote{CommentID: comment.ID, User: string(rune('a' + i)), Value: value})
+			require.NoError(t, err)
+		}
+	}
+
+	bodies := func(q storage.CommentQuery) []string {
+		t.Helper()
+		q.PostID = post.ID
+		comments, err := s.GetComments(ctx, q)
+		require.NoError(t, err)
+		var bodies []string
+		for _, comment := range comments {
+			bodies = append(bodies, comment.Body)
+		}
+		return bodies
+	}
+	assert.Equal(t, []string{"a", "a1", "a2", "a21", "b", "c"}, bodies(storage.CommentQuery{Order: model.CommentOrderOldest}))
+	assert.Equal(t, []string{"c", "b", "a", "a2", "a21", "a1"}, bodies(storage.CommentQuery{Order: model.CommentOrderNewest}))
+	// ties go to the older comment
+	assert.Equal(t, []string{"b", "a", "a2", "a21", "a1", "c"}, bodies(storage.CommentQuery{Order: model.CommentOrderTop}))
+	assert.Equal(t, []string{"c", "a", "a1", "a2", "a21", "b"}, bodies(storage.CommentQuery{Order: model.CommentOrderControversial}))
+	assert.Len(t, bodies(storage.CommentQuery{Order: model.CommentOrderHot}), 6)
+
+	// keyset pages over the top-level comments
+	assert.Equal(t, []string{"b"}, bodies(storage.CommentQuery{Order: model.CommentOrderTop, Limit: 1}))
+	assert.Equal(t, []string{"a", "a2", "a21", "a1"}, bodies(storage.CommentQuery{Order: model.CommentOrderTop, After: &b.ID, Limit: 1}))
+	assert.Equal(t, []string{"c"}, bodies(storage.CommentQuery{Order: model.CommentOrderTop, After: &a.ID}))
+	assert.Empty(t, bodies(storage.CommentQuery{Order: model.CommentOrderOldest, After: &c.ID}))
+	assert.Equal(t, []string{"b", "c"}, bodies(storage.CommentQuery{Order: model.CommentOrderOldest, After: &a.ID}))
+
+	_, err := s.GetComments(ctx, storage.CommentQuery{PostID: post.ID, Order: model.CommentOrderTop, After: &a1.ID})
+	assert.Error(t, err, "after must be a top-level comment")
+	_, err = s.GetComments(ctx, storage.CommentQuery{PostID: 4242, Order: model.CommentOrderTop})
+	assert.Error(t, err)
+}
+
+func testMentions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	users, err := s.ResolveUsers(ctx, []string{"alice"})
+	require.NoError(t, err)
+	assert.Empty(t, users)
+
+	post := &model.Post{Title: "title", Author: "Alice"}
+	require.NoError(t, s.CreatePost(ctx, post))
+	comment := &model.Comment{PostID: post.ID, Body: "@alice @bob", Author: "bob", Mentions: []string{"Alice", "bob"}}
+	require.NoError(t, s.CreateComment(ctx, comment))
+	reply := &model.Comment{PostID: post.ID, ParentID: &comment.ID, Body: "hi", Author: "alice"}
+	require.NoError(t, s.CreateComment(ctx, reply))
+	createComment(t, s, post.ID, nil, "anonymous")
+
+	users, err = s.ResolveUsers(ctx, []string{"BOB", "nobody", "alice", "ALICE", ""})
+	require.NoError(t, err)
+	// a user spelled the same wins over others matching regardless of case
+	assert.Equal(t, []string{"bob", "alice", "Alice"}, users)
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", got.Author)
+	assert.Empty(t, got.FilterProfile)
+	require.Len(t, got.Comments, 3)
+	assert.Equal(t, "bob", got.Comments[0].Author)
+	assert.Equal(t, []string{"Alice", "bob"}, got.Comments[0].Mentions)
+	assert.Empty(t, got.Comments[1].Mentions)
+	assert.Empty(t, got.Comments[2].Author)
+
+	comments, err := s.GetComments(ctx, storage.CommentQuery{PostID: post.ID, Order: model.CommentOrderTop})
+	require.NoError(t, err)
+	require.Len(t, comments, 3)
+	assert.Equal(t, []string{"Alice", "bob"}, comments[0].Mentions)
+	voted, err := s.Vote(ctx, &model.Vote{CommentID: comment.ID, User: "carol", Value: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Alice", "bob"}, voted.Mentions)
+	assert.Equal(t, "bob", voted.Author)
+	one, err := s.GetComment(ctx, comment.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Alice", "bob"}, one.Mentions)
+	assert.Equal(t, "bob", one.Author)
+}
+
+func testNotifications(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	post := createPost(t, s, "title")
+	comment := createComment(t, s, post.ID, nil, "@alice")
+
+	notify := func(user string) *model.Notification {
+		t.Helper()
+		notification := &model.Notification{
+			User:      user,
+			Kind:      model.NotificationKindMention,
+			PostID:    post.ID,
+			CommentID: &comment.ID,
+			Actor:     "bob",
+		}
+		require.NoError(t, s.CreateNotification(ctx, notification))
+		require.NotZero(t, notification.ID)
+		return notification
+	}
+	first := notify("alice")
+	notify("carol")
+	second := notify("alice")
+	third := notify("alice")
+
+	ids := func(q storage.NotificationQuery) []int {
+		t.Helper()
+		notifications, err := s.GetNotifications(ctx, q)
+		require.NoError(t, err)
+		ids := []int{}
+		for _, notification := range notifications {
+			ids = append(ids, notification.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int{third.ID, second.ID, first.ID}, ids(storage.NotificationQuery{User: "alice"}))
+	assert.Equal(t, []int{third.ID, second.ID}, ids(storage.NotificationQuery{User: "alice", Limit: 2}))
+	assert.Equal(t, []int{first.ID}, ids(storage.NotificationQuery{User: "alice", After: &second.ID, Limit: 2}))
+	assert.Empty(t, ids(storage.NotificationQuery{User: "nobody"}))
+
+	notifications, err := s.GetNotifications(ctx, storage.NotificationQuery{User: "alice", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	got := notifications[0]
+	assert.Equal(t, model.NotificationKindMention, got.Kind)
+	assert.Equal(t, post.ID, got.PostID)
+	require.NotNil(t, got.CommentID)
+	assert.Equal(t, comment.ID, *got.CommentID)
+	assert.Equal(t, "bob", got.Actor)
+	assert.False(t, got.Read)
+	assert.WithinDuration(t, third.CreatedAt, got.CreatedAt, time.Millisecond)
+
+	marked, err := s.MarkNotificationsRead(ctx, "alice", []int{second.ID, third.ID, 4242})
+	require.NoError(t, err)
+	assert.Equal(t, 2, marked)
+	marked, err = s.MarkNotificationsRead(ctx, "carol", []int{first.ID})
+	require.NoError(t, err)
+	assert.Zero(t, marked, "other users' notifications are left alone")
+	marked, err = s.MarkNotificationsRead(ctx, "alice", []int{})
+	require.NoError(t, err)
+	assert.Zero(t, marked)
+	assert.Equal(t, []int{first.ID}, ids(storage.NotificationQuery{User: "alice", UnreadOnly: true}))
+	assert.Equal(t, []int{}, ids(storage.NotificationQuery{User: "alice", After: &first.ID, UnreadOnly: true}))
+
+	marked, err = s.MarkNotificationsRead(ctx, "alice", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, marked)
+	assert.Empty(t, ids(storage.NotificationQuery{User: "alice", UnreadOnly: true}))
+	assert.Len(t, ids(storage.NotificationQuery{User: "carol", UnreadOnly: true}), 1)
+	notifications, err = s.GetNotifications(ctx, storage.NotificationQuery{User: "alice", Limit: 1})
+	require.NoError(t, err)
+	assert.True(t, notifications[0].Read)
+
+	missing := 4242
+	err = s.CreateNotification(ctx, &model.Notification{User: "alice", Kind: model.NotificationKindMention, PostID: post.ID, CommentID: &missing})
+	assert.Error(t, err)
+	err = s.CreateNotification(ctx, &model.Notification{User: "alice", Kind: "UNKNOWN", PostID: post.ID})
+	assert.Error(t, err)
+}
+
+func testModeration(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	post := createPost(t, s, "title")
+	alice := &model.Comment{PostID: post.ID, Body: "first", Author: "alice"}
+	require.NoError(t, s.CreateComment(ctx, alice))
+	bob := &model.Comment{PostID: post.ID, Body: "@carol second", Author: "bob", Mentions: []string{"carol"}}
+	require.NoError(t, s.CreateComment(ctx, bob))
+	anonymous := createComment(t, s, post.ID, nil, "third")
+
+	report := func(comment *model.Comment, reporter, reason string) *model.Report {
+		t.Helper()
+		report := &model.Report{CommentID: comment.ID, Reporter: reporter, Reason: reason}
+		require.NoError(t, s.ReportComment(ctx, report))
+		require.False(t, report.CreatedAt.IsZero())
+		return report
+	}
+	first := report(bob, "x", "spam")
+	report(alice, "x", "spam")
+	report(alice, "y", "off topic")
+	report(bob, "y", "spam")
+	report(bob, "z", "spam")
+	again := report(bob, "x", "rude")
+	assert.WithinDuration(t, first.CreatedAt, again.CreatedAt, time.Millisecond, "reporting again keeps the report")
+
+	queued := func(limit int) []int {
+		t.Helper()
+		queue, err := s.GetModerationQueue(ctx, limit)
+		require.NoError(t, err)
+		ids := []int{}
+		for _, item := range queue {
+			ids = append(ids, item.Comment.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int{bob.ID, alice.ID}, queued(0))
+	assert.Equal(t, []int{bob.ID}, queued(1))
+
+	queue, err := s.GetModerationQueue(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, "bob", queue[0].Comment.Author)
+	assert.Equal(t, []string{"carol"}, queue[0].Comment.Mentions)
+	require.Len(t, queue[0].Reports, 3)
+	assert.Equal(t, "x", queue[0].Reports[0].Reporter)
+	assert.Equal(t, "rude", queue[0].Reports[0].Reason)
+	assert.Equal(t, bob.ID, queue[0].Reports[0].CommentID)
+
+	assert.Error(t, s.ReportComment(ctx, &model.Report{CommentID: 4242, Reporter: "x", Reason: "spam"}))
+
+	dismissed, closed, err := s.ResolveReports(ctx, alice.ID, model.ModerationActionDismiss)
+	require.NoError(t, err)
+	assert.False(t, dismissed.Hidden)
+	assert.Equal(t, 2, closed)
+	assert.Equal(t, []int{bob.ID}, queued(0))
+
+	banned, closed, err := s.ResolveReports(ctx, bob.ID, model.ModerationActionBan)
+	require.NoError(t, err)
+	assert.True(t, banned.Hidden)
+	assert.Equal(t, 3, closed, "reporting again replaces the report")
+	assert.Equal(t, "@carol second", banned.Body)
+	assert.Equal(t, []string{"carol"}, banned.Mentions)
+	assert.Empty(t, queued(0))
+	isBanned := func(user string) bool {
+		t.Helper()
+		banned, err := s.IsBanned(ctx, user)
+		require.NoError(t, err)
+		return banned
+	}
+	assert.True(t, isBanned("bob"))
+	assert.False(t, isBanned("alice"))
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Comments, 3)
+	assert.False(t, got.Comments[0].Hidden)
+	assert.True(t, got.Comments[1].Hidden)
+	assert.Error(t, s.ReportComment(ctx, &model.Report{CommentID: bob.ID, Reporter: "x", Reason: "spam"}), "hidden comments can't be reported")
+
+	hidden, closed, err := s.ResolveReports(ctx, alice.ID, model.ModerationActionHide)
+	require.NoError(t, err)
+	assert.True(t, hidden.Hidden)
+	assert.Zero(t, closed, "the reports were dismissed already")
+	assert.False(t, isBanned("alice"))
+
+	hidden, _, err = s.ResolveReports(ctx, anonymous.ID, model.ModerationActionBan)
+	require.NoError(t, err)
+	assert.True(t, hidden.Hidden)
+	assert.False(t, isBanned(""), "anonymous comments hide but ban nobody")
+
+	_, _, err = s.ResolveReports(ctx, 4242, model.ModerationActionHide)
+	assert.Error(t, err)
+	_, _, err = s.ResolveReports(ctx, alice.ID, "DELETE")
+	assert.Error(t, err)
+}
+
+func testSpamModel(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	m, err := s.GetSpamModel(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, m.Spam)
+	assert.Zero(t, m.Ham)
+	assert.Empty(t, m.Tokens)
+
+	require.NoError(t, s.TrainSpam(ctx, []string{"buy", "now"}, true))
+	require.NoError(t, s.TrainSpam(ctx, []string{"hello", "now"}, false))
+	require.NoError(t, s.TrainSpam(ctx, []string{"buy"}, true))
+
+	m, err = s.GetSpamModel(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &model.SpamModel{
+		Spam: 2,
+		Ham:  1,
+		Tokens: map[string]model.TokenCounts{
+			"buy":   {Spam: 2},
+			"now":   {Spam: 1, Ham: 1},
+			"hello": {Ham: 1},
+		},
+	}, m)
+}
+
+func testTags(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	first := &model.Post{Title: "first", Body: "body", Tags: []string{"go", "news"}}
+	require.NoError(t, s.CreatePost(ctx, first))
+	second := &model.Post{Title: "second", Body: "body", Tags: []string{"go"}}
+	require.NoError(t, s.CreatePost(ctx, second))
+	untagged := createPost(t, s, "untagged")
+	createComment(t, s, first.ID, nil, "comment")
+
+	got, err := s.GetPost(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go", "news"}, got.Tags)
+	posts, err := s.GetPosts(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 3)
+	assert.Equal(t, []string{"go"}, posts[1].Tags)
+	assert.Empty(t, posts[2].Tags)
+
+	postIDs := func(tag string) []int {
+		t.Helper()
+		posts, err := s.GetPostsByTag(ctx, tag)
+		require.NoError(t, err)
+		ids := []int{}
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+		}
+		return ids
+	}
+	tagCounts := func() []model.TagCount {
+		t.Helper()
+		tags, err := s.GetTags(ctx)
+		require.NoError(t, err)
+		counts := []model.TagCount{}
+		for _, tag := range tags {
+			counts = append(counts, *tag)
+		}
+		return counts
+	}
+	assert.Equal(t, []int{first.ID, second.ID}, postIDs("go"))
+	assert.Equal(t, []int{}, postIDs("missing"))
+	assert.Equal(t, []model.TagCount{{Tag: "go", Count: 2}, {Tag: "news", Count: 1}}, tagCounts())
+
+	tags := []string{"news", "rust"}
+	updated, err := s.UpdatePost(ctx, second.ID, storage.PostUpdate{Tags: &tags})
+	require.NoError(t, err)
+	assert.Equal(t, tags, updated.Tags)
+	assert.Equal(t, "second", updated.Title)
+	assert.False(t, updated.UpdatedAt.Before(second.UpdatedAt))
+
+	title, body := "renamed", "new body"
+	updated, err = s.UpdatePost(ctx, first.ID, storage.PostUpdate{Title: &title, Body: &body})
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", updated.Title)
+	assert.Equal(t, "new body", updated.Body)
+	assert.Equal(t, []string{"go", "news"}, updated.Tags, "tags are kept unless given")
+	assert.Len(t, updated.Comments, 1)
+	assert.Equal(t, []model.TagCount{{Tag: "news", Count: 2}, {Tag: "go", Count: 1}, {Tag: "rust", Count: 1}}, tagCounts())
+
+	none := []string{}
+	_, err = s.UpdatePost(ctx, first.ID, storage.PostUpdate{Tags: &none})
+	require.NoError(t, err)
+	assert.Equal(t, []int{}, postIDs("go"))
+	assert.Equal(t, []int{second.ID}, postIDs("news"))
+	assert.Equal(t, []model.TagCount{{Tag: "news", Count: 1}, {Tag: "rust", Count: 1}}, tagCounts())
+
+	_, err = s.UpdatePost(ctx, untagged.ID+100, storage.PostUpdate{Title: &title})
+	assert.Error(t, err)
+}
+
+func testConcurrency(t *testing.T, s storage.Storage) {
+	const writers, perWriter = 8, 10
+	ctx := context.Background()
+	post := createPost(t, s, "title")
+
+	var wg sync.WaitGroup
+	ids := make(chan int, writers*perWriter)
+	errs := make(chan error, writers*perWriter)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWriter; j++ {
+				comment := &model.Comment{PostID: post.ID, Body: "body"}
+				if err := s.CreateComment(ctx, comment); err != nil {
+					errs <- err
+					continue
+				}
+				ids <- comment.ID
+				if _, err := s.GetPost(ctx, post.ID); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	seen := map[int]bool{}
+	for id := range ids {
+		assert.False(t, seen[id], "duplicate comment id %d", id)
+		seen[id] = true
+	}
+	assert.Len(t, seen, writers*perWriter)
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Comments, writers*perWriter)
+	for i := 1; i < len(got.Comments); i++ {
+		assert.Less(t, got.Comments[i-1].ID, got.Comments[i].ID)
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ErrCorrupt is returned when a snapshot or log record fails its checksum.
+var ErrCorrupt = errors.New("storage file is corrupt")
+
+// FsyncPolicy decides when appends to the write-ahead log reach the disk.
+type FsyncPolicy int
+
+const (
+	// FsyncAlways syncs every write before it is acknowledged.
+	FsyncAlways FsyncPolicy = iota
+	// FsyncInterval syncs in the background, so a crash loses at most the
+	// last interval of writes.
+	FsyncInterval
+	// FsyncNever leaves flushing to the operating system.
+	FsyncNever
+)
+
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch s {
+	case "", "always":
+		return FsyncAlways, nil
+	case "interval":
+		return FsyncInterval, nil
+	case "never":
+		return FsyncNever, nil
+	}
+	return 0, fmt.Errorf("unknown fsync policy %q", s)
+}
+
+func (p *FsyncPolicy) UnmarshalText(text []byte) error {
+	policy, err := ParseFsyncPolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+func (p FsyncPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncInterval:
+		return "interval"
+	case FsyncNever:
+		return "never"
+	}
+	return "always"
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// frameHeader is the payload length, the CRC-32C of the length and the
+// CRC-32C of the payload. The length has a checksum of its own so that a
+// corrupted one is told apart from a frame cut short.
+const frameHeader = 12
+
+// maxFrameSize bounds the payloads, so that a length gone wrong can't make
+// readFrame allocate gigabytes.
+const maxFrameSize = 256 << 20
+
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("record of %d bytes is larger than %d", len(payload), maxFrameSize)
+	}
+	buf := make([]byte, frameHeader+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(buf[0:4], crcTable))
+	binary.LittleEndian.PutUint32(buf[8:12], crc32.Checksum(payload, crcTable))
+	copy(buf[frameHeader:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFrame returns io.EOF at a clean end, io.ErrUnexpectedEOF when the
+// frame was cut short, as by a crash during a write, and ErrCorrupt when
+// a checksum doesn't match or the length is out of bounds.
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, frameHeader)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(header[0:4], crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, ErrCorrupt
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxFrameSize {
+		return nil, ErrCorrupt
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[8:12]) {
+		return nil, ErrCorrupt
+	}
+	return payload, nil
+}
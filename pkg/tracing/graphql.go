@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer is a gqlgen handler extension that creates a span for every
+// operation (every event for subscriptions) and a child span for every
+// field backed by a resolver.
+type Tracer struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+	graphql.FieldInterceptor
+} = Tracer{}
+
+func (Tracer) ExtensionName() string {
+	return "Tracing"
+}
+
+func (Tracer) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (Tracer) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	oc := graphql.GetOperationContext(ctx)
+	if oc.Operation == nil {
+		return next(ctx)
+	}
+
+	name := oc.Operation.Name
+	if name == "" {
+		name = "anonymous"
+	}
+	opts := []trace.SpanStartOption{
+		trace.WithAttributes(
+			attribute.String("graphql.operation.type", string(oc.Operation.Operation)),
+			attribute.String("graphql.operation.name", name),
+		),
+	}
+	if !oc.Stats.OperationStart.IsZero() {
+		opts = append(opts, trace.WithTimestamp(oc.Stats.OperationStart))
+	}
+	ctx, span := tracer().Start(ctx, string(oc.Operation.Operation)+" "+name, opts...)
+
+	resp := next(ctx)
+	var err error
+	if resp != nil && len(resp.Errors) > 0 {
+		err = resp.Errors
+	}
+	finish(span, err)
+	return resp
+}
+
+func (Tracer) InterceptField(ctx context.Context, next graphql.Resolver) (any, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver {
+		return next(ctx)
+	}
+
+	ctx, span := tracer().Start(ctx, fc.Object+"."+fc.Field.Name,
+		trace.WithAttributes(attribute.String("graphql.field.path", fc.Path().String())),
+	)
+	res, err := next(ctx)
+	finish(span, err)
+	return res, err
+}
@@ -0,0 +1,246 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"post-comments/pkg/model"
+	"post-comments/pkg/storage"
+)
+
+// Storage wraps every call to the wrapped storage in a span.
+type Storage struct {
+	next storage.Storage
+}
+
+func NewStorage(next storage.Storage) *Storage {
+	return &Storage{next: next}
+}
+
+func start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "storage."+method, trace.WithAttributes(attrs...))
+}
+
+func (s *Storage) CreatePost(ctx context.Context, post *model.Post) error {
+	ctx, span := start(ctx, "CreatePost")
+	err := s.next.CreatePost(ctx, post)
+	finish(span, err)
+	return err
+}
+
+func (s *Storage) GetPosts(ctx context.Context) ([]*model.Post, error) {
+	ctx, span := start(ctx, "GetPosts")
+	posts, err := s.next.GetPosts(ctx)
+	span.SetAttributes(attribute.Int("posts.count", len(posts)))
+	finish(span, err)
+	return posts, err
+}
+
+func (s *Storage) GetPost(ctx context.Context, id int) (*model.Post, error) {
+	ctx, span := start(ctx, "GetPost", attribute.Int("post.id", id))
+	post, err := s.next.GetPost(ctx, id)
+	finish(span, err)
+	return post, err
+}
+
+func (s *Storage) GetPostHeader(ctx context.Context, id int) (*model.Post, error) {
+	ctx, span := start(ctx, "GetPostHeader", attribute.Int("post.id", id))
+	post, err := s.next.GetPostHeader(ctx, id)
+	finish(span, err)
+	return post, err
+}
+
+func (s *Storage) UpdatePost(ctx context.Context, id int, update storage.PostUpdate) (*model.Post, error) {
+	ctx, span := start(ctx, "UpdatePost", attribute.Int("post.id", id))
+	post, err := s.next.UpdatePost(ctx, id, update)
+	finish(span, err)
+	return post, err
+}
+
+func (s *Storage) GetPostsByTag(ctx context.Context, tag string) ([]*model.Post, error) {
+	ctx, span := start(ctx, "GetPostsByTag", attribute.String("post.tag", tag))
+	posts, err := s.next.GetPostsByTag(ctx, tag)
+	span.SetAttributes(attribute.Int("posts.count", len(posts)))
+	finish(span, err)
+	return posts, err
+}
+
+func (s *Storage) GetTags(ctx context.Context) ([]*model.TagCount, error) {
+	ctx, span := start(ctx, "GetTags")
+	tags, err := s.next.GetTags(ctx)
+	span.SetAttributes(attribute.Int("tags.count", len(tags)))
+	finish(span, err)
+	return tags, err
+}
+
+func (s *Storage) CreateComment(ctx context.Context, comment *model.Comment) error {
+	ctx, span := start(ctx, "CreateComment", attribute.Int("post.id", comment.PostID))
+	err := s.next.CreateComment(ctx, comment)
+	finish(span, err)
+	return err
+}
+
+func (s *Storage) DisableComments(ctx context.Context, postID int) (*model.Post, error) {
+	ctx, span := start(ctx, "DisableComments", attribute.Int("post.id", postID))
+	post, err := s.next.DisableComments(ctx, postID)
+	finish(span, err)
+	return post, err
+}
+
+func (s *Storage) EnableComments(ctx context.Context, postID int) (*model.Post, error) {
+	ctx, span := start(ctx, "EnableComments", attribute.Int("post.id", postID))
+	post, err := s.next.EnableComments(ctx, postID)
+	finish(span, err)
+	return post, err
+}
+
+func targetAttrs(postID int, commentID *int) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.Int("post.id", postID)}
+	if commentID != nil {
+		attrs = append(attrs, attribute.Int("comment.id", *commentID))
+	}
+	return attrs
+}
+
+func (s *Storage) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	ctx, span := start(ctx, "AddReaction", targetAttrs(reaction.PostID, reaction.CommentID)...)
+	added, err := s.next.AddReaction(ctx, reaction)
+	finish(span, err)
+	return added, err
+}
+
+func (s *Storage) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	ctx, span := start(ctx, "RemoveReaction", targetAttrs(reaction.PostID, reaction.CommentID)...)
+	removed, err := s.next.RemoveReaction(ctx, reaction)
+	finish(span, err)
+	return removed, err
+}
+
+func (s *Storage) GetReactionCounts(ctx context.Context, postID int, commentID *int) ([]*model.ReactionCount, error) {
+	ctx, span := start(ctx, "GetReactionCounts", targetAttrs(postID, commentID)...)
+	counts, err := s.next.GetReactionCounts(ctx, postID, commentID)
+	finish(span, err)
+	return counts, err
+}
+
+func (s *Storage) GetUserReactions(ctx context.Context, postID int, commentID *int, user string) ([]string, error) {
+	ctx, span := start(ctx, "GetUserReactions", targetAttrs(postID, commentID)...)
+	emojis, err := s.next.GetUserReactions(ctx, postID, commentID, user)
+	finish(span, err)
+	return emojis, err
+}
+
+func (s *Storage) Vote(ctx context.Context, vote *model.Vote) (*model.Comment, error) {
+	ctx, span := start(ctx, "Vote", attribute.Int("comment.id", vote.CommentID))
+	comment, err := s.next.Vote(ctx, vote)
+	finish(span, err)
+	return comment, err
+}
+
+func (s *Storage) GetUserVote(ctx context.Context, commentID int, user string) (int, error) {
+	ctx, span := start(ctx, "GetUserVote", attribute.Int("comment.id", commentID))
+	value, err := s.next.GetUserVote(ctx, commentID, user)
+	finish(span, err)
+	return value, err
+}
+
+func (s *Storage) GetComments(ctx context.Context, q storage.CommentQuery) ([]*model.Comment, error) {
+	ctx, span := start(ctx, "GetComments", attribute.Int("post.id", q.PostID), attribute.String("comments.order", string(q.Order)))
+	comments, err := s.next.GetComments(ctx, q)
+	span.SetAttributes(attribute.Int("comments.count", len(comments)))
+	finish(span, err)
+	return comments, err
+}
+
+func (s *Storage) GetComment(ctx context.Context, id int) (*model.Comment, error) {
+	ctx, span := start(ctx, "GetComment", attribute.Int("comment.id", id))
+	comment, err := s.next.GetComment(ctx, id)
+	finish(span, err)
+	return comment, err
+}
+
+func (s *Storage) GetCommentDepth(ctx context.Context, id int) (int, error) {
+	ctx, span := start(ctx, "GetCommentDepth", attribute.Int("comment.id", id))
+	depth, err := s.next.GetCommentDepth(ctx, id)
+	finish(span, err)
+	return depth, err
+}
+
+func (s *Storage) ResolveUsers(ctx context.Context, names []string) ([]string, error) {
+	ctx, span := start(ctx, "ResolveUsers", attribute.Int("users.requested", len(names)))
+	users, err := s.next.ResolveUsers(ctx, names)
+	span.SetAttributes(attribute.Int("users.count", len(users)))
+	finish(span, err)
+	return users, err
+}
+
+func (s *Storage) CreateNotification(ctx context.Context, notification *model.Notification) error {
+	ctx, span := start(ctx, "CreateNotification", append(targetAttrs(notification.PostID, notification.CommentID),
+		attribute.String("notification.kind", string(notification.Kind)))...)
+	err := s.next.CreateNotification(ctx, notification)
+	finish(span, err)
+	return err
+}
+
+func (s *Storage) GetNotifications(ctx context.Context, q storage.NotificationQuery) ([]*model.Notification, error) {
+	ctx, span := start(ctx, "GetNotifications", attribute.Bool("notifications.unread_only", q.UnreadOnly))
+	notifications, err := s.next.GetNotifications(ctx, q)
+	span.SetAttributes(attribute.Int("notifications.count", len(notifications)))
+	finish(span, err)
+	return notifications, err
+}
+
+func (s *Storage) MarkNotificationsRead(ctx context.Context, user string, ids []int) (int, error) {
+	ctx, span := start(ctx, "MarkNotificationsRead", attribute.Bool("notifications.all", ids == nil))
+	marked, err := s.next.MarkNotificationsRead(ctx, user, ids)
+	span.SetAttributes(attribute.Int("notifications.marked", marked))
+	finish(span, err)
+	return marked, err
+}
+
+func (s *Storage) ReportComment(ctx context.Context, report *model.Report) error {
+	ctx, span := start(ctx, "ReportComment", attribute.Int("comment.id", report.CommentID))
+	err := s.next.ReportComment(ctx, report)
+	finish(span, err)
+	return err
+}
+
+func (s *Storage) GetModerationQueue(ctx context.Context, limit int) ([]*model.ReportedComment, error) {
+	ctx, span := start(ctx, "GetModerationQueue", attribute.Int("reports.limit", limit))
+	queue, err := s.next.GetModerationQueue(ctx, limit)
+	span.SetAttributes(attribute.Int("comments.count", len(queue)))
+	finish(span, err)
+	return queue, err
+}
+
+func (s *Storage) ResolveReports(ctx context.Context, commentID int, action model.ModerationAction) (*model.Comment, int, error) {
+	ctx, span := start(ctx, "ResolveReports", attribute.Int("comment.id", commentID), attribute.String("moderation.action", string(action)))
+	comment, closed, err := s.next.ResolveReports(ctx, commentID, action)
+	span.SetAttributes(attribute.Int("reports.count", closed))
+	finish(span, err)
+	return comment, closed, err
+}
+
+func (s *Storage) IsBanned(ctx context.Context, user string) (bool, error) {
+	ctx, span := start(ctx, "IsBanned")
+	banned, err := s.next.IsBanned(ctx, user)
+	finish(span, err)
+	return banned, err
+}
+
+func (s *Storage) TrainSpam(ctx context.Context, tokens []string, spam bool) error {
+	ctx, span := start(ctx, "TrainSpam", attribute.Int("tokens.count", len(tokens)), attribute.Bool("spam", spam))
+	err := s.next.TrainSpam(ctx, tokens, spam)
+	finish(span, err)
+	return err
+}
+
+func (s *Storage) GetSpamModel(ctx context.Context) (*model.SpamModel, error) {
+	ctx, span := start(ctx, "GetSpamModel")
+	m, err := s.next.GetSpamModel(ctx)
+	if m != nil {
+		span.SetAttributes(attribute.Int("tokens.count", len(m.Tokens)))
+	}
+	finish(span, err)
+	return m, err
+}
@@ -0,0 +1,37 @@
+// Package viewer tells who is making a request.
+package viewer
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Header carries the name of the signed-in user. The server doesn't
+// authenticate anyone itself: the header is expected to be set by the
+// authenticating proxy in front of it, which must drop it from client
+// requests.
+const Header = "X-User"
+
+type ctxKey struct{}
+
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, user)
+}
+
+// User returns the signed-in user, or "" for anonymous requests.
+func User(ctx context.Context) string {
+	user, _ := ctx.Value(ctxKey{}).(string)
+	return user
+}
+
+// Middleware takes the user from Header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := strings.TrimSpace(r.Header.Get(Header))
+		if user != "" {
+			r = r.WithContext(WithUser(r.Context(), user))
+		}
+		next.ServeHTTP(w, r)
+	})
+}